@@ -1,11 +1,16 @@
 package identity
 
 import (
+	"bytes"
 	"encoding/base64"
 	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
 	"sort"
+	"strings"
 
-	"github.com/iancoleman/orderedmap"
+	"github.com/TankerHQ/identity-go/v3/internal/ordered"
 )
 
 var (
@@ -25,54 +30,287 @@ var (
 )
 
 func keySort(keys []string) {
-	sort.Slice(keys, func(i, j int) bool {
+	sort.SliceStable(keys, func(i, j int) bool {
 		return keyIndexes[keys[i]] < keyIndexes[keys[j]]
 	})
 }
 
 // Encode returns a pointer to the base64 representation of the result of
-// marshalling v in JSON. If an error occurs in the process, it is returned.
-// Under the hood, Encode transforms v into a *orderedmap.OrderedMap so
-// the result will always wrap a key-sorted JSON representation. If v's
-// underlying type is already *orderedmap.OrderedMap, v's wrapped value
-// will be used as is.
+// marshalling v in JSON. If an error occurs in the process, it is returned
+// wrapped in ErrEncoding.
+// The fields of the resulting JSON object are always sorted in the order
+// of the Tanker identity format, fields unknown to it coming first. v must
+// therefore marshal to a JSON object. Identity types are written directly
+// in that order, other values are marshalled then reordered.
 func Encode(v interface{}) (*string, error) {
-	orderedMap, isOrderedMap := v.(*orderedmap.OrderedMap)
+	buf, err := appendCanonicalJSON(make([]byte, 0, 512), v)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrEncoding, err)
+	}
+	b64Encoded := base64.StdEncoding.EncodeToString(buf)
+	return &b64Encoded, nil
+}
 
-	if !isOrderedMap {
-		// Note: []byte values are encoded as base64-encoded strings
-		//       (see: https://golang.org/pkg/encoding/json/#Marshal)
-		buf, err := json.Marshal(v)
-		if err != nil {
-			return nil, err
+func appendCanonicalJSON(buf []byte, v interface{}) ([]byte, error) {
+	// only the exact identity types are written directly, since a type
+	// embedding one of them may add fields of its own
+	switch v := v.(type) {
+	case SecretPermanentIdentity:
+		return v.appendJSON(buf), nil
+	case *SecretPermanentIdentity:
+		if v != nil {
+			return v.appendJSON(buf), nil
 		}
-
-		// Struct fields are marshalled in order of declaration, but we can't easily change the order
-		// OrderedMap fields are always marshalled in order, so we bounce through it
-		orderedMap = orderedmap.New()
-		err = json.Unmarshal(buf, orderedMap)
-		if err != nil {
-			return nil, err
+	case SecretProvisionalIdentity:
+		return v.appendJSON(buf), nil
+	case *SecretProvisionalIdentity:
+		if v != nil {
+			return v.appendJSON(buf), nil
+		}
+	case PublicPermanentIdentity:
+		return v.appendJSON(buf), nil
+	case *PublicPermanentIdentity:
+		if v != nil {
+			return v.appendJSON(buf), nil
+		}
+	case PublicProvisionalIdentity:
+		return v.appendJSON(buf), nil
+	case *PublicProvisionalIdentity:
+		if v != nil {
+			return v.appendJSON(buf), nil
 		}
+	case *publicIdentity:
+		if v != nil {
+			return v.appendJSON(buf), nil
+		}
+	case *anyPublicIdentity:
+		if v != nil {
+			return v.appendJSON(buf), nil
+		}
+	case *ordered.Map:
+		v.SortKeys(keySort)
+		return v.AppendJSON(buf)
 	}
-	orderedMap.SortKeys(keySort)
-	orderedJson, err := json.Marshal(orderedMap)
+
+	// Note: []byte values are encoded as base64-encoded strings
+	//       (see: https://golang.org/pkg/encoding/json/#Marshal)
+	marshalled, err := json.Marshal(v)
 	if err != nil {
 		return nil, err
 	}
+	decoded := ordered.New()
+	if err := json.Unmarshal(marshalled, decoded); err != nil {
+		return nil, err
+	}
+	decoded.SortKeys(keySort)
+	return decoded.AppendJSON(buf)
+}
 
-	b64Encoded := base64.StdEncoding.EncodeToString(orderedJson)
-	return &b64Encoded, nil
+// objectWriter writes the fields of a JSON object in the order they are
+// given, which must be the keyIndexes order
+type objectWriter struct {
+	buf    []byte
+	fields int
 }
 
+func (w *objectWriter) key(key string) {
+	if w.fields == 0 {
+		w.buf = append(w.buf, '{')
+	} else {
+		w.buf = append(w.buf, ',')
+	}
+	w.fields++
+	w.buf = ordered.AppendString(w.buf, key)
+	w.buf = append(w.buf, ':')
+}
+
+func (w *objectWriter) string(key string, value string) {
+	w.key(key)
+	w.buf = ordered.AppendString(w.buf, value)
+}
+
+func (w *objectWriter) bytes(key string, value []byte) {
+	w.key(key)
+	if value == nil {
+		w.buf = append(w.buf, "null"...)
+		return
+	}
+	w.buf = append(w.buf, '"')
+	w.buf = base64.StdEncoding.AppendEncode(w.buf, value)
+	w.buf = append(w.buf, '"')
+}
+
+// optionalBytes is bytes for omitempty fields
+func (w *objectWriter) optionalBytes(key string, value []byte) {
+	if len(value) > 0 {
+		w.bytes(key, value)
+	}
+}
+
+func (w *objectWriter) end() []byte {
+	if w.fields == 0 {
+		w.buf = append(w.buf, '{')
+	}
+	return append(w.buf, '}')
+}
+
+func (i *publicIdentity) writeFields(w *objectWriter) {
+	w.bytes("trustchain_id", i.TrustchainID)
+	w.string("target", i.Target)
+	w.string("value", i.Value)
+}
+
+func (i *publicIdentity) appendJSON(buf []byte) []byte {
+	w := objectWriter{buf: buf}
+	i.writeFields(&w)
+	return w.end()
+}
+
+func (i *anyPublicIdentity) appendJSON(buf []byte) []byte {
+	w := objectWriter{buf: buf}
+	i.writeFields(&w)
+	w.optionalBytes("public_encryption_key", i.PublicEncryptionKey)
+	w.optionalBytes("public_signature_key", i.PublicSignatureKey)
+	return w.end()
+}
+
+func (i *PublicPermanentIdentity) appendJSON(buf []byte) []byte {
+	return i.publicIdentity.appendJSON(buf)
+}
+
+func (i *SecretPermanentIdentity) appendJSON(buf []byte) []byte {
+	w := objectWriter{buf: buf}
+	i.writeFields(&w)
+	w.bytes("delegation_signature", i.DelegationSignature)
+	w.bytes("ephemeral_public_signature_key", i.EphemeralPublicSignatureKey)
+	w.bytes("ephemeral_private_signature_key", i.EphemeralPrivateSignatureKey)
+	w.bytes("user_secret", i.UserSecret)
+	return w.end()
+}
+
+func (i *PublicProvisionalIdentity) appendJSON(buf []byte) []byte {
+	w := objectWriter{buf: buf}
+	i.writeFields(&w)
+	w.bytes("public_encryption_key", i.PublicEncryptionKey)
+	w.bytes("public_signature_key", i.PublicSignatureKey)
+	return w.end()
+}
+
+func (i *SecretProvisionalIdentity) appendJSON(buf []byte) []byte {
+	w := objectWriter{buf: buf}
+	i.writeFields(&w)
+	w.bytes("public_encryption_key", i.PublicEncryptionKey)
+	w.bytes("private_encryption_key", i.PrivateEncryptionKey)
+	w.bytes("public_signature_key", i.PublicSignatureKey)
+	w.bytes("private_signature_key", i.PrivateSignatureKey)
+	return w.end()
+}
+
+// MaxIdentityLength is the maximum length of the base64 identities
+// accepted by the functions of this package, Decode excepted. It is well
+// above the length of the identities this package creates, and protects
+// callers exposing these functions from oversized inputs.
+const MaxIdentityLength = 4096
+
 // Decode takes a value typically returned by Encode, that is,
 // a base64-encoded JSON-marshalled value, and applies the reverse operation,
 // first base64-decoding b64, then unmarshalling the resulting JSON
-// representation into v. If an error occurs on the way, it is returned.
+// representation into v. If an error occurs on the way, it is returned
+// wrapped in ErrMalformedIdentity.
+// Decode does not check the identity it decodes in any way, the functions
+// of this package decoding identities use ParseIdentity instead.
 func Decode(b64 string, v interface{}) error {
 	buf, err := base64.StdEncoding.DecodeString(b64)
 	if err != nil {
+		return fmt.Errorf("%w: %w", ErrMalformedIdentity, err)
+	}
+	if err := json.Unmarshal(buf, v); err != nil {
+		return jsonError(err)
+	}
+	return nil
+}
+
+// decodeBase64Identity base64-decodes b64Identity, refusing identities
+// longer than MaxIdentityLength
+func decodeBase64Identity(b64Identity string) ([]byte, error) {
+	if len(b64Identity) > MaxIdentityLength {
+		return nil, fmt.Errorf("%w: identity is %d bytes long, more than the maximum of %d", ErrMalformedIdentity, len(b64Identity), MaxIdentityLength)
+	}
+	buf, err := base64.StdEncoding.DecodeString(b64Identity)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrMalformedIdentity, err)
+	}
+	return buf, nil
+}
+
+// unmarshalIdentity unmarshals the single JSON value of buf into v,
+// rejecting fields v does not have unless allowUnknown is set, and the
+// keys rejected by checkIdentityKeys
+func unmarshalIdentity(buf []byte, v interface{}, allowUnknown bool) error {
+	if err := checkIdentityKeys(buf, allowUnknown); err != nil {
 		return err
 	}
-	return json.Unmarshal(buf, v)
+	dec := json.NewDecoder(bytes.NewReader(buf))
+	if !allowUnknown {
+		dec.DisallowUnknownFields()
+	}
+	if err := dec.Decode(v); err != nil {
+		return jsonError(err)
+	}
+	if _, err := dec.Token(); err != io.EOF {
+		return fmt.Errorf("%w: unexpected data after the JSON object", ErrMalformedIdentity)
+	}
+	return nil
+}
+
+// checkIdentityKeys rejects the duplicate keys of the JSON object in buf,
+// of which encoding/json keeps the last one, and the keys which only
+// match an identity field regardless of case, which encoding/json
+// accepts. Other SDKs may read such identities differently. Unless
+// allowUnknown is set, keys which are not identity fields are rejected
+// too.
+func checkIdentityKeys(buf []byte, allowUnknown bool) error {
+	dec := json.NewDecoder(bytes.NewReader(buf))
+	if token, err := dec.Token(); err != nil || token != json.Delim('{') {
+		// not an object, which is reported when decoding it
+		return nil
+	}
+	seen := map[string]bool{}
+	for dec.More() {
+		token, err := dec.Token()
+		if err != nil {
+			return jsonError(err)
+		}
+		// object keys are always strings
+		key := token.(string)
+		if seen[key] {
+			return fmt.Errorf("%w: duplicate field %q", ErrMalformedIdentity, key)
+		}
+		seen[key] = true
+		if _, known := keyIndexes[key]; !known {
+			if !allowUnknown {
+				return fmt.Errorf("%w: unknown field %q", ErrMalformedIdentity, key)
+			}
+			for name := range keyIndexes {
+				if strings.EqualFold(name, key) {
+					return fmt.Errorf("%w: field %q should be spelled %q", ErrMalformedIdentity, key, name)
+				}
+			}
+		}
+		var value json.RawMessage
+		if err := dec.Decode(&value); err != nil {
+			return jsonError(err)
+		}
+	}
+	return nil
+}
+
+func jsonError(err error) error {
+	// syntax errors quote the offending character, which may be part
+	// of a private key, so only report its offset
+	var syntaxErr *json.SyntaxError
+	if errors.As(err, &syntaxErr) {
+		return fmt.Errorf("%w: invalid JSON at offset %d", ErrMalformedIdentity, syntaxErr.Offset)
+	}
+	return fmt.Errorf("%w: %w", ErrMalformedIdentity, err)
 }
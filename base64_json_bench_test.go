@@ -4,8 +4,7 @@ import (
 	"testing"
 
 	"github.com/TankerHQ/identity-go/v3"
-
-	"github.com/iancoleman/orderedmap"
+	"github.com/TankerHQ/identity-go/v3/internal/ordered"
 )
 
 type benchStruct struct {
@@ -27,12 +26,24 @@ var (
 		"private_encryption_key": "str",
 	}
 
-	benchOrderedMap = func() *orderedmap.OrderedMap {
-		ordered := orderedmap.New()
+	benchOrderedMap = func() *ordered.Map {
+		m := ordered.New()
 		for k, v := range benchMap {
-			ordered.Set(k, v)
+			m.Set(k, v)
 		}
-		return ordered
+		return m
+	}()
+
+	benchSecretIdentity = func() identity.Identity {
+		secret, _ := identity.Create(validConf, "userID")
+		parsed, _ := identity.ParseIdentity(*secret)
+		return parsed
+	}()
+
+	benchProvisionalIdentity = func() identity.Identity {
+		provisional, _ := identity.CreateProvisional(validConf, "email", "userID")
+		parsed, _ := identity.ParseIdentity(*provisional)
+		return parsed
 	}()
 
 	benchVecs = []struct {
@@ -51,6 +62,14 @@ var (
 			desc: "OrderedMap",
 			data: benchOrderedMap,
 		},
+		{
+			desc: "SecretPermanentIdentity",
+			data: benchSecretIdentity,
+		},
+		{
+			desc: "SecretProvisionalIdentity",
+			data: benchProvisionalIdentity,
+		},
 	}
 )
 
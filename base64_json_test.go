@@ -1,12 +1,12 @@
 package identity_test
 
 import (
+	"encoding/base64"
 	"errors"
 	"testing"
 
 	"github.com/TankerHQ/identity-go/v3"
-
-	"github.com/iancoleman/orderedmap"
+	"github.com/TankerHQ/identity-go/v3/internal/ordered"
 )
 
 // should sort last
@@ -120,14 +120,14 @@ func TestEncodeDecode(t *testing.T) {
 				t.Fatal(vec.desc, "encode failed")
 			}
 
-			ordered := orderedmap.New()
-			err = identity.Decode(*buf, &ordered)
+			decoded := ordered.New()
+			err = identity.Decode(*buf, &decoded)
 			if err != nil {
 				t.Fatal(vec.desc, "decode failed")
 			}
 
 			var previousKey string
-			for i, key := range ordered.Keys() {
+			for i, key := range decoded.Keys() {
 				if i != 0 && order[key] < order[previousKey] {
 					t.Fatalf("%s should sort before %s", previousKey, key)
 				}
@@ -137,6 +137,77 @@ func TestEncodeDecode(t *testing.T) {
 	}
 }
 
+func TestEncode_Canonical(t *testing.T) {
+	vectors := []struct {
+		desc     string
+		data     interface{}
+		expected string
+	}{
+		{
+			desc: "Map",
+			data: map[string]interface{}{
+				"value":         "<b&b>",
+				"target":        "user",
+				"zz":            1.50,
+				"aa":            map[string]interface{}{"z": []interface{}{1e21, nil}, "a": true},
+				"trustchain_id": []byte{1, 2},
+			},
+			expected: `{"aa":{"a":true,"z":[1e+21,null]},"zz":1.5,"trustchain_id":"AQI=","target":"user","value":"\u003cb\u0026b\u003e"}`,
+		},
+		{
+			desc: "Struct",
+			data: struct {
+				PrivateSignatureKey []byte `json:"private_signature_key"`
+				Unknown             string `json:"unknown"`
+				Target              string `json:"target"`
+				UserSecret          []byte `json:"user_secret"`
+			}{Target: "\u00e9\u2028", UserSecret: []byte{0xff}},
+			expected: `{"unknown":"","target":"é\u2028","user_secret":"/w==","private_signature_key":null}`,
+		},
+	}
+
+	for _, vec := range vectors {
+		t.Run(vec.desc, func(t *testing.T) {
+			encoded, err := identity.Encode(vec.data)
+			if err != nil {
+				t.Fatal("error encoding:", err)
+			}
+			if decoded, _ := base64.StdEncoding.DecodeString(*encoded); string(decoded) != vec.expected {
+				t.Fatalf("expected %s, got %s", vec.expected, decoded)
+			}
+		})
+	}
+}
+
+func TestEncode_Identity(t *testing.T) {
+	secret, _ := identity.Create(validConf, "userID")
+	provisional, _ := identity.CreateProvisional(validConf, "email", "userID")
+	public, _ := identity.GetPublicIdentity(*secret)
+	publicProvisional, _ := identity.GetPublicIdentity(*provisional)
+
+	for _, b64 := range []string{*secret, *provisional, *public, *publicProvisional} {
+		parsed, err := identity.ParseIdentity(b64)
+		if err != nil {
+			t.Fatal("error parsing identity:", err)
+		}
+		// the identity types are written directly, they must give the same
+		// result as the generic path
+		generic := map[string]interface{}{}
+		if err := identity.Decode(b64, &generic); err != nil {
+			t.Fatal("error decoding identity:", err)
+		}
+		for _, v := range []interface{}{parsed, generic} {
+			encoded, err := identity.Encode(v)
+			if err != nil {
+				t.Fatal("error encoding identity:", err)
+			}
+			if *encoded != b64 {
+				t.Fatalf("expected %s, got %s", b64, *encoded)
+			}
+		}
+	}
+}
+
 type errorMarshaller struct{}
 
 func (errorMarshaller) MarshalJSON() ([]byte, error) {
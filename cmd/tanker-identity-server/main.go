@@ -0,0 +1,97 @@
+// Command tanker-identity-server runs the identity server of the server
+// package as a standalone HTTP service.
+//
+// The app config is read from the TANKER_APP_ID and TANKER_APP_SECRET
+// environment variables. Callers are authenticated by a header, set by
+// an authenticating reverse proxy, whose name is given by -user-header:
+// the server must not be reachable without going through that proxy.
+// Provisional identities are only delivered for the email address and
+// phone number the proxy passes in the headers named by -email-header and
+// -phone-number-header, which it must only set once they are verified.
+//
+// Identities are kept in the file given by -store, or in memory and lost
+// when the server stops if -store is empty.
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/TankerHQ/identity-go/v3"
+	"github.com/TankerHQ/identity-go/v3/server"
+	"github.com/TankerHQ/identity-go/v3/store"
+)
+
+func main() {
+	addr := flag.String("addr", "localhost:8080", "address to listen on")
+	userHeader := flag.String("user-header", "X-Remote-User", "header holding the authenticated user ID")
+	emailHeader := flag.String("email-header", "", "header holding the verified email address of the user, if any")
+	phoneNumberHeader := flag.String("phone-number-header", "", "header holding the verified phone number of the user, if any")
+	storePath := flag.String("store", "", "file to store identities in (default in memory)")
+	flag.Parse()
+
+	logger := slog.New(slog.NewTextHandler(os.Stderr, nil))
+	auth := server.HeaderAuthenticator{Header: *userHeader, EmailHeader: *emailHeader, PhoneNumberHeader: *phoneNumberHeader}
+	if err := run(*addr, auth, *storePath, logger); err != nil {
+		logger.Error("tanker-identity-server failed", "error", err)
+		os.Exit(1)
+	}
+}
+
+func run(addr string, auth server.Authenticator, storePath string, logger *slog.Logger) error {
+	config := identity.Config{
+		AppID:     os.Getenv("TANKER_APP_ID"),
+		AppSecret: os.Getenv("TANKER_APP_SECRET"),
+	}
+	if config.AppID == "" || config.AppSecret == "" {
+		return errors.New("TANKER_APP_ID and TANKER_APP_SECRET must be set")
+	}
+
+	var identityStore store.IdentityStore = store.NewMemory()
+	if storePath != "" {
+		fileStore, err := store.OpenFile(storePath)
+		if err != nil {
+			return err
+		}
+		defer fileStore.Close()
+		identityStore = fileStore
+	}
+
+	handler, err := server.New(config, auth, identityStore, server.WithLogger(logger))
+	if err != nil {
+		return fmt.Errorf("invalid config: %w", err)
+	}
+
+	srv := &http.Server{
+		Addr:              addr,
+		Handler:           handler,
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	errs := make(chan error, 1)
+	go func() {
+		logger.Info("listening", "addr", addr, "config", config)
+		errs <- srv.ListenAndServe()
+	}()
+
+	select {
+	case err := <-errs:
+		return err
+	case <-ctx.Done():
+	}
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	return srv.Shutdown(shutdownCtx)
+}
@@ -0,0 +1,367 @@
+// Command tanker-identity creates, inspects and verifies Tanker identities.
+//
+// Usage:
+//
+//	tanker-identity <command> [flags] [arguments]
+//
+// The commands are:
+//
+//	create <user-id>                    create a secret identity
+//	create-provisional <target> <value> create a secret provisional identity
+//	public <identity>                   get the public identity of an identity
+//	upgrade <identity>                  upgrade an identity to the latest format
+//	inspect <identity>                  print the decoded identity as JSON
+//	verify <identity> [user-id]         check that an identity is valid
+//
+// An identity argument of "-", or no identity argument at all, reads the
+// identity from the standard input.
+//
+// Commands that need the app config read it from the -app-id and
+// -app-secret flags, then from the JSON file given by -config (with
+// "app_id" and "app_secret" keys), then from the TANKER_APP_ID and
+// TANKER_APP_SECRET environment variables. Prefer the file or the
+// environment for the app secret, since flags are visible to other users
+// of the machine.
+//
+// By default identities are printed as base64 tokens. With -output json
+// they are printed as decoded JSON, with private keys and secrets masked
+// unless -show-secrets is given.
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/TankerHQ/identity-go/v3"
+	"github.com/TankerHQ/identity-go/v3/internal/ordered"
+)
+
+const masked = "[MASKED]"
+
+var secretFields = []string{
+	"ephemeral_private_signature_key",
+	"user_secret",
+	"private_encryption_key",
+	"private_signature_key",
+}
+
+var errUsage = errors.New("invalid usage")
+
+type env struct {
+	getenv func(string) string
+	stdin  io.Reader
+	stdout io.Writer
+	stderr io.Writer
+}
+
+type command struct {
+	usage string
+	// needsConfig tells whether the config flags should be registered
+	needsConfig bool
+	// hasOutput tells whether the -output and -show-secrets flags should
+	// be registered
+	hasOutput bool
+	run       func(e *env, opts *options, args []string) error
+}
+
+type options struct {
+	appID       string
+	appSecret   string
+	configFile  string
+	output      string
+	showSecrets bool
+}
+
+var commands = map[string]command{
+	"create": {
+		usage:       "create [flags] <user-id>",
+		needsConfig: true,
+		hasOutput:   true,
+		run:         runCreate,
+	},
+	"create-provisional": {
+		usage:       "create-provisional [flags] <email|phone_number> <value>",
+		needsConfig: true,
+		hasOutput:   true,
+		run:         runCreateProvisional,
+	},
+	"public": {
+		usage:     "public [flags] [identity]",
+		hasOutput: true,
+		run:       runPublic,
+	},
+	"upgrade": {
+		usage:     "upgrade [flags] [identity]",
+		hasOutput: true,
+		run:       runUpgrade,
+	},
+	"inspect": {
+		usage: "inspect [flags] [identity]",
+		run:   runInspect,
+	},
+	"verify": {
+		usage:       "verify [flags] <identity> [user-id]",
+		needsConfig: true,
+		run:         runVerify,
+	},
+}
+
+var commandOrder = []string{"create", "create-provisional", "public", "upgrade", "inspect", "verify"}
+
+func main() {
+	os.Exit(run(os.Args[1:], &env{
+		getenv: os.Getenv,
+		stdin:  os.Stdin,
+		stdout: os.Stdout,
+		stderr: os.Stderr,
+	}))
+}
+
+func run(args []string, e *env) int {
+	if len(args) == 0 {
+		printUsage(e.stderr)
+		return 2
+	}
+	cmd, found := commands[args[0]]
+	if !found {
+		fmt.Fprintf(e.stderr, "tanker-identity: unknown command %q\n", args[0])
+		printUsage(e.stderr)
+		return 2
+	}
+
+	opts := new(options)
+	flags := flag.NewFlagSet(args[0], flag.ContinueOnError)
+	flags.SetOutput(e.stderr)
+	flags.Usage = func() {
+		fmt.Fprintf(e.stderr, "usage: tanker-identity %s\n", cmd.usage)
+		flags.PrintDefaults()
+	}
+	if cmd.needsConfig {
+		flags.StringVar(&opts.appID, "app-id", "", "app ID (default $TANKER_APP_ID)")
+		flags.StringVar(&opts.appSecret, "app-secret", "", "app secret (default $TANKER_APP_SECRET)")
+		flags.StringVar(&opts.configFile, "config", "", "JSON file holding app_id and app_secret")
+	}
+	if cmd.hasOutput {
+		flags.StringVar(&opts.output, "output", "raw", "output format, raw or json")
+	}
+	if cmd.hasOutput || args[0] == "inspect" {
+		flags.BoolVar(&opts.showSecrets, "show-secrets", false, "do not mask secrets in JSON output")
+	}
+	if err := flags.Parse(args[1:]); err != nil {
+		return 2
+	}
+	if opts.output != "" && opts.output != "raw" && opts.output != "json" {
+		fmt.Fprintf(e.stderr, "tanker-identity: unknown output format %q\n", opts.output)
+		return 2
+	}
+
+	if err := cmd.run(e, opts, flags.Args()); err != nil {
+		if errors.Is(err, errUsage) {
+			flags.Usage()
+			return 2
+		}
+		fmt.Fprintf(e.stderr, "tanker-identity: %v\n", err)
+		return 1
+	}
+	return 0
+}
+
+func printUsage(w io.Writer) {
+	fmt.Fprintln(w, "usage: tanker-identity <command> [flags] [arguments]")
+	fmt.Fprintln(w, "\ncommands:")
+	for _, name := range commandOrder {
+		fmt.Fprintf(w, "  %s\n", commands[name].usage)
+	}
+}
+
+func runCreate(e *env, opts *options, args []string) error {
+	if len(args) != 1 {
+		return errUsage
+	}
+	config, err := loadConfig(e, opts)
+	if err != nil {
+		return err
+	}
+	id, err := identity.Create(*config, args[0])
+	if err != nil {
+		return err
+	}
+	return printIdentity(e, opts, *id)
+}
+
+func runCreateProvisional(e *env, opts *options, args []string) error {
+	if len(args) != 2 {
+		return errUsage
+	}
+	config, err := loadConfig(e, opts)
+	if err != nil {
+		return err
+	}
+	id, err := identity.CreateProvisional(*config, args[0], args[1])
+	if err != nil {
+		return err
+	}
+	return printIdentity(e, opts, *id)
+}
+
+func runPublic(e *env, opts *options, args []string) error {
+	b64Identity, err := readIdentity(e, args)
+	if err != nil {
+		return err
+	}
+	public, err := identity.GetPublicIdentity(b64Identity)
+	if err != nil {
+		return err
+	}
+	return printIdentity(e, opts, *public)
+}
+
+func runUpgrade(e *env, opts *options, args []string) error {
+	b64Identity, err := readIdentity(e, args)
+	if err != nil {
+		return err
+	}
+	upgraded, err := identity.UpgradeIdentity(b64Identity)
+	if err != nil {
+		return err
+	}
+	return printIdentity(e, opts, *upgraded)
+}
+
+func runInspect(e *env, opts *options, args []string) error {
+	b64Identity, err := readIdentity(e, args)
+	if err != nil {
+		return err
+	}
+	opts.output = "json"
+	return printIdentity(e, opts, b64Identity)
+}
+
+func runVerify(e *env, opts *options, args []string) error {
+	if len(args) == 0 || len(args) > 2 {
+		return errUsage
+	}
+	b64Identity, err := readIdentity(e, args[:1])
+	if err != nil {
+		return err
+	}
+
+	parsed, err := identity.ParseIdentity(b64Identity)
+	if err != nil {
+		return err
+	}
+	switch parsed.Kind() {
+	case identity.KindSecretPermanent:
+		if len(args) != 2 {
+			return errors.New("verifying a secret permanent identity requires a user ID")
+		}
+		config, err := loadConfig(e, opts)
+		if err != nil {
+			return err
+		}
+		if err := identity.VerifyIdentity(*config, b64Identity, args[1]); err != nil {
+			return err
+		}
+	case identity.KindSecretProvisional:
+		if err := identity.ValidateProvisionalIdentity(b64Identity); err != nil {
+			return err
+		}
+	default:
+		return fmt.Errorf("cannot verify a %v identity", parsed.Kind())
+	}
+
+	fmt.Fprintf(e.stdout, "valid %v identity\n", parsed.Kind())
+	return nil
+}
+
+func loadConfig(e *env, opts *options) (*identity.Config, error) {
+	config := identity.Config{AppID: opts.appID, AppSecret: opts.appSecret}
+
+	if opts.configFile != "" {
+		buf, err := os.ReadFile(opts.configFile)
+		if err != nil {
+			return nil, err
+		}
+		fileConfig := struct {
+			AppID     string `json:"app_id"`
+			AppSecret string `json:"app_secret"`
+		}{}
+		// the JSON error is not wrapped since it may quote the app secret
+		if err := json.Unmarshal(buf, &fileConfig); err != nil {
+			var syntaxErr *json.SyntaxError
+			if errors.As(err, &syntaxErr) {
+				return nil, fmt.Errorf("unable to parse config file %s: invalid JSON at offset %d", opts.configFile, syntaxErr.Offset)
+			}
+			return nil, fmt.Errorf("unable to parse config file %s: not a valid JSON config file", opts.configFile)
+		}
+		if config.AppID == "" {
+			config.AppID = fileConfig.AppID
+		}
+		if config.AppSecret == "" {
+			config.AppSecret = fileConfig.AppSecret
+		}
+	}
+
+	if config.AppID == "" {
+		config.AppID = e.getenv("TANKER_APP_ID")
+	}
+	if config.AppSecret == "" {
+		config.AppSecret = e.getenv("TANKER_APP_SECRET")
+	}
+
+	if config.AppID == "" || config.AppSecret == "" {
+		return nil, errors.New("missing app config, use -app-id and -app-secret, -config, or TANKER_APP_ID and TANKER_APP_SECRET")
+	}
+	return &config, nil
+}
+
+func readIdentity(e *env, args []string) (string, error) {
+	if len(args) > 1 {
+		return "", errUsage
+	}
+	if len(args) == 1 && args[0] != "-" {
+		return strings.TrimSpace(args[0]), nil
+	}
+
+	line, err := bufio.NewReader(e.stdin).ReadString('\n')
+	if err != nil && !errors.Is(err, io.EOF) {
+		return "", err
+	}
+	line = strings.TrimSpace(line)
+	if line == "" {
+		return "", errors.New("no identity given")
+	}
+	return line, nil
+}
+
+func printIdentity(e *env, opts *options, b64Identity string) error {
+	if opts.output != "json" {
+		_, err := fmt.Fprintln(e.stdout, b64Identity)
+		return err
+	}
+
+	decoded := ordered.New()
+	if err := identity.Decode(b64Identity, &decoded); err != nil {
+		return err
+	}
+	if !opts.showSecrets {
+		for _, field := range secretFields {
+			if _, found := decoded.Get(field); found {
+				decoded.Set(field, masked)
+			}
+		}
+	}
+
+	buf, err := json.MarshalIndent(decoded, "", "  ")
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintln(e.stdout, string(buf))
+	return err
+}
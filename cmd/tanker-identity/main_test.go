@@ -0,0 +1,201 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/TankerHQ/identity-go/v3"
+	"github.com/TankerHQ/identity-go/v3/store/storetest"
+)
+
+var (
+	validConf = storetest.NewConfig()
+	appSecret = validConf.AppSecret
+	appID     = validConf.AppID
+)
+
+func runCmd(t *testing.T, environ map[string]string, stdin string, args ...string) (int, string, string) {
+	t.Helper()
+	var stdout, stderr bytes.Buffer
+	code := run(args, &env{
+		getenv: func(key string) string { return environ[key] },
+		stdin:  strings.NewReader(stdin),
+		stdout: &stdout,
+		stderr: &stderr,
+	})
+	return code, stdout.String(), stderr.String()
+}
+
+func configEnv() map[string]string {
+	return map[string]string{"TANKER_APP_ID": appID, "TANKER_APP_SECRET": appSecret}
+}
+
+func TestCreate(t *testing.T) {
+	t.Run("Env", func(t *testing.T) {
+		code, out, _ := runCmd(t, configEnv(), "", "create", "alice")
+		if code != 0 {
+			t.Fatal("create failed")
+		}
+		if err := identity.VerifyIdentity(identity.Config{AppID: appID, AppSecret: appSecret}, strings.TrimSpace(out), "alice"); err != nil {
+			t.Fatal("created identity is invalid:", err)
+		}
+	})
+
+	t.Run("Flags", func(t *testing.T) {
+		code, _, _ := runCmd(t, nil, "", "create", "-app-id", appID, "-app-secret", appSecret, "alice")
+		if code != 0 {
+			t.Fatal("create failed")
+		}
+	})
+
+	t.Run("File", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "config.json")
+		content := `{"app_id": "` + appID + `", "app_secret": "` + appSecret + `"}`
+		if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+			panic("error writing config file")
+		}
+		code, _, _ := runCmd(t, nil, "", "create", "-config", path, "alice")
+		if code != 0 {
+			t.Fatal("create failed")
+		}
+	})
+
+	t.Run("JSONMasked", func(t *testing.T) {
+		code, out, _ := runCmd(t, configEnv(), "", "create", "-output", "json", "alice")
+		if code != 0 {
+			t.Fatal("create failed")
+		}
+		if !strings.Contains(out, `"user_secret": "[MASKED]"`) || !strings.Contains(out, `"trustchain_id": "`+appID+`"`) {
+			t.Fatal("unexpected JSON output:", out)
+		}
+	})
+}
+
+func TestCreate_Error(t *testing.T) {
+	code, _, stderr := runCmd(t, nil, "", "create", "alice")
+	if code != 1 || !strings.Contains(stderr, "missing app config") {
+		t.Fatal("no error creating identity without config")
+	}
+
+	code, _, stderr = runCmd(t, map[string]string{"TANKER_APP_ID": appID, "TANKER_APP_SECRET": "not base64"}, "", "create", "alice")
+	if code != 1 || strings.Contains(stderr, "not base64") {
+		t.Fatal("unexpected error output:", stderr)
+	}
+
+	path := filepath.Join(t.TempDir(), "config.json")
+	content := `{"app_id": "` + appID + `", "app_secret": "` + appSecret[:10] + `"x}`
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		panic("error writing config file")
+	}
+	code, _, stderr = runCmd(t, nil, "", "create", "-config", path, "alice")
+	if code != 1 || !strings.Contains(stderr, "offset") || strings.Contains(stderr, "'x'") {
+		t.Fatal("unexpected error output for malformed config file:", stderr)
+	}
+
+	code, _, _ = runCmd(t, configEnv(), "", "create")
+	if code != 2 {
+		t.Fatal("no usage error creating identity without user ID")
+	}
+}
+
+func TestCreateProvisional(t *testing.T) {
+	code, out, _ := runCmd(t, configEnv(), "", "create-provisional", "email", "alice@example.com")
+	if code != 0 {
+		t.Fatal("create-provisional failed")
+	}
+	if err := identity.ValidateProvisionalIdentity(strings.TrimSpace(out)); err != nil {
+		t.Fatal("created provisional identity is invalid:", err)
+	}
+
+	code, _, _ = runCmd(t, configEnv(), "", "create-provisional", "fax", "alice@example.com")
+	if code != 1 {
+		t.Fatal("no error creating provisional identity with bad target")
+	}
+}
+
+func TestPublicAndUpgrade(t *testing.T) {
+	_, id, _ := runCmd(t, configEnv(), "", "create", "alice")
+	expected, err := identity.GetPublicIdentity(strings.TrimSpace(id))
+	if err != nil {
+		panic("error getting public identity")
+	}
+
+	code, out, _ := runCmd(t, nil, "", "public", strings.TrimSpace(id))
+	if code != 0 || strings.TrimSpace(out) != *expected {
+		t.Fatal("public returned wrong public identity")
+	}
+
+	code, out, _ = runCmd(t, nil, id, "public")
+	if code != 0 || strings.TrimSpace(out) != *expected {
+		t.Fatal("public from stdin returned wrong public identity")
+	}
+
+	code, out, _ = runCmd(t, nil, "", "upgrade", "-", "extra")
+	if code != 2 {
+		t.Fatal("no usage error upgrading with extra argument")
+	}
+
+	code, out, _ = runCmd(t, nil, *expected+"\n", "upgrade", "-")
+	if code != 0 || strings.TrimSpace(out) != *expected {
+		t.Fatal("upgrade changed an up-to-date identity")
+	}
+}
+
+func TestInspect(t *testing.T) {
+	_, id, _ := runCmd(t, configEnv(), "", "create-provisional", "email", "alice@example.com")
+
+	code, out, _ := runCmd(t, nil, id, "inspect")
+	if code != 0 {
+		t.Fatal("inspect failed")
+	}
+	if !strings.Contains(out, `"private_signature_key": "[MASKED]"`) ||
+		!strings.Contains(out, `"private_encryption_key": "[MASKED]"`) ||
+		!strings.Contains(out, `"value": "alice@example.com"`) {
+		t.Fatal("unexpected inspect output:", out)
+	}
+
+	code, out, _ = runCmd(t, nil, id, "inspect", "-show-secrets")
+	if code != 0 || strings.Contains(out, "[MASKED]") {
+		t.Fatal("inspect -show-secrets masked secrets")
+	}
+
+	code, _, _ = runCmd(t, nil, "", "inspect", "not an identity")
+	if code != 1 {
+		t.Fatal("no error inspecting invalid identity")
+	}
+}
+
+func TestVerify(t *testing.T) {
+	_, id, _ := runCmd(t, configEnv(), "", "create", "alice")
+	id = strings.TrimSpace(id)
+	_, prov, _ := runCmd(t, configEnv(), "", "create-provisional", "email", "alice@example.com")
+	prov = strings.TrimSpace(prov)
+
+	if code, _, _ := runCmd(t, configEnv(), "", "verify", id, "alice"); code != 0 {
+		t.Fatal("verify failed on valid identity")
+	}
+	if code, _, _ := runCmd(t, configEnv(), "", "verify", id, "bob"); code != 1 {
+		t.Fatal("no error verifying identity of another user")
+	}
+	if code, _, _ := runCmd(t, configEnv(), "", "verify", id); code != 1 {
+		t.Fatal("no error verifying identity without user ID")
+	}
+	if code, _, _ := runCmd(t, nil, "", "verify", prov); code != 0 {
+		t.Fatal("verify failed on valid provisional identity")
+	}
+}
+
+func TestUnknownCommand(t *testing.T) {
+	if code, _, _ := runCmd(t, nil, ""); code != 2 {
+		t.Fatal("no usage error without command")
+	}
+	if code, _, _ := runCmd(t, nil, "", "frobnicate"); code != 2 {
+		t.Fatal("no usage error with unknown command")
+	}
+	if code, _, _ := runCmd(t, nil, "", "public", "-output", "yaml", "x"); code != 2 {
+		t.Fatal("no usage error with unknown output format")
+	}
+}
@@ -3,11 +3,19 @@ package identity
 import (
 	"encoding/base64"
 	"fmt"
+	"io"
+	"log/slog"
 
 	"github.com/TankerHQ/identity-go/v3/internal/app"
 )
 
-// Config wraps information about an app
+// redacted replaces secrets when printing or logging values
+const redacted = "[REDACTED]"
+
+// Config wraps information about an app.
+//
+// The AppSecret never appears when a Config is printed with the fmt
+// package or logged with log/slog.
 type Config struct {
 	// AppID is the ID of the app corresponding to this config
 	AppID string
@@ -15,6 +23,47 @@ type Config struct {
 	AppSecret string
 }
 
+// String implements fmt.Stringer, redacting the AppSecret
+func (cfg Config) String() string {
+	return fmt.Sprintf("{%s %s}", cfg.AppID, redactSecret(cfg.AppSecret))
+}
+
+// GoString implements fmt.GoStringer, redacting the AppSecret
+func (cfg Config) GoString() string {
+	return fmt.Sprintf("identity.Config{AppID:%q, AppSecret:%q}", cfg.AppID, redactSecret(cfg.AppSecret))
+}
+
+// Format implements fmt.Formatter so that no verb prints the AppSecret
+func (cfg Config) Format(f fmt.State, verb rune) {
+	switch {
+	case verb == 'v' && f.Flag('#'):
+		io.WriteString(f, cfg.GoString()) //nolint: errcheck
+	case verb == 'v' && f.Flag('+'):
+		fmt.Fprintf(f, "{AppID:%s AppSecret:%s}", cfg.AppID, redactSecret(cfg.AppSecret))
+	case verb == 'q':
+		fmt.Fprintf(f, "%q", cfg.String())
+	default:
+		io.WriteString(f, cfg.String()) //nolint: errcheck
+	}
+}
+
+// LogValue implements slog.LogValuer, redacting the AppSecret
+func (cfg Config) LogValue() slog.Value {
+	return slog.GroupValue(
+		slog.String("AppID", cfg.AppID),
+		slog.String("AppSecret", redactSecret(cfg.AppSecret)),
+	)
+}
+
+// redactSecret keeps empty secrets visible, since a missing secret is a
+// common misconfiguration that is worth seeing in logs
+func redactSecret(secret string) string {
+	if secret == "" {
+		return ""
+	}
+	return redacted
+}
+
 type config struct {
 	AppID     []byte
 	AppSecret []byte
@@ -27,21 +76,29 @@ func (cfg Config) fromBase64() (*config, error) {
 		err    error
 	)
 
-	newCfg.AppID, err = base64.StdEncoding.DecodeString(cfg.AppID)
+	newCfg.AppID, err = decodeAppID(cfg.AppID)
 	if err != nil {
-		return nil, fmt.Errorf("unable to decode AppID '%s', should be a valid base64 string", cfg.AppID)
-	}
-	if len(newCfg.AppID) != app.AppPublicKeySize {
-		return nil, fmt.Errorf("wrong byte size for AppID: %d, should be %d", len(newCfg.AppID), app.AppPublicKeySize)
+		return nil, err
 	}
 
 	newCfg.AppSecret, err = base64.StdEncoding.DecodeString(cfg.AppSecret)
 	if err != nil {
-		return nil, fmt.Errorf("unable to decode AppSecret '%s', should be a valid base64 string", cfg.AppSecret)
+		return nil, &FieldError{Err: ErrInvalidConfig, Field: "AppSecret", Reason: "should be a valid base64 string", Cause: err}
 	}
 	if len(newCfg.AppSecret) != app.AppSecretSize {
-		return nil, fmt.Errorf("wrong byte size for AppSecret: %d, should be %d", len(newCfg.AppSecret), app.AppSecretSize)
+		return nil, sizeError(ErrInvalidConfig, "AppSecret", app.AppSecretSize, len(newCfg.AppSecret))
 	}
 
 	return newCfg, nil
 }
+
+func decodeAppID(appID string) ([]byte, error) {
+	trustchainID, err := base64.StdEncoding.DecodeString(appID)
+	if err != nil {
+		return nil, &FieldError{Err: ErrInvalidConfig, Field: "AppID", Reason: "should be a valid base64 string", Cause: err}
+	}
+	if len(trustchainID) != app.AppPublicKeySize {
+		return nil, sizeError(ErrInvalidConfig, "AppID", app.AppPublicKeySize, len(trustchainID))
+	}
+	return trustchainID, nil
+}
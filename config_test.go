@@ -0,0 +1,143 @@
+package identity_test
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"log/slog"
+	"strings"
+	"testing"
+
+	"github.com/TankerHQ/identity-go/v3"
+)
+
+func assertNoSecret(t *testing.T, text string, secret string) {
+	t.Helper()
+	if secret == "" {
+		return
+	}
+	raw, _ := base64.StdEncoding.DecodeString(secret)
+	leaks := []string{secret, hex.EncodeToString(raw)}
+	if len(raw) > 0 {
+		leaks = append(leaks, fmt.Sprintf("%v", raw))
+	}
+	// any run of 8 characters of the secret is enough to tell a leak
+	// from a coincidence
+	for i := 0; i+8 <= len(secret); i++ {
+		leaks = append(leaks, secret[i:i+8])
+	}
+	for _, leak := range leaks {
+		if len(leak) >= 8 && strings.Contains(text, leak) {
+			t.Fatalf("secret leaked in %q", text)
+		}
+	}
+}
+
+func TestConfig_Format(t *testing.T) {
+	formats := []string{"%v", "%+v", "%#v", "%s", "%q", "%x", "%X", "%d"}
+	for _, format := range formats {
+		t.Run(format, func(t *testing.T) {
+			assertNoSecret(t, fmt.Sprintf(format, validConf), validAppSecret)
+			assertNoSecret(t, fmt.Sprintf(format, &validConf), validAppSecret)
+		})
+	}
+
+	if !strings.Contains(fmt.Sprintf("%+v", validConf), validAppId) {
+		t.Fatal("AppID should be printed")
+	}
+	if validConf.String() != fmt.Sprint(validConf) {
+		t.Fatal("String and Sprint differ")
+	}
+	assertNoSecret(t, validConf.GoString(), validAppSecret)
+}
+
+func TestConfig_LogValue(t *testing.T) {
+	var buf bytes.Buffer
+	logger := slog.New(slog.NewJSONHandler(&buf, nil))
+	logger.Info("config", "config", validConf)
+
+	assertNoSecret(t, buf.String(), validAppSecret)
+	if !strings.Contains(buf.String(), validAppId) {
+		t.Fatal("AppID should be logged")
+	}
+}
+
+func TestIssuer_Format(t *testing.T) {
+	issuer, err := identity.NewIssuer(validConf)
+	if err != nil {
+		panic("error creating issuer")
+	}
+
+	rawSecret := string(goodAppSecretRaw)
+	for _, format := range []string{"%v", "%+v", "%#v", "%s", "%q", "%x"} {
+		text := fmt.Sprintf(format, issuer)
+		assertNoSecret(t, text, validAppSecret)
+		if strings.Contains(text, rawSecret) {
+			t.Fatalf("secret leaked in %q", text)
+		}
+	}
+}
+
+func FuzzConfigErrors(f *testing.F) {
+	f.Add(validAppSecret, 0, "")
+	f.Add(validAppSecret, 10, "")
+	f.Add(validAppSecret, len(validAppSecret)-3, "")
+	f.Add(validAppSecret, 20, "!")
+	f.Add(validAppSecret, 5, "-_")
+	f.Add(validAppSecret, 40, "\n")
+	f.Add(validAppSecret+validAppSecret, 0, "")
+	f.Add(strings.ToLower(validAppSecret), 0, "")
+
+	f.Fuzz(func(t *testing.T, secret string, cut int, junk string) {
+		if cut > 0 && cut < len(secret) {
+			secret = secret[:cut] + junk + secret[cut:]
+		}
+
+		conf := identity.Config{AppID: validAppId, AppSecret: secret}
+		errs := []error{}
+		if _, err := identity.Create(conf, "userID"); err != nil {
+			errs = append(errs, err)
+		}
+		if _, err := identity.CreateProvisional(conf, "email", "userID"); err != nil {
+			errs = append(errs, err)
+		}
+		if _, err := identity.NewIssuer(conf); err != nil {
+			errs = append(errs, err)
+		}
+		if err := identity.VerifyIdentity(conf, notBase64Identity, "userID"); err != nil {
+			errs = append(errs, err)
+		}
+
+		for _, err := range errs {
+			assertNoSecret(t, err.Error(), secret)
+			assertNoSecret(t, fmt.Sprintf("%+v", err), secret)
+		}
+	})
+}
+
+func TestDecode_NoSecretInError(t *testing.T) {
+	prov, err := identity.CreateProvisional(validConf, "email", "userID")
+	if err != nil {
+		panic("error creating provisional identity")
+	}
+	raw, _ := base64.StdEncoding.DecodeString(*prov)
+	// drop the opening quote of the private signature key, so that the
+	// JSON decoder stumbles on its first character
+	corrupted := strings.Replace(string(raw), `"private_signature_key":"`, `"private_signature_key":`, 1)
+	b64Corrupted := base64.StdEncoding.EncodeToString([]byte(corrupted))
+
+	for _, call := range []func() error{
+		func() error { _, err := identity.GetPublicIdentity(b64Corrupted); return err },
+		func() error { _, err := identity.UpgradeIdentity(b64Corrupted); return err },
+		func() error { _, err := identity.ParseIdentity(b64Corrupted); return err },
+	} {
+		err := call()
+		if err == nil {
+			t.Fatal("no error decoding corrupted identity")
+		}
+		if strings.Contains(err.Error(), "'") {
+			t.Fatalf("identity content leaked in %q", err.Error())
+		}
+	}
+}
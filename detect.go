@@ -0,0 +1,58 @@
+package identity
+
+import "fmt"
+
+// DetectKind returns the kind of b64Identity, which can be any kind of
+// identity. It is the Kind of the identity returned by ParseIdentity; see
+// DetectProvisionalKind to tell public provisional identities apart.
+//
+// The identity is checked as by UpgradeIdentity, so that DetectKind
+// accepts any identity UpgradeIdentity does.
+func DetectKind(b64Identity string) (Kind, error) {
+	_, kind, err := decodeStrict(b64Identity, true)
+	return kind, err
+}
+
+// DetectProvisionalKind returns the kind of b64Identity, which must be a
+// public provisional identity. It is the ProvisionalKind of the identity
+// returned by ParseIdentity, and is checked as by DetectKind.
+func DetectProvisionalKind(b64Identity string) (ProvisionalKind, error) {
+	raw, kind, err := decodeStrict(b64Identity, true)
+	if err != nil {
+		return 0, err
+	}
+	if kind != KindPublicProvisional {
+		return 0, fmt.Errorf("%w: expected a %v identity, got a %v identity", ErrWrongKind, KindPublicProvisional, kind)
+	}
+	return provisionalKindOf(*raw.Target), nil
+}
+
+// IsSecret returns whether b64Identity is a secret identity, holding
+// private keys
+func IsSecret(b64Identity string) (bool, error) {
+	kind, err := DetectKind(b64Identity)
+	if err != nil {
+		return false, err
+	}
+	return kind.IsSecret(), nil
+}
+
+// IsPublic returns whether b64Identity is a public identity, safe to share
+// with other users
+func IsPublic(b64Identity string) (bool, error) {
+	kind, err := DetectKind(b64Identity)
+	if err != nil {
+		return false, err
+	}
+	return kind.IsPublic(), nil
+}
+
+// NeedsUpgrade returns whether UpgradeIdentity would change b64Identity,
+// that is whether one of the steps of DefaultMigrations applies to it
+func NeedsUpgrade(b64Identity string) (bool, error) {
+	result, err := defaultMigrations.DryRun(b64Identity)
+	if err != nil {
+		return false, err
+	}
+	return len(result.Applied) > 0, nil
+}
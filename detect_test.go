@@ -0,0 +1,144 @@
+package identity_test
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/TankerHQ/identity-go/v3"
+)
+
+func TestDetectKind(t *testing.T) {
+	id, err := identity.Create(validConf, "userID")
+	if err != nil {
+		panic("error creating identity")
+	}
+	pub, _ := identity.GetPublicIdentity(*id)
+	email, err := identity.CreateProvisional(validConf, "email", "alice@example.com")
+	if err != nil {
+		panic("error creating provisional identity")
+	}
+	phoneNumber, err := identity.CreateProvisional(validConf, "phone_number", "+33612345678")
+	if err != nil {
+		panic("error creating provisional identity")
+	}
+	hashedEmail, _ := identity.GetPublicIdentity(*email)
+	hashedPhoneNumber, _ := identity.GetPublicIdentity(*phoneNumber)
+
+	vectors := []struct {
+		desc         string
+		identity     string
+		kind         identity.Kind
+		provisional  identity.ProvisionalKind
+		secret       bool
+		needsUpgrade bool
+	}{
+		{desc: "SecretPermanent", identity: *id, kind: identity.KindSecretPermanent, secret: true},
+		{desc: "SecretProvisionalEmail", identity: *email, kind: identity.KindSecretProvisional, secret: true},
+		{desc: "SecretProvisionalPhoneNumber", identity: *phoneNumber, kind: identity.KindSecretProvisional, secret: true},
+		{desc: "PublicPermanent", identity: *pub, kind: identity.KindPublicPermanent},
+		{desc: "PublicHashedEmail", identity: *hashedEmail, kind: identity.KindPublicProvisional, provisional: identity.ProvisionalHashedEmail},
+		{desc: "PublicHashedPhoneNumber", identity: *hashedPhoneNumber, kind: identity.KindPublicProvisional, provisional: identity.ProvisionalHashedPhoneNumber},
+		{desc: "PublicLegacyEmail", identity: withoutPrivateKeys(*email), kind: identity.KindPublicProvisional, provisional: identity.ProvisionalLegacyEmail, needsUpgrade: true},
+		{desc: "PublicPhoneNumber", identity: withoutPrivateKeys(*phoneNumber), kind: identity.KindPublicProvisional, provisional: identity.ProvisionalPhoneNumber},
+	}
+
+	for _, vec := range vectors {
+		t.Run(vec.desc, func(t *testing.T) {
+			kind, err := identity.DetectKind(vec.identity)
+			if err != nil {
+				t.Fatal("error detecting kind:", err)
+			}
+			if kind != vec.kind {
+				t.Fatalf("expected %v, got %v", vec.kind, kind)
+			}
+			// DetectKind must agree with ParseIdentity
+			parsed, err := identity.ParseIdentity(vec.identity)
+			if err != nil {
+				t.Fatal("error parsing identity:", err)
+			}
+			if parsed.Kind() != kind {
+				t.Fatalf("ParseIdentity returned a %v identity", parsed.Kind())
+			}
+			if pub, ok := parsed.(*identity.PublicProvisionalIdentity); ok && pub.ProvisionalKind() != vec.provisional {
+				t.Fatalf("expected %v, got %v", vec.provisional, pub.ProvisionalKind())
+			}
+			provisional, err := identity.DetectProvisionalKind(vec.identity)
+			if vec.provisional == 0 {
+				if !errors.Is(err, identity.ErrWrongKind) {
+					t.Fatal("no error detecting the provisional kind of a non provisional identity")
+				}
+			} else if err != nil || provisional != vec.provisional {
+				t.Fatalf("expected %v, got %v (%v)", vec.provisional, provisional, err)
+			}
+			if provisional.NeedsUpgrade() != vec.needsUpgrade {
+				t.Fatal("wrong ProvisionalKind.NeedsUpgrade result")
+			}
+			if secret, err := identity.IsSecret(vec.identity); err != nil || secret != vec.secret {
+				t.Fatal("wrong IsSecret result")
+			}
+			if public, err := identity.IsPublic(vec.identity); err != nil || public == vec.secret {
+				t.Fatal("wrong IsPublic result")
+			}
+			needsUpgrade, err := identity.NeedsUpgrade(vec.identity)
+			if err != nil || needsUpgrade != vec.needsUpgrade {
+				t.Fatal("wrong NeedsUpgrade result")
+			}
+			// NeedsUpgrade must agree with UpgradeIdentity
+			upgraded, err := identity.UpgradeIdentity(vec.identity)
+			if err != nil {
+				t.Fatal("error upgrading identity:", err)
+			}
+			if (*upgraded != vec.identity) != needsUpgrade {
+				t.Fatal("NeedsUpgrade disagrees with UpgradeIdentity")
+			}
+		})
+	}
+}
+
+func TestDetectKind_Error(t *testing.T) {
+	badTarget, _ := identity.Encode(map[string]string{"target": invalidTarget})
+	noTarget, _ := identity.Encode(map[string]string{})
+
+	vectors := []struct {
+		desc     string
+		identity string
+		sentinel error
+	}{
+		{desc: "InvalidBase64", identity: notBase64Identity, sentinel: identity.ErrMalformedIdentity},
+		{desc: "NoTarget", identity: *noTarget, sentinel: identity.ErrMalformedIdentity},
+		{desc: "BadTarget", identity: *badTarget, sentinel: identity.ErrUnsupportedTarget},
+	}
+
+	for _, vec := range vectors {
+		t.Run(vec.desc, func(t *testing.T) {
+			if _, err := identity.DetectKind(vec.identity); !errors.Is(err, vec.sentinel) {
+				t.Fatalf("expected %v, got %v", vec.sentinel, err)
+			}
+			if _, err := identity.DetectProvisionalKind(vec.identity); !errors.Is(err, vec.sentinel) {
+				t.Fatalf("expected %v from DetectProvisionalKind, got %v", vec.sentinel, err)
+			}
+			if _, err := identity.IsSecret(vec.identity); !errors.Is(err, vec.sentinel) {
+				t.Fatalf("expected %v from IsSecret, got %v", vec.sentinel, err)
+			}
+			if _, err := identity.IsPublic(vec.identity); !errors.Is(err, vec.sentinel) {
+				t.Fatalf("expected %v from IsPublic, got %v", vec.sentinel, err)
+			}
+			if _, err := identity.NeedsUpgrade(vec.identity); !errors.Is(err, vec.sentinel) {
+				t.Fatalf("expected %v from NeedsUpgrade, got %v", vec.sentinel, err)
+			}
+		})
+	}
+}
+
+func TestKind_String(t *testing.T) {
+	for kind := identity.KindSecretPermanent; kind <= identity.KindPublicProvisional; kind++ {
+		if kind.String() == identity.Kind(0).String() {
+			t.Fatalf("kind %d has no name", kind)
+		}
+	}
+	for kind := identity.ProvisionalHashedEmail; kind <= identity.ProvisionalPhoneNumber; kind++ {
+		if kind.String() == identity.ProvisionalKind(0).String() {
+			t.Fatalf("provisional kind %d has no name", kind)
+		}
+	}
+}
@@ -0,0 +1,88 @@
+package identity
+
+import (
+	"errors"
+	"fmt"
+)
+
+var (
+	// ErrInvalidConfig is returned when a Config or an app ID cannot be
+	// decoded
+	ErrInvalidConfig = errors.New("invalid config")
+	// ErrAppIDMismatch is returned when the app secret of a Config does
+	// not match its app ID. It wraps ErrInvalidConfig.
+	ErrAppIDMismatch = fmt.Errorf("%w: app secret and app ID mismatch", ErrInvalidConfig)
+	// ErrUnsupportedTarget is returned when an identity, or a request to
+	// create one, has a target this package does not handle
+	ErrUnsupportedTarget = errors.New("unsupported identity target")
+	// ErrMalformedIdentity is returned when an identity cannot be decoded,
+	// or lacks fields, or has fields of the wrong size
+	ErrMalformedIdentity = errors.New("malformed tanker identity")
+	// ErrInvalidIdentity is returned when a well-formed identity does not
+	// pass verification
+	ErrInvalidIdentity = errors.New("invalid tanker identity")
+	// ErrWrongKind is returned when an identity is not of the kind
+	// expected by the function it was passed to
+	ErrWrongKind = errors.New("wrong identity kind")
+	// ErrEncoding is returned when a value cannot be encoded
+	ErrEncoding = errors.New("unable to encode identity")
+	// ErrRandom is returned when random bytes cannot be read
+	ErrRandom = errors.New("unable to read random bytes")
+	// ErrUnknownApp is returned by a Registry when an identity or an app
+	// ID belongs to an app which is not registered
+	ErrUnknownApp = errors.New("unknown app")
+)
+
+// FieldError describes a problem with a single field of a config or an
+// identity. It wraps one of the sentinel errors of this package, and the
+// underlying error if any, so it can be matched with errors.Is.
+type FieldError struct {
+	// Err is the sentinel error classifying the failure
+	Err error
+	// Field is the name of the offending field
+	Field string
+	// Expected and Actual are sizes in bytes, only set when the field
+	// has the wrong size
+	Expected int
+	Actual   int
+	// Reason describes the problem when it is not a size mismatch
+	Reason string
+	// Cause is the underlying error, if any
+	Cause error
+}
+
+func (e *FieldError) Error() string {
+	var msg string
+	if e.Reason != "" {
+		msg = fmt.Sprintf("%v: %s %s", e.Err, e.Field, e.Reason)
+	} else {
+		msg = fmt.Sprintf("%v: wrong byte size for %s: %d, should be %d", e.Err, e.Field, e.Actual, e.Expected)
+	}
+	if e.Cause != nil {
+		msg += ": " + e.Cause.Error()
+	}
+	return msg
+}
+
+// Unwrap returns the sentinel error and the underlying error, if any
+func (e *FieldError) Unwrap() []error {
+	if e.Cause != nil {
+		return []error{e.Err, e.Cause}
+	}
+	return []error{e.Err}
+}
+
+func sizeError(sentinel error, field string, expected int, actual int) error {
+	return &FieldError{Err: sentinel, Field: field, Expected: expected, Actual: actual}
+}
+
+func fieldError(sentinel error, field string, reason string) error {
+	return &FieldError{Err: sentinel, Field: field, Reason: reason}
+}
+
+func checkSize(sentinel error, field string, value []byte, expected int) error {
+	if len(value) != expected {
+		return sizeError(sentinel, field, expected, len(value))
+	}
+	return nil
+}
@@ -0,0 +1,203 @@
+package identity_test
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+
+	"github.com/TankerHQ/identity-go/v3"
+	"github.com/TankerHQ/identity-go/v3/internal/app"
+)
+
+func TestErrors_Config(t *testing.T) {
+	vectors := []struct {
+		desc     string
+		config   identity.Config
+		sentinel error
+		field    string
+		expected int
+		actual   int
+	}{
+		{
+			desc:     "WrongSizeAppId",
+			config:   identity.Config{AppID: wrongSizeAppId, AppSecret: validAppSecret},
+			sentinel: identity.ErrInvalidConfig,
+			field:    "AppID",
+			expected: app.AppPublicKeySize,
+			actual:   app.AppPublicKeySize / 2,
+		},
+		{
+			desc:     "WrongSizeAppSecret",
+			config:   identity.Config{AppID: validAppId, AppSecret: wrongSizeAppSecret},
+			sentinel: identity.ErrInvalidConfig,
+			field:    "AppSecret",
+			expected: app.AppSecretSize,
+			actual:   app.AppSecretSize - 2,
+		},
+		{
+			desc:     "NotBase64AppId",
+			config:   identity.Config{AppID: "app ID", AppSecret: validAppSecret},
+			sentinel: identity.ErrInvalidConfig,
+			field:    "AppID",
+		},
+		{
+			desc:     "NotBase64AppSecret",
+			config:   identity.Config{AppID: validAppId, AppSecret: "app secret"},
+			sentinel: identity.ErrInvalidConfig,
+			field:    "AppSecret",
+		},
+	}
+
+	for _, vec := range vectors {
+		t.Run(vec.desc, func(t *testing.T) {
+			_, err := identity.Create(vec.config, "userID")
+			if !errors.Is(err, vec.sentinel) {
+				t.Fatalf("expected %v, got %v", vec.sentinel, err)
+			}
+			var fieldErr *identity.FieldError
+			if !errors.As(err, &fieldErr) {
+				t.Fatal("error is not a FieldError")
+			}
+			if fieldErr.Field != vec.field || fieldErr.Expected != vec.expected || fieldErr.Actual != vec.actual {
+				t.Fatalf("unexpected FieldError content: %+v", fieldErr)
+			}
+		})
+	}
+
+	t.Run("AppIdSecretMismatch", func(t *testing.T) {
+		_, err := identity.Create(identity.Config{AppID: base64id(app.AppPublicKeySize), AppSecret: validAppSecret}, "userID")
+		if !errors.Is(err, identity.ErrAppIDMismatch) || !errors.Is(err, identity.ErrInvalidConfig) {
+			t.Fatalf("expected ErrAppIDMismatch, got %v", err)
+		}
+	})
+}
+
+func TestErrors_Identity(t *testing.T) {
+	id, err := identity.Create(validConf, "userID")
+	if err != nil {
+		panic("error creating identity")
+	}
+	noTarget, _ := identity.Encode(map[string]string{})
+	badTarget, _ := identity.Encode(map[string]string{"target": invalidTarget})
+	phoneNumber, err := identity.CreateProvisional(validConf, "phone_number", "+33612345678")
+	if err != nil {
+		panic("error creating provisional identity")
+	}
+	noPrivateKey := withoutPrivateKeys(*phoneNumber)
+
+	vectors := []struct {
+		desc     string
+		call     func() error
+		sentinel error
+		field    string
+	}{
+		{
+			desc: "CreateProvisionalInvalidTarget",
+			call: func() error {
+				_, err := identity.CreateProvisional(validConf, invalidTarget, "userID")
+				return err
+			},
+			sentinel: identity.ErrUnsupportedTarget,
+		},
+		{
+			desc: "GetPublicIdentityInvalidBase64",
+			call: func() error {
+				_, err := identity.GetPublicIdentity(notBase64Identity)
+				return err
+			},
+			sentinel: identity.ErrMalformedIdentity,
+		},
+		{
+			desc: "GetPublicIdentityBadTarget",
+			call: func() error {
+				_, err := identity.GetPublicIdentity(*badTarget)
+				return err
+			},
+			sentinel: identity.ErrUnsupportedTarget,
+		},
+		{
+			desc: "GetPublicIdentityMissingPrivateKey",
+			call: func() error {
+				_, err := identity.GetPublicIdentity(noPrivateKey)
+				return err
+			},
+			sentinel: identity.ErrMalformedIdentity,
+			field:    "private_signature_key",
+		},
+		{
+			desc: "UpgradeIdentityNoTarget",
+			call: func() error {
+				_, err := identity.UpgradeIdentity(*noTarget)
+				return err
+			},
+			sentinel: identity.ErrMalformedIdentity,
+			field:    "target",
+		},
+		{
+			desc: "ParsePublicIdentitySecret",
+			call: func() error {
+				_, err := identity.ParsePublicIdentity(*id)
+				return err
+			},
+			sentinel: identity.ErrWrongKind,
+		},
+		{
+			desc: "VerifyIdentityWrongUser",
+			call: func() error {
+				return identity.VerifyIdentity(validConf, *id, "otherUserID")
+			},
+			sentinel: identity.ErrInvalidIdentity,
+			field:    "value",
+		},
+		{
+			desc: "VerifyIdentityTruncatedSignature",
+			call: func() error {
+				tampered := tamperIdentity(*id, func(secret *identity.SecretPermanentIdentity) {
+					secret.DelegationSignature = secret.DelegationSignature[1:]
+				})
+				return identity.VerifyIdentity(validConf, tampered, "userID")
+			},
+			sentinel: identity.ErrMalformedIdentity,
+			field:    "delegation_signature",
+		},
+		{
+			desc: "EncodeMarshalError",
+			call: func() error {
+				_, err := identity.Encode(errorMarshaller{})
+				return err
+			},
+			sentinel: identity.ErrEncoding,
+		},
+		{
+			desc: "RandomFailure",
+			call: func() error {
+				issuer, err := identity.NewIssuer(validConf, identity.WithRandom(bytes.NewReader(nil)))
+				if err != nil {
+					panic("error creating issuer")
+				}
+				_, err = issuer.Create("userID")
+				return err
+			},
+			sentinel: identity.ErrRandom,
+		},
+	}
+
+	for _, vec := range vectors {
+		t.Run(vec.desc, func(t *testing.T) {
+			err := vec.call()
+			if !errors.Is(err, vec.sentinel) {
+				t.Fatalf("expected %v, got %v", vec.sentinel, err)
+			}
+			if vec.field == "" {
+				return
+			}
+			var fieldErr *identity.FieldError
+			if !errors.As(err, &fieldErr) {
+				t.Fatal("error is not a FieldError")
+			}
+			if fieldErr.Field != vec.field {
+				t.Fatalf("expected field %s, got %s", vec.field, fieldErr.Field)
+			}
+		})
+	}
+}
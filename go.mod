@@ -2,9 +2,6 @@ module github.com/TankerHQ/identity-go/v3
 
 go 1.22
 
-require (
-	github.com/iancoleman/orderedmap v0.3.0
-	golang.org/x/crypto v0.31.0
-)
+require golang.org/x/crypto v0.31.0
 
 require golang.org/x/sys v0.28.0 // indirect
@@ -4,11 +4,11 @@ import (
 	"bytes"
 	"crypto/ed25519"
 	"encoding/base64"
-	"errors"
+	"fmt"
+	"io"
 
 	"github.com/TankerHQ/identity-go/v3/internal/app"
 	"github.com/TankerHQ/identity-go/v3/internal/crypto"
-	"github.com/iancoleman/orderedmap"
 	"golang.org/x/crypto/blake2b"
 )
 
@@ -18,8 +18,16 @@ type publicIdentity struct {
 	Value        string `json:"value"`
 }
 
-type identity struct {
+// PublicPermanentIdentity is the public part of a permanent identity,
+// safe to share with other users
+type PublicPermanentIdentity struct {
 	publicIdentity
+}
+
+// SecretPermanentIdentity is the identity of a registered user, as
+// returned by Create
+type SecretPermanentIdentity struct {
+	PublicPermanentIdentity
 
 	DelegationSignature          []byte `json:"delegation_signature"`
 	EphemeralPublicSignatureKey  []byte `json:"ephemeral_public_signature_key"`
@@ -27,15 +35,19 @@ type identity struct {
 	UserSecret                   []byte `json:"user_secret"`
 }
 
-type publicProvisionalIdentity struct {
+// PublicProvisionalIdentity is the public part of a provisional identity,
+// safe to share with other users
+type PublicProvisionalIdentity struct {
 	publicIdentity
 
 	PublicSignatureKey  []byte `json:"public_signature_key"`
 	PublicEncryptionKey []byte `json:"public_encryption_key"`
 }
 
-type provisionalIdentity struct {
-	publicProvisionalIdentity
+// SecretProvisionalIdentity is the identity of a user who is not
+// registered yet, as returned by CreateProvisional
+type SecretProvisionalIdentity struct {
+	PublicProvisionalIdentity
 
 	PrivateSignatureKey  []byte `json:"private_signature_key"`
 	PrivateEncryptionKey []byte `json:"private_encryption_key"`
@@ -43,49 +55,56 @@ type provisionalIdentity struct {
 
 // Create returns a new identity crafted from config and userID
 func Create(config Config, userID string) (*string, error) {
-	conf, err := config.fromBase64()
-	if err != nil {
-		return nil, err
-	}
-	identity, err := generateIdentity(*conf, userID)
+	issuer, err := NewIssuer(config)
 	if err != nil {
 		return nil, err
 	}
-	return Encode(identity)
+	return issuer.Create(userID)
 }
 
 // CreateProvisional returns a new provisional identity crafted from
 // config, target and value
 func CreateProvisional(config Config, target string, value string) (*string, error) {
-	conf, err := config.fromBase64()
+	issuer, err := NewIssuer(config)
 	if err != nil {
 		return nil, err
 	}
+	return issuer.CreateProvisional(target, value)
+}
 
-	if target != "email" && target != "phone_number" {
-		return nil, errors.New("unsupported provisional identity target")
-	}
+// anyPublicIdentity is the public part of any identity, the keys of
+// provisional identities being left empty for permanent ones
+type anyPublicIdentity struct {
+	publicIdentity
 
-	provisional, err := generateProvisionalIdentity(*conf, target, value)
-	if err != nil {
-		return nil, err
-	}
-	return Encode(provisional)
+	PublicSignatureKey  []byte `json:"public_signature_key,omitempty"`
+	PublicEncryptionKey []byte `json:"public_encryption_key,omitempty"`
 }
 
 // GetPublicIdentity returns the public identity associated with the
 // provided identity
 func GetPublicIdentity(b64Identity string) (*string, error) {
-	type anyPublicIdentity struct {
-		publicIdentity
-
-		PublicSignatureKey  []byte `json:"public_signature_key,omitempty"`
-		PublicEncryptionKey []byte `json:"public_encryption_key,omitempty"`
+	parsed, err := ParseIdentity(b64Identity)
+	if err != nil {
+		return nil, err
 	}
 
-	publicIdentity := new(anyPublicIdentity)
-	if err := Decode(b64Identity, publicIdentity); err != nil {
-		return nil, err
+	var publicIdentity anyPublicIdentity
+	var privateSignatureKey []byte
+	switch parsed := parsed.(type) {
+	case *SecretPermanentIdentity:
+		publicIdentity.publicIdentity = parsed.publicIdentity
+	case *PublicPermanentIdentity:
+		publicIdentity.publicIdentity = parsed.publicIdentity
+	case *SecretProvisionalIdentity:
+		publicIdentity.publicIdentity = parsed.publicIdentity
+		publicIdentity.PublicSignatureKey = parsed.PublicSignatureKey
+		publicIdentity.PublicEncryptionKey = parsed.PublicEncryptionKey
+		privateSignatureKey = parsed.PrivateSignatureKey
+	case *PublicProvisionalIdentity:
+		publicIdentity.publicIdentity = parsed.publicIdentity
+		publicIdentity.PublicSignatureKey = parsed.PublicSignatureKey
+		publicIdentity.PublicEncryptionKey = parsed.PublicEncryptionKey
 	}
 
 	hashTarget := true
@@ -95,111 +114,84 @@ func GetPublicIdentity(b64Identity string) (*string, error) {
 	case "email":
 		publicIdentity.Value = hashProvisionalIdentityEmail(publicIdentity.Value)
 	case "phone_number":
-		privateIdentity := struct {
-			PrivateSignatureKey *string `json:"private_signature_key"`
-		}{}
-		// in practice this case should never happen since we are decoding into a
-		// more permissive type, and we already decoded above so there should be
-		// no problem with b64Identity itself
-		if err := Decode(b64Identity, &privateIdentity); err != nil {
-			return nil, err
-		}
-		privateSignatureKey := privateIdentity.PrivateSignatureKey
 		if privateSignatureKey == nil {
-			return nil, errors.New("invalid tanker identity")
+			return nil, fieldError(ErrMalformedIdentity, "private_signature_key", "is missing")
 		}
-		publicIdentity.Value = hashProvisionalIdentityValue(publicIdentity.Value, *privateSignatureKey)
+		publicIdentity.Value = hashProvisionalIdentityValue(publicIdentity.Value, privateSignatureKey)
 	default:
-		return nil, errors.New("unsupported identity target")
+		return nil, ErrUnsupportedTarget
 	}
 
 	if hashTarget {
 		publicIdentity.Target = "hashed_" + publicIdentity.Target
 	}
 
-	return Encode(publicIdentity)
+	return Encode(&publicIdentity)
 }
 
 // UpgradeIdentity upgrades the provided identity if needed and returns
-// the result of the upgrade
+// the result of the upgrade, running the steps of DefaultMigrations.
+// The identity is checked as by ParseIdentity, except that fields unknown
+// to this package are kept as they are rather than rejected, since they
+// may come from another version of the identity format.
 func UpgradeIdentity(b64Identity string) (*string, error) {
-	identity := orderedmap.New()
-	if err := Decode(b64Identity, &identity); err != nil {
+	result, err := defaultMigrations.Run(b64Identity)
+	if err != nil {
 		return nil, err
 	}
-
-	_, isPrivate := identity.Get("private_encryption_key")
-	target, found := identity.Get("target")
-	if !found {
-		return nil, errors.New("invalid provisional identity (missing target field)")
-	}
-
-	if target == "email" && !isPrivate {
-		identity.Set("target", "hashed_email")
-		value, valueFound := identity.Get("value")
-		if !valueFound {
-			return nil, errors.New("unsupported identity without value")
-		}
-
-		hashedEmail := blake2b.Sum256([]byte(value.(string)))
-		identity.Set("value", base64.StdEncoding.EncodeToString(hashedEmail[:]))
-	}
-
-	return Encode(identity)
+	return &result.Identity, nil
 }
 
 func checkKeysIntegrity(config config) error {
 	if !bytes.Equal(app.GetAppId(config.AppSecret), config.AppID) {
-		return errors.New("app secret and app ID mismatch")
+		return ErrAppIDMismatch
 	}
 	return nil
 }
 
-func generateIdentity(config config, userIDString string) (*identity, error) {
-	if err := checkKeysIntegrity(config); err != nil {
-		return nil, err
-	}
-
+func generateIdentity(config config, random io.Reader, userIDString string) (*SecretPermanentIdentity, error) {
 	userID := hashUserID(config.AppID, userIDString)
-	epubSignKey, eprivSignKey, err := ed25519.GenerateKey(nil)
+	epubSignKey, eprivSignKey, err := ed25519.GenerateKey(random)
 	if err != nil {
-		return nil, err
+		return nil, fmt.Errorf("%w: %w", ErrRandom, err)
+	}
+	userSecret, err := newUserSecret(random, userID)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrRandom, err)
 	}
 
 	payload := append(epubSignKey, userID...)
 	delegationSignature := ed25519.Sign(config.AppSecret, payload)
 
-	identity := identity{
-		publicIdentity: publicIdentity{
-			TrustchainID: config.AppID,
-			Target:       "user",
-			Value:        base64.StdEncoding.EncodeToString(userID),
+	identity := SecretPermanentIdentity{
+		PublicPermanentIdentity: PublicPermanentIdentity{
+			publicIdentity: publicIdentity{
+				TrustchainID: config.AppID,
+				Target:       "user",
+				Value:        base64.StdEncoding.EncodeToString(userID),
+			},
 		},
 		DelegationSignature:          delegationSignature,
 		EphemeralPrivateSignatureKey: eprivSignKey,
 		EphemeralPublicSignatureKey:  epubSignKey,
-		UserSecret:                   newUserSecret(userID),
+		UserSecret:                   userSecret,
 	}
 
 	return &identity, nil
 }
 
-func generateProvisionalIdentity(config config, target string, value string) (*provisionalIdentity, error) {
-	if err := checkKeysIntegrity(config); err != nil {
-		return nil, err
-	}
-
-	publicSignatureKey, privateSignatureKey, err := ed25519.GenerateKey(nil)
+func generateProvisionalIdentity(config config, random io.Reader, target string, value string) (*SecretProvisionalIdentity, error) {
+	publicSignatureKey, privateSignatureKey, err := ed25519.GenerateKey(random)
 	if err != nil {
-		return nil, err
+		return nil, fmt.Errorf("%w: %w", ErrRandom, err)
 	}
-	publicEncryptionKey, privateEncryptionKey, err := crypto.NewKeyPair()
+	publicEncryptionKey, privateEncryptionKey, err := crypto.NewKeyPair(random)
 	if err != nil {
-		return nil, err
+		return nil, fmt.Errorf("%w: %w", ErrRandom, err)
 	}
 
-	provisionalIdentity := provisionalIdentity{
-		publicProvisionalIdentity: publicProvisionalIdentity{
+	provisionalIdentity := SecretProvisionalIdentity{
+		PublicProvisionalIdentity: PublicProvisionalIdentity{
 			publicIdentity: publicIdentity{
 				TrustchainID: config.AppID,
 				Target:       target,
@@ -220,8 +212,7 @@ func hashProvisionalIdentityEmail(email string) (hash string) {
 	return base64.StdEncoding.EncodeToString(hashedValue[:])
 }
 
-func hashProvisionalIdentityValue(value string, privateSignatureKeyB64 string) (hash string) {
-	privateSignatureKey, _ := base64.StdEncoding.DecodeString(privateSignatureKeyB64)
+func hashProvisionalIdentityValue(value string, privateSignatureKey []byte) (hash string) {
 	hashSalt := blake2b.Sum256(privateSignatureKey)
 	hashedValue := blake2b.Sum256(append(hashSalt[:], value...))
 	return base64.StdEncoding.EncodeToString(hashedValue[:])
@@ -39,3 +39,36 @@ func BenchmarkUpgradeIdentity(b *testing.B) {
 		identity.UpgradeIdentity(*ident) //nolint: errcheck
 	}
 }
+
+func BenchmarkIssuer_Create(b *testing.B) {
+	issuer, _ := identity.NewIssuer(validConf)
+	for i := 0; i < b.N; i++ {
+		issuer.Create("userID") //nolint: errcheck
+	}
+}
+
+func BenchmarkIssuer_CreateProvisional(b *testing.B) {
+	issuer, _ := identity.NewIssuer(validConf)
+	for _, target := range validTargets {
+		b.Run(target, func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				issuer.CreateProvisional(target, "userID") //nolint: errcheck
+			}
+		})
+	}
+}
+
+func BenchmarkIssuer_Create_Parallel(b *testing.B) {
+	issuer, _ := identity.NewIssuer(validConf)
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			issuer.Create("userID") //nolint: errcheck
+		}
+	})
+}
+
+func BenchmarkPublicIdentityFromUserID(b *testing.B) {
+	for i := 0; i < b.N; i++ {
+		identity.PublicIdentityFromUserID(validAppId, "userID") //nolint: errcheck
+	}
+}
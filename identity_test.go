@@ -2,6 +2,7 @@ package identity_test
 
 import (
 	"bytes"
+	"crypto/ed25519"
 	"crypto/rand"
 	"encoding/base64"
 	"errors"
@@ -23,7 +24,7 @@ func base64id(n int) string {
 }
 
 var (
-	goodAppSecretRaw   = byteArray(app.AppSecretSize)
+	goodAppSecretRaw   = []byte(ed25519.NewKeyFromSeed(byteArray(ed25519.SeedSize)))
 	validAppSecret     = base64.StdEncoding.EncodeToString(goodAppSecretRaw)
 	wrongSizeAppSecret = base64.StdEncoding.EncodeToString(goodAppSecretRaw[2:])
 
@@ -138,12 +139,6 @@ func TestCreate_Error(t *testing.T) {
 	})
 
 	t.Run("DisruptedRandReader", func(t *testing.T) {
-		defer func() {
-			if err := recover(); err == nil {
-				t.Fatal("no panic creating identity")
-			}
-		}()
-
 		r := rand.Reader
 		defer func() {
 			rand.Reader = r
@@ -151,8 +146,8 @@ func TestCreate_Error(t *testing.T) {
 
 		rand.Reader = &singleSuccessReader{r: r, n: 0}
 		_, err := identity.Create(validConf, "userID")
-		if err != nil {
-			t.Fatal("error creating identity")
+		if err == nil {
+			t.Fatal("no error creating identity")
 		}
 	})
 }
@@ -319,19 +314,34 @@ func TestUpgradeIdentity(t *testing.T) {
 	}
 
 	t.Run("EmailNotPrivate", func(t *testing.T) {
-		fakeIdentity := map[string]string{
-			"target": "email",
-			"value":  "value",
+		prov, err := identity.CreateProvisional(validConf, "email", "value")
+		if err != nil {
+			panic("error creating provisional identity")
 		}
 
-		fakeIdentityEncoded, _ := identity.Encode(fakeIdentity)
-		_, err := identity.UpgradeIdentity(*fakeIdentityEncoded)
+		_, err = identity.UpgradeIdentity(withoutPrivateKeys(*prov))
 		if err != nil {
 			t.Fatal("error upgrading identity")
 		}
 	})
 }
 
+// withoutPrivateKeys returns b64Identity, a secret provisional identity,
+// as the legacy public provisional identities with an unhashed value
+func withoutPrivateKeys(b64Identity string) string {
+	decoded := map[string]interface{}{}
+	if err := identity.Decode(b64Identity, &decoded); err != nil {
+		panic("error decoding identity")
+	}
+	delete(decoded, "private_encryption_key")
+	delete(decoded, "private_signature_key")
+	encoded, err := identity.Encode(decoded)
+	if err != nil {
+		panic("error encoding identity")
+	}
+	return *encoded
+}
+
 func TestUpgradeIdentity_Error(t *testing.T) {
 	t.Run("BadBase64", func(t *testing.T) {
 		_, err := identity.UpgradeIdentity(notBase64Identity)
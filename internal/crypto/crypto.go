@@ -2,27 +2,62 @@ package crypto
 
 import (
 	"crypto/rand"
+	"errors"
+	"io"
 
 	"golang.org/x/crypto/curve25519"
 )
 
+// KeySize is the length of both public and private encryption keys,
+// in bytes
+const KeySize = 32
+
 // NewKeyPair returns a pair of cryptographic keys that can later
-// be used for encryption, along with an error if one occurs
-func NewKeyPair() ([]byte, []byte, error) {
+// be used for encryption, along with an error if one occurs.
+// The private key is read from random, or from crypto/rand.Reader
+// if random is nil.
+func NewKeyPair(random io.Reader) ([]byte, []byte, error) {
 	var (
-		sk [32]byte
-		pk [32]byte
+		sk [KeySize]byte
+		pk [KeySize]byte
 	)
 
-	if _, err := rand.Read(sk[:]); err != nil {
+	if random == nil {
+		random = rand.Reader
+	}
+	if _, err := io.ReadFull(random, sk[:]); err != nil {
 		return nil, nil, err
 	}
 
-	sk[0] &= 248
-	sk[31] &= 127
-	sk[31] |= 64
+	clamp(&sk)
 
 	curve25519.ScalarBaseMult(&pk, &sk)
 
 	return pk[:], sk[:], nil
 }
+
+// PublicKey returns the public encryption key matching the private
+// encryption key sk
+func PublicKey(sk []byte) ([]byte, error) {
+	var (
+		clamped [KeySize]byte
+		pk      [KeySize]byte
+	)
+
+	if len(sk) != KeySize {
+		return nil, errors.New("wrong private encryption key size")
+	}
+
+	copy(clamped[:], sk)
+	clamp(&clamped)
+
+	curve25519.ScalarBaseMult(&pk, &clamped)
+
+	return pk[:], nil
+}
+
+func clamp(sk *[KeySize]byte) {
+	sk[0] &= 248
+	sk[31] &= 127
+	sk[31] |= 64
+}
@@ -8,6 +8,6 @@ import (
 
 func BenchmarkNewKeyPair(b *testing.B) {
 	for i := 0; i < b.N; i++ {
-		crypto.NewKeyPair() //nolint: errcheck
+		crypto.NewKeyPair(nil) //nolint: errcheck
 	}
 }
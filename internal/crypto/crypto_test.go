@@ -9,19 +9,19 @@ import (
 )
 
 func TestNewKeyPair(t *testing.T) {
-	sk1, pk1, err := crypto.NewKeyPair()
+	sk1, pk1, err := crypto.NewKeyPair(nil)
 	if err != nil {
 		t.Fatal("error generating key pair")
 	}
-	sk2, pk2, err := crypto.NewKeyPair()
+	sk2, pk2, err := crypto.NewKeyPair(nil)
 	if err != nil {
 		t.Fatal("error generating key pair")
 	}
-	sk3, pk3, err := crypto.NewKeyPair()
+	sk3, pk3, err := crypto.NewKeyPair(nil)
 	if err != nil {
 		t.Fatal("error generating key pair")
 	}
-	sk4, pk4, err := crypto.NewKeyPair()
+	sk4, pk4, err := crypto.NewKeyPair(nil)
 	if err != nil {
 		t.Fatal("error generating key pair")
 	}
@@ -46,10 +46,65 @@ func TestNewKeyPair(t *testing.T) {
 }
 
 func TestNewKeyPair_Error(t *testing.T) {
+	r := rand.Reader
+	defer func() {
+		rand.Reader = r
+	}()
+
 	buf := bytes.NewBuffer(nil)
 	rand.Reader = buf
-	_, _, err := crypto.NewKeyPair()
+	_, _, err := crypto.NewKeyPair(nil)
 	if err == nil {
 		t.Fatal("no error generating key pair with invalid rand.Reader")
 	}
+
+	_, _, err = crypto.NewKeyPair(bytes.NewBuffer(nil))
+	if err == nil {
+		t.Fatal("no error generating key pair with invalid random source")
+	}
+}
+
+func TestPublicKey(t *testing.T) {
+	pk, sk, err := crypto.NewKeyPair(nil)
+	if err != nil {
+		t.Fatal("error generating key pair")
+	}
+
+	derived, err := crypto.PublicKey(sk)
+	if err != nil {
+		t.Fatal("error deriving public key")
+	}
+	if !bytes.Equal(pk, derived) {
+		t.Fatal("derived public key does not match")
+	}
+
+	_, err = crypto.PublicKey(sk[1:])
+	if err == nil {
+		t.Fatal("no error deriving public key from truncated private key")
+	}
+}
+
+func TestNewKeyPair_Random(t *testing.T) {
+	seed := make([]byte, crypto.KeySize)
+	_, err := rand.Read(seed)
+	if err != nil {
+		panic("err should be nil")
+	}
+
+	pk1, sk1, err := crypto.NewKeyPair(bytes.NewReader(seed))
+	if err != nil {
+		t.Fatal("error generating key pair")
+	}
+	pk2, sk2, err := crypto.NewKeyPair(bytes.NewReader(seed))
+	if err != nil {
+		t.Fatal("error generating key pair")
+	}
+	if !bytes.Equal(pk1, pk2) || !bytes.Equal(sk1, sk2) {
+		t.Fatal("key pairs generated from the same random source differ")
+	}
+
+	_, _, err = crypto.NewKeyPair(bytes.NewReader(seed[1:]))
+	if err == nil {
+		t.Fatal("no error generating key pair with short random source")
+	}
 }
@@ -0,0 +1,205 @@
+// Package ordered provides a JSON object which keeps its keys in order, so
+// that decoding and encoding it again preserves the order of its fields
+package ordered
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+)
+
+// Map is a JSON object whose keys are kept in insertion order. Nested
+// objects are decoded as *Map and arrays as []interface{}, other values
+// are decoded as by encoding/json into an interface{}.
+type Map struct {
+	keys   []string
+	values map[string]interface{}
+}
+
+// New returns an empty Map
+func New() *Map {
+	return &Map{values: map[string]interface{}{}}
+}
+
+// Get returns the value of key, and whether it is present
+func (m *Map) Get(key string) (interface{}, bool) {
+	value, found := m.values[key]
+	return value, found
+}
+
+// Set sets the value of key. A new key is added after the existing ones,
+// an existing key keeps its position.
+func (m *Map) Set(key string, value interface{}) {
+	if m.values == nil {
+		m.values = map[string]interface{}{}
+	}
+	if _, found := m.values[key]; !found {
+		m.keys = append(m.keys, key)
+	}
+	m.values[key] = value
+}
+
+// Delete removes key, if present
+func (m *Map) Delete(key string) {
+	if _, found := m.values[key]; !found {
+		return
+	}
+	delete(m.values, key)
+	m.removeKey(key)
+}
+
+func (m *Map) removeKey(key string) {
+	for i, k := range m.keys {
+		if k == key {
+			m.keys = append(m.keys[:i], m.keys[i+1:]...)
+			return
+		}
+	}
+}
+
+// Keys returns the keys of m, in order
+func (m *Map) Keys() []string {
+	return append([]string(nil), m.keys...)
+}
+
+// SortKeys reorders the keys of m with sortFunc. Nested objects are left
+// untouched.
+func (m *Map) SortKeys(sortFunc func(keys []string)) {
+	sortFunc(m.keys)
+}
+
+// UnmarshalJSON implements json.Unmarshaler. Only JSON objects can be
+// decoded. When a key is repeated, the last value wins and the key takes
+// the position of its last occurrence.
+func (m *Map) UnmarshalJSON(b []byte) error {
+	dec := json.NewDecoder(bytes.NewReader(b))
+	token, err := dec.Token()
+	if err != nil {
+		return err
+	}
+	if token != json.Delim('{') {
+		return errors.New("ordered: cannot unmarshal a non-object into a Map")
+	}
+	*m = Map{values: map[string]interface{}{}}
+	return m.decode(dec)
+}
+
+func (m *Map) decode(dec *json.Decoder) error {
+	for {
+		token, err := dec.Token()
+		if err != nil {
+			return err
+		}
+		if token == json.Delim('}') {
+			return nil
+		}
+		key := token.(string)
+		value, err := decodeValue(dec)
+		if err != nil {
+			return err
+		}
+		if _, found := m.values[key]; found {
+			m.removeKey(key)
+		}
+		m.keys = append(m.keys, key)
+		m.values[key] = value
+	}
+}
+
+func decodeValue(dec *json.Decoder) (interface{}, error) {
+	token, err := dec.Token()
+	if err != nil {
+		return nil, err
+	}
+	switch token {
+	case json.Delim('{'):
+		nested := New()
+		if err := nested.decode(dec); err != nil {
+			return nil, err
+		}
+		return nested, nil
+	case json.Delim('['):
+		array := []interface{}{}
+		for dec.More() {
+			elem, err := decodeValue(dec)
+			if err != nil {
+				return nil, err
+			}
+			array = append(array, elem)
+		}
+		// consume ']'
+		if _, err := dec.Token(); err != nil {
+			return nil, err
+		}
+		return array, nil
+	default:
+		return token, nil
+	}
+}
+
+// MarshalJSON implements json.Marshaler, writing keys in order
+func (m *Map) MarshalJSON() ([]byte, error) {
+	return m.AppendJSON(nil)
+}
+
+// AppendJSON appends the compact JSON encoding of m to buf. Strings are
+// escaped as by encoding/json, including HTML characters.
+func (m *Map) AppendJSON(buf []byte) ([]byte, error) {
+	buf = append(buf, '{')
+	for i, key := range m.keys {
+		if i > 0 {
+			buf = append(buf, ',')
+		}
+		buf = AppendString(buf, key)
+		buf = append(buf, ':')
+		var err error
+		if buf, err = appendValue(buf, m.values[key]); err != nil {
+			return nil, err
+		}
+	}
+	return append(buf, '}'), nil
+}
+
+func appendValue(buf []byte, value interface{}) ([]byte, error) {
+	switch value := value.(type) {
+	case *Map:
+		return value.AppendJSON(buf)
+	case []interface{}:
+		buf = append(buf, '[')
+		for i, elem := range value {
+			if i > 0 {
+				buf = append(buf, ',')
+			}
+			var err error
+			if buf, err = appendValue(buf, elem); err != nil {
+				return nil, err
+			}
+		}
+		return append(buf, ']'), nil
+	case string:
+		return AppendString(buf, value), nil
+	default:
+		encoded, err := json.Marshal(value)
+		if err != nil {
+			return nil, fmt.Errorf("ordered: %w", err)
+		}
+		return append(buf, encoded...), nil
+	}
+}
+
+// AppendString appends s to buf as a JSON string, escaped as by
+// encoding/json
+func AppendString(buf []byte, s string) []byte {
+	for i := 0; i < len(s); i++ {
+		if c := s[i]; c < 0x20 || c >= 0x7f || c == '"' || c == '\\' || c == '<' || c == '>' || c == '&' {
+			// rare enough to leave it to encoding/json, which never
+			// fails on strings
+			encoded, _ := json.Marshal(s)
+			return append(buf, encoded...)
+		}
+	}
+	buf = append(buf, '"')
+	buf = append(buf, s...)
+	return append(buf, '"')
+}
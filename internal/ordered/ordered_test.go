@@ -0,0 +1,54 @@
+package ordered_test
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+
+	"github.com/TankerHQ/identity-go/v3/internal/ordered"
+)
+
+func TestMap_RoundTrip(t *testing.T) {
+	input := `{"z":1,"a":{"y":[{"d":null,"c":"<&>"}],"x":true},"m":1.5,"z":2}`
+	expected := `{"a":{"y":[{"d":null,"c":"\u003c\u0026\u003e"}],"x":true},"m":1.5,"z":2}`
+
+	m := ordered.New()
+	if err := json.Unmarshal([]byte(input), m); err != nil {
+		t.Fatal("error unmarshalling:", err)
+	}
+	if !reflect.DeepEqual(m.Keys(), []string{"a", "m", "z"}) {
+		t.Fatal("wrong key order:", m.Keys())
+	}
+	buf, err := json.Marshal(m)
+	if err != nil {
+		t.Fatal("error marshalling:", err)
+	}
+	if string(buf) != expected {
+		t.Fatalf("expected %s, got %s", expected, buf)
+	}
+}
+
+func TestMap_SetDelete(t *testing.T) {
+	m := ordered.New()
+	m.Set("b", 1)
+	m.Set("a", 2)
+	m.Set("b", 3)
+	m.Delete("a")
+	m.Delete("missing")
+	m.Set("a", 4)
+
+	if !reflect.DeepEqual(m.Keys(), []string{"b", "a"}) {
+		t.Fatal("wrong key order:", m.Keys())
+	}
+	if value, found := m.Get("b"); !found || value != 3 {
+		t.Fatal("wrong value for b")
+	}
+}
+
+func TestMap_Unmarshal_Error(t *testing.T) {
+	for _, input := range []string{`[1]`, `"str"`, `null`, `{"a":}`} {
+		if err := json.Unmarshal([]byte(input), ordered.New()); err == nil {
+			t.Fatalf("no error unmarshalling %s", input)
+		}
+	}
+}
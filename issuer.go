@@ -0,0 +1,108 @@
+package identity
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"fmt"
+	"io"
+)
+
+// Issuer creates identities for a single app. Its config is decoded and
+// validated once by NewIssuer, which makes it cheaper than the package
+// level functions when creating many identities.
+//
+// An Issuer is safe for concurrent use by multiple goroutines.
+type Issuer struct {
+	config config
+	random io.Reader
+}
+
+// Option configures an Issuer
+type Option func(*Issuer)
+
+// WithRandom makes the Issuer read all the random bytes it needs to
+// create identities from random instead of crypto/rand.Reader. Failures
+// to read from random are returned as errors by the Issuer methods.
+//
+// Identities created from a predictable source are not secure: this is
+// meant for reproducible test fixtures, or to plug in a vetted
+// deterministic random bit generator. If the Issuer is used by several
+// goroutines, random must be safe for concurrent use.
+func WithRandom(random io.Reader) Option {
+	return func(i *Issuer) {
+		i.random = random
+	}
+}
+
+// NewIssuer returns an Issuer for the app described by config, or an
+// error if config is invalid
+func NewIssuer(config Config, opts ...Option) (*Issuer, error) {
+	conf, err := config.fromBase64()
+	if err != nil {
+		return nil, err
+	}
+	if err := checkKeysIntegrity(*conf); err != nil {
+		return nil, err
+	}
+
+	issuer := &Issuer{config: *conf}
+	for _, opt := range opts {
+		opt(issuer)
+	}
+	return issuer, nil
+}
+
+// Create returns a new identity for userID
+func (i *Issuer) Create(userID string) (*string, error) {
+	identity, err := generateIdentity(i.config, i.rand(), userID)
+	if err != nil {
+		return nil, err
+	}
+	return Encode(identity)
+}
+
+// CreateProvisional returns a new provisional identity for target and
+// value
+func (i *Issuer) CreateProvisional(target string, value string) (*string, error) {
+	if target != "email" && target != "phone_number" {
+		return nil, ErrUnsupportedTarget
+	}
+
+	provisional, err := generateProvisionalIdentity(i.config, i.rand(), target, value)
+	if err != nil {
+		return nil, err
+	}
+	return Encode(provisional)
+}
+
+// AppID returns the ID of the app identities are created for
+func (i *Issuer) AppID() string {
+	return base64.StdEncoding.EncodeToString(i.config.AppID)
+}
+
+// PublicIdentity returns the public identity of userID, as
+// PublicIdentityFromUserID does
+func (i *Issuer) PublicIdentity(userID string) (*string, error) {
+	return Encode(newPublicPermanentIdentity(i.config.AppID, userID))
+}
+
+func (i *Issuer) rand() io.Reader {
+	if i.random != nil {
+		return i.random
+	}
+	return rand.Reader
+}
+
+// String implements fmt.Stringer without revealing the app secret
+func (i *Issuer) String() string {
+	return fmt.Sprintf("identity.Issuer{AppID:%s}", i.AppID())
+}
+
+// Format implements fmt.Formatter so that no verb prints the app secret
+func (i *Issuer) Format(f fmt.State, verb rune) {
+	if verb == 'q' {
+		fmt.Fprintf(f, "%q", i.String())
+		return
+	}
+	io.WriteString(f, i.String()) //nolint: errcheck
+}
@@ -0,0 +1,190 @@
+package identity_test
+
+import (
+	"bytes"
+	"crypto/rand"
+	"io"
+	"sync"
+	"testing"
+
+	"github.com/TankerHQ/identity-go/v3"
+)
+
+func TestNewIssuer_Error(t *testing.T) {
+	for _, conf := range badConfsVector {
+		t.Run(conf.desc, func(t *testing.T) {
+			_, err := identity.NewIssuer(conf.config)
+			if err == nil {
+				t.Fatal("no error creating issuer")
+			}
+		})
+	}
+}
+
+func TestIssuer_Create(t *testing.T) {
+	issuer, err := identity.NewIssuer(validConf)
+	if err != nil {
+		t.Fatal("error creating issuer with valid config")
+	}
+
+	id, err := issuer.Create("userID")
+	if err != nil || id == nil || *id == "" {
+		t.Fatal("error creating identity")
+	}
+	if err := identity.VerifyIdentity(validConf, *id, "userID"); err != nil {
+		t.Fatal("issued identity is invalid:", err)
+	}
+}
+
+func TestIssuer_CreateProvisional(t *testing.T) {
+	issuer, err := identity.NewIssuer(validConf)
+	if err != nil {
+		t.Fatal("error creating issuer with valid config")
+	}
+
+	for _, target := range validTargets {
+		t.Run("Target/"+target, func(t *testing.T) {
+			id, err := issuer.CreateProvisional(target, "userID")
+			if err != nil || id == nil || *id == "" {
+				t.Fatal("error creating provisional identity")
+			}
+			if err := identity.ValidateProvisionalIdentity(*id); err != nil {
+				t.Fatal("issued provisional identity is invalid:", err)
+			}
+		})
+	}
+
+	t.Run("InvalidTarget", func(t *testing.T) {
+		_, err := issuer.CreateProvisional(invalidTarget, "userID")
+		if err == nil {
+			t.Fatal("no error creating provisional identity")
+		}
+	})
+}
+
+func TestIssuer_PublicIdentity(t *testing.T) {
+	issuer, err := identity.NewIssuer(validConf)
+	if err != nil {
+		t.Fatal("error creating issuer with valid config")
+	}
+
+	pub, err := issuer.PublicIdentity("userID")
+	if err != nil {
+		t.Fatal("error getting public identity")
+	}
+	expected, err := identity.PublicIdentityFromUserID(validAppId, "userID")
+	if err != nil {
+		panic("error computing public identity")
+	}
+	if *pub != *expected {
+		t.Fatal("public identities differ")
+	}
+	if issuer.AppID() != validAppId {
+		t.Fatal("wrong app ID")
+	}
+}
+
+func TestIssuer_Concurrent(t *testing.T) {
+	issuer, err := identity.NewIssuer(validConf)
+	if err != nil {
+		t.Fatal("error creating issuer with valid config")
+	}
+
+	const workers = 16
+	errs := make(chan error, workers*3)
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			id, err := issuer.Create("userID")
+			if err == nil {
+				err = identity.VerifyIdentity(validConf, *id, "userID")
+			}
+			errs <- err
+			_, err = issuer.CreateProvisional(validTargets[0], "userID")
+			errs <- err
+			_, err = issuer.PublicIdentity("userID")
+			errs <- err
+		}()
+	}
+	wg.Wait()
+	close(errs)
+
+	for err := range errs {
+		if err != nil {
+			t.Fatal("error using issuer concurrently:", err)
+		}
+	}
+}
+
+func TestIssuer_WithRandom(t *testing.T) {
+	seed := byteArray(1024)
+
+	newIssuer := func() *identity.Issuer {
+		issuer, err := identity.NewIssuer(validConf, identity.WithRandom(bytes.NewReader(seed)))
+		if err != nil {
+			panic("error creating issuer")
+		}
+		return issuer
+	}
+
+	t.Run("Create", func(t *testing.T) {
+		id1, err := newIssuer().Create("userID")
+		if err != nil {
+			t.Fatal("error creating identity")
+		}
+		id2, err := newIssuer().Create("userID")
+		if err != nil {
+			t.Fatal("error creating identity")
+		}
+		if *id1 != *id2 {
+			t.Fatal("identities created from the same random source differ")
+		}
+		if err := identity.VerifyIdentity(validConf, *id1, "userID"); err != nil {
+			t.Fatal("identity created from custom random source is invalid:", err)
+		}
+	})
+
+	for _, target := range validTargets {
+		t.Run("CreateProvisional/"+target, func(t *testing.T) {
+			id1, err := newIssuer().CreateProvisional(target, "userID")
+			if err != nil {
+				t.Fatal("error creating provisional identity")
+			}
+			id2, err := newIssuer().CreateProvisional(target, "userID")
+			if err != nil {
+				t.Fatal("error creating provisional identity")
+			}
+			if *id1 != *id2 {
+				t.Fatal("provisional identities created from the same random source differ")
+			}
+			if err := identity.ValidateProvisionalIdentity(*id1); err != nil {
+				t.Fatal("provisional identity created from custom random source is invalid:", err)
+			}
+		})
+	}
+}
+
+func TestIssuer_WithRandom_Error(t *testing.T) {
+	vectors := []struct {
+		desc   string
+		random io.Reader
+	}{
+		{desc: "Empty", random: bytes.NewReader(nil)},
+		{desc: "Short", random: bytes.NewReader(byteArray(40))},
+		{desc: "Disrupted", random: &singleSuccessReader{r: rand.Reader, n: 0}},
+	}
+
+	for _, vec := range vectors {
+		t.Run(vec.desc, func(t *testing.T) {
+			issuer, err := identity.NewIssuer(validConf, identity.WithRandom(vec.random))
+			if err != nil {
+				panic("error creating issuer")
+			}
+			if _, err := issuer.Create("userID"); err == nil {
+				t.Fatal("no error creating identity")
+			}
+		})
+	}
+}
@@ -0,0 +1,180 @@
+package identity
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+
+	"github.com/TankerHQ/identity-go/v3/internal/ordered"
+)
+
+// Fields are the decoded fields of an identity, in order, as seen by
+// migration steps. Values are decoded as by encoding/json into an
+// interface{}, except nested objects which are opaque and must be kept as
+// they are.
+type Fields struct {
+	m *ordered.Map
+}
+
+// Get returns the value of field, and whether it is present
+func (f *Fields) Get(field string) (interface{}, bool) {
+	return f.m.Get(field)
+}
+
+// Set sets the value of field. A new field is added after the existing
+// ones, Encode sorts them anyway.
+func (f *Fields) Set(field string, value interface{}) {
+	f.m.Set(field, value)
+}
+
+// Delete removes field, if present
+func (f *Fields) Delete(field string) {
+	f.m.Delete(field)
+}
+
+// Keys returns the names of the fields, in order
+func (f *Fields) Keys() []string {
+	return f.m.Keys()
+}
+
+// MigrationStep is a named change to the fields of identities, upgrading
+// them from a former version of the identity format
+type MigrationStep struct {
+	// Name identifies the step in MigrationResult
+	Name string
+	// Apply changes fields if the identity needs it, and returns whether
+	// it did. It must be idempotent: applying it to its own result must
+	// change nothing and return false.
+	Apply func(fields *Fields) (bool, error)
+}
+
+// MigrationResult describes the outcome of Migrations.Run
+type MigrationResult struct {
+	// Identity is the upgraded identity, or the identity given to
+	// Migrations.DryRun unchanged
+	Identity string
+	// Applied lists the names of the steps which changed the identity,
+	// or would have changed it in a dry run, in the order they ran
+	Applied []string
+}
+
+// Migrations is an ordered registry of migration steps. Steps run in the
+// order they were registered, each one seeing the changes of the previous
+// ones.
+type Migrations struct {
+	steps []MigrationStep
+}
+
+// NewMigrations returns a registry holding steps, or an error if two of
+// them have the same name
+func NewMigrations(steps ...MigrationStep) (*Migrations, error) {
+	m := &Migrations{}
+	for _, step := range steps {
+		if err := m.Register(step); err != nil {
+			return nil, err
+		}
+	}
+	return m, nil
+}
+
+// DefaultMigrations returns a new registry holding the steps run by
+// UpgradeIdentity, to which other steps can be registered
+func DefaultMigrations() *Migrations {
+	m, _ := NewMigrations(hashPublicEmailStep)
+	return m
+}
+
+var defaultMigrations = DefaultMigrations()
+
+// Register adds step after the steps already registered
+func (m *Migrations) Register(step MigrationStep) error {
+	if step.Name == "" || step.Apply == nil {
+		return errors.New("migration step needs a name and an Apply function")
+	}
+	for _, registered := range m.steps {
+		if registered.Name == step.Name {
+			return fmt.Errorf("migration step %s is already registered", step.Name)
+		}
+	}
+	m.steps = append(m.steps, step)
+	return nil
+}
+
+// Steps returns the names of the registered steps, in order
+func (m *Migrations) Steps() []string {
+	names := make([]string, 0, len(m.steps))
+	for _, step := range m.steps {
+		names = append(names, step.Name)
+	}
+	return names
+}
+
+// Run applies the registered steps to b64Identity, which is checked as by
+// UpgradeIdentity before and after the steps. Fields unknown to this
+// package are kept as they are. The identity is always returned in the
+// form written by Encode.
+func (m *Migrations) Run(b64Identity string) (*MigrationResult, error) {
+	return m.run(b64Identity, false)
+}
+
+// DryRun is like Run, but only reports the steps which would change
+// b64Identity and returns it unchanged
+func (m *Migrations) DryRun(b64Identity string) (*MigrationResult, error) {
+	return m.run(b64Identity, true)
+}
+
+func (m *Migrations) run(b64Identity string, dryRun bool) (*MigrationResult, error) {
+	if _, _, err := decodeStrict(b64Identity, true); err != nil {
+		return nil, err
+	}
+	// cannot fail, decodeStrict has decoded it already
+	buf, _ := base64.StdEncoding.DecodeString(b64Identity)
+	fields := &Fields{m: ordered.New()}
+	if err := unmarshalIdentity(buf, fields.m, true); err != nil {
+		return nil, err
+	}
+
+	result := &MigrationResult{Identity: b64Identity}
+	for _, step := range m.steps {
+		applied, err := step.Apply(fields)
+		if err != nil {
+			return nil, fmt.Errorf("migration step %s: %w", step.Name, err)
+		}
+		if applied {
+			result.Applied = append(result.Applied, step.Name)
+		}
+	}
+	if dryRun {
+		return result, nil
+	}
+
+	upgraded, err := Encode(fields.m)
+	if err != nil {
+		return nil, err
+	}
+	if _, _, err := decodeStrict(*upgraded, true); err != nil {
+		return nil, fmt.Errorf("migration steps %v produced an invalid identity: %w", result.Applied, err)
+	}
+	result.Identity = *upgraded
+	return result, nil
+}
+
+// hashPublicEmailStep turns the public provisional identities of former
+// versions, which hold the email unhashed, into hashed_email identities
+var hashPublicEmailStep = MigrationStep{
+	Name: "hash_public_email",
+	Apply: func(fields *Fields) (bool, error) {
+		target, _ := fields.Get("target")
+		if privateKey, _ := fields.Get("private_encryption_key"); target != "email" || privateKey != nil {
+			return false, nil
+		}
+		value, _ := fields.Get("value")
+		email, isString := value.(string)
+		if !isString {
+			return false, fieldError(ErrMalformedIdentity, "value", "should be a string")
+		}
+		fields.Set("target", "hashed_email")
+		fields.Set("value", hashProvisionalIdentityEmail(email))
+		return true, nil
+	},
+}
@@ -0,0 +1,190 @@
+package identity_test
+
+import (
+	"bytes"
+	"encoding/base64"
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/TankerHQ/identity-go/v3"
+)
+
+var (
+	legacyKey = func(c byte) string {
+		return base64.StdEncoding.EncodeToString(bytes.Repeat([]byte{c}, 32))
+	}
+
+	// a legacy public email identity with fields unknown to this package,
+	// not in canonical order
+	legacyIdentity = base64.StdEncoding.EncodeToString([]byte(`{"zz":[1,{"b":2,"a":1.50}],` +
+		`"public_signature_key":"` + legacyKey(3) + `","target":"email","aa":{"y":null,"x":"<"},` +
+		`"value":"alice@example.com","n":1e3,"trustchain_id":"` + legacyKey(1) + `",` +
+		`"public_encryption_key":"` + legacyKey(2) + `"}`))
+
+	// as returned by UpgradeIdentity since unknown fields are kept: they
+	// come first, nested objects keep their order, numbers and strings are
+	// re-encoded
+	upgradedLegacyIdentity = base64.StdEncoding.EncodeToString([]byte(`{"zz":[1,{"b":2,"a":1.5}],` +
+		`"aa":{"y":null,"x":"\u003c"},"n":1000,"trustchain_id":"` + legacyKey(1) + `",` +
+		`"target":"hashed_email","value":"SAeBAAEkgfnGV09Rl/wrz75L7jwZSv3kPcksVzo0X0M=",` +
+		`"public_encryption_key":"` + legacyKey(2) + `","public_signature_key":"` + legacyKey(3) + `"}`))
+)
+
+func TestUpgradeIdentity_KeepsUnknownFields(t *testing.T) {
+	upgraded, err := identity.UpgradeIdentity(legacyIdentity)
+	if err != nil {
+		t.Fatal("error upgrading identity:", err)
+	}
+	if *upgraded != upgradedLegacyIdentity {
+		t.Fatal("unknown fields not kept as they were")
+	}
+}
+
+func TestMigrations_Default(t *testing.T) {
+	m := identity.DefaultMigrations()
+	if !reflect.DeepEqual(m.Steps(), []string{"hash_public_email"}) {
+		t.Fatal("wrong default steps:", m.Steps())
+	}
+
+	result, err := m.Run(legacyIdentity)
+	if err != nil {
+		t.Fatal("error running migrations:", err)
+	}
+	if result.Identity != upgradedLegacyIdentity || !reflect.DeepEqual(result.Applied, []string{"hash_public_email"}) {
+		t.Fatal("wrong migration result")
+	}
+
+	// steps are idempotent
+	again, err := m.Run(result.Identity)
+	if err != nil {
+		t.Fatal("error running migrations:", err)
+	}
+	if again.Identity != result.Identity || len(again.Applied) != 0 {
+		t.Fatal("migrations are not idempotent")
+	}
+
+	id, _ := identity.Create(validConf, "userID")
+	prov, _ := identity.CreateProvisional(validConf, "email", "alice@example.com")
+	for _, b64 := range []string{*id, *prov} {
+		result, err := m.Run(b64)
+		if err != nil {
+			t.Fatal("error running migrations:", err)
+		}
+		if result.Identity != b64 || len(result.Applied) != 0 {
+			t.Fatal("up-to-date identity changed by migrations")
+		}
+	}
+}
+
+func TestMigrations_DryRun(t *testing.T) {
+	result, err := identity.DefaultMigrations().DryRun(legacyIdentity)
+	if err != nil {
+		t.Fatal("error running migrations:", err)
+	}
+	if result.Identity != legacyIdentity {
+		t.Fatal("identity changed by dry run")
+	}
+	if !reflect.DeepEqual(result.Applied, []string{"hash_public_email"}) {
+		t.Fatal("wrong steps reported by dry run:", result.Applied)
+	}
+}
+
+func TestMigrations_Register(t *testing.T) {
+	var seen []string
+	renameStep := identity.MigrationStep{
+		Name: "rename_legacy",
+		Apply: func(fields *identity.Fields) (bool, error) {
+			// runs after hash_public_email
+			seen = fields.Keys()
+			if _, found := fields.Get("legacy"); !found {
+				return false, nil
+			}
+			fields.Delete("legacy")
+			fields.Set("renamed", true)
+			return true, nil
+		},
+	}
+	noopStep := identity.MigrationStep{
+		Name:  "noop",
+		Apply: func(*identity.Fields) (bool, error) { return false, nil },
+	}
+
+	m := identity.DefaultMigrations()
+	if err := m.Register(renameStep); err != nil {
+		t.Fatal("error registering step:", err)
+	}
+	if err := m.Register(noopStep); err != nil {
+		t.Fatal("error registering step:", err)
+	}
+	if err := m.Register(noopStep); err == nil {
+		t.Fatal("no error registering a step twice")
+	}
+	if err := m.Register(identity.MigrationStep{Name: "nil"}); err == nil {
+		t.Fatal("no error registering a step without Apply")
+	}
+
+	withLegacy := modifyIdentity(legacyIdentity, func(decoded map[string]interface{}) { decoded["legacy"] = 1 })
+	result, err := m.Run(withLegacy)
+	if err != nil {
+		t.Fatal("error running migrations:", err)
+	}
+	if !reflect.DeepEqual(result.Applied, []string{"hash_public_email", "rename_legacy"}) {
+		t.Fatal("wrong steps applied:", result.Applied)
+	}
+	if len(seen) == 0 {
+		t.Fatal("step did not run")
+	}
+	decoded := map[string]interface{}{}
+	if err := identity.Decode(result.Identity, &decoded); err != nil {
+		t.Fatal("error decoding identity:", err)
+	}
+	if _, found := decoded["legacy"]; found || decoded["renamed"] != true || decoded["target"] != "hashed_email" {
+		t.Fatal("steps not applied")
+	}
+}
+
+func TestMigrations_Error(t *testing.T) {
+	failure := errors.New("step failure")
+	vectors := []struct {
+		desc     string
+		step     identity.MigrationStep
+		sentinel error
+	}{
+		{
+			desc: "StepError",
+			step: identity.MigrationStep{
+				Name:  "fail",
+				Apply: func(*identity.Fields) (bool, error) { return false, failure },
+			},
+			sentinel: failure,
+		},
+		{
+			desc: "InvalidResult",
+			step: identity.MigrationStep{
+				Name: "drop_trustchain_id",
+				Apply: func(fields *identity.Fields) (bool, error) {
+					fields.Delete("trustchain_id")
+					return true, nil
+				},
+			},
+			sentinel: identity.ErrMalformedIdentity,
+		},
+	}
+
+	for _, vec := range vectors {
+		t.Run(vec.desc, func(t *testing.T) {
+			m, err := identity.NewMigrations(vec.step)
+			if err != nil {
+				t.Fatal("error creating migrations:", err)
+			}
+			if _, err := m.Run(legacyIdentity); !errors.Is(err, vec.sentinel) {
+				t.Fatalf("expected %v, got %v", vec.sentinel, err)
+			}
+		})
+	}
+
+	if _, err := identity.DefaultMigrations().Run(notBase64Identity); !errors.Is(err, identity.ErrMalformedIdentity) {
+		t.Fatal("expected ErrMalformedIdentity, got", err)
+	}
+}
@@ -0,0 +1,116 @@
+package identity
+
+import (
+	"encoding/base64"
+	"fmt"
+	"strings"
+
+	"github.com/TankerHQ/identity-go/v3/internal/ordered"
+)
+
+// Normalization is a set of the repairs NormalizeIdentity applied to an
+// identity
+type Normalization uint
+
+const (
+	// NormalizedWhitespace means whitespace around or inside the identity
+	// was removed
+	NormalizedWhitespace Normalization = 1 << iota
+	// NormalizedURLEncoding means the identity was encoded with the
+	// URL-safe base64 alphabet
+	NormalizedURLEncoding
+	// NormalizedPadding means the base64 padding of the identity was
+	// missing
+	NormalizedPadding
+	// NormalizedRawJSON means the identity was given as JSON rather than
+	// base64, NormalizedJSON is then implied and not reported
+	NormalizedRawJSON
+	// NormalizedJSON means the JSON of the identity was not in the form
+	// written by Encode, for instance its fields were in another order
+	NormalizedJSON
+)
+
+var normalizationNames = []string{
+	"whitespace",
+	"url encoding",
+	"padding",
+	"raw json",
+	"json",
+}
+
+// Has returns whether all the normalizations of flags were applied
+func (n Normalization) Has(flags Normalization) bool {
+	return n&flags == flags
+}
+
+func (n Normalization) String() string {
+	if n == 0 {
+		return "none"
+	}
+	var names []string
+	for i, name := range normalizationNames {
+		if n.Has(1 << i) {
+			names = append(names, name)
+		}
+	}
+	return strings.Join(names, ", ")
+}
+
+// NormalizeIdentity repairs identities received from other systems, such
+// as identities which went through URLs or headers, or were encoded by
+// other SDKs. It accepts URL-safe base64, base64 without padding, stray
+// whitespace and raw JSON, and returns the identity in the form written by
+// Encode, along with the normalizations which were needed. Once repaired,
+// the identity is checked as by ParseIdentity.
+//
+// The other functions of this package only accept identities in the form
+// written by Encode.
+func NormalizeIdentity(input string) (*string, Normalization, error) {
+	// whitespace and padding may make an identity longer than
+	// MaxIdentityLength, the repaired identity is checked again below
+	if len(input) > 2*MaxIdentityLength {
+		return nil, 0, fmt.Errorf("%w: identity is %d bytes long, more than the maximum of %d", ErrMalformedIdentity, len(input), MaxIdentityLength)
+	}
+
+	var normalization Normalization
+	trimmed := strings.TrimSpace(input)
+	var b64Identity string
+	if strings.HasPrefix(trimmed, "{") {
+		normalization |= NormalizedRawJSON
+		b64Identity = base64.StdEncoding.EncodeToString([]byte(trimmed))
+	} else {
+		b64Identity = strings.Join(strings.Fields(trimmed), "")
+		if b64Identity != input {
+			normalization |= NormalizedWhitespace
+		}
+		if strings.ContainsAny(b64Identity, "-_") {
+			if strings.ContainsAny(b64Identity, "+/") {
+				return nil, 0, fmt.Errorf("%w: identity mixes the standard and URL-safe base64 alphabets", ErrMalformedIdentity)
+			}
+			normalization |= NormalizedURLEncoding
+			b64Identity = strings.NewReplacer("-", "+", "_", "/").Replace(b64Identity)
+		}
+		if missing := len(b64Identity) % 4; missing != 0 && !strings.HasSuffix(b64Identity, "=") {
+			normalization |= NormalizedPadding
+			b64Identity += strings.Repeat("=", 4-missing)
+		}
+	}
+
+	if _, _, err := decodeStrict(b64Identity, false); err != nil {
+		return nil, 0, err
+	}
+	// cannot fail, decodeStrict has decoded it already
+	buf, _ := base64.StdEncoding.DecodeString(b64Identity)
+	identity := ordered.New()
+	if err := unmarshalIdentity(buf, identity, false); err != nil {
+		return nil, 0, err
+	}
+	canonical, err := Encode(identity)
+	if err != nil {
+		return nil, 0, err
+	}
+	if *canonical != b64Identity && !normalization.Has(NormalizedRawJSON) {
+		normalization |= NormalizedJSON
+	}
+	return canonical, normalization, nil
+}
@@ -0,0 +1,103 @@
+package identity_test
+
+import (
+	"encoding/base64"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/TankerHQ/identity-go/v3"
+)
+
+// createURLUnsafeIdentity returns a padded identity whose base64 form
+// differs in the URL-safe alphabet. Since the JSON of identities is ASCII,
+// this only happens with values holding characters such as '?' or '~'.
+func createURLUnsafeIdentity() *string {
+	for n := 0; n < 3; n++ {
+		email := "???~~~" + strings.Repeat("a", n) + "@example.com"
+		id, err := identity.CreateProvisional(validConf, "email", email)
+		if err != nil {
+			panic("error creating provisional identity")
+		}
+		if strings.ContainsAny(*id, "+/") && strings.HasSuffix(*id, "=") {
+			return id
+		}
+	}
+	panic("no URL-unsafe padded identity")
+}
+
+func TestNormalizeIdentity(t *testing.T) {
+	id := createURLUnsafeIdentity()
+	raw, _ := base64.StdEncoding.DecodeString(*id)
+	urlSafe := base64.RawURLEncoding.EncodeToString(raw)
+	// the field order of other SDKs
+	reordered := base64.StdEncoding.EncodeToString([]byte(reverseFields(raw)))
+
+	vectors := []struct {
+		desc          string
+		input         string
+		normalization identity.Normalization
+	}{
+		{desc: "Canonical", input: *id, normalization: 0},
+		{desc: "Whitespace", input: " " + (*id)[:20] + "\n" + (*id)[20:] + "\r\n", normalization: identity.NormalizedWhitespace},
+		{desc: "URLSafe", input: base64.URLEncoding.EncodeToString(raw), normalization: identity.NormalizedURLEncoding},
+		{desc: "NoPadding", input: base64.RawStdEncoding.EncodeToString(raw), normalization: identity.NormalizedPadding},
+		{desc: "URLSafeNoPadding", input: urlSafe, normalization: identity.NormalizedURLEncoding | identity.NormalizedPadding},
+		{desc: "RawJSON", input: "\t" + string(raw) + "\n", normalization: identity.NormalizedRawJSON},
+		{desc: "FieldOrder", input: reordered, normalization: identity.NormalizedJSON},
+	}
+
+	for _, vec := range vectors {
+		t.Run(vec.desc, func(t *testing.T) {
+			normalized, normalization, err := identity.NormalizeIdentity(vec.input)
+			if err != nil {
+				t.Fatal("error normalizing identity:", err)
+			}
+			if *normalized != *id {
+				t.Fatal("normalized identity is not in canonical form")
+			}
+			if normalization != vec.normalization {
+				t.Fatalf("expected normalization %v, got %v", vec.normalization, normalization)
+			}
+		})
+	}
+}
+
+// reverseFields returns the JSON object of buf with its fields reversed
+func reverseFields(buf []byte) string {
+	fields := strings.Split(strings.Trim(string(buf), "{}"), ",")
+	for i, j := 0, len(fields)-1; i < j; i, j = i+1, j-1 {
+		fields[i], fields[j] = fields[j], fields[i]
+	}
+	return "{" + strings.Join(fields, ",") + "}"
+}
+
+func TestNormalizeIdentity_Error(t *testing.T) {
+	id := createURLUnsafeIdentity()
+	raw, _ := base64.StdEncoding.DecodeString(*id)
+	mixed := base64.URLEncoding.EncodeToString(raw) + "+/-_"
+
+	for _, input := range []string{
+		"",
+		notBase64Identity,
+		mixed,
+		strings.Repeat(" ", 2*identity.MaxIdentityLength+1),
+		`{"target": "user"}`,
+		modifyIdentity(*id, func(decoded map[string]interface{}) { decoded["unknown"] = "value" }),
+		replaceJSON(*id, `"target":`, `"TARGET":`),
+		replaceJSON(*id, `{"trustchain_id":`, `{"target":"user","trustchain_id":`),
+	} {
+		if _, _, err := identity.NormalizeIdentity(input); !errors.Is(err, identity.ErrMalformedIdentity) {
+			t.Fatalf("expected ErrMalformedIdentity normalizing %.20q, got %v", input, err)
+		}
+	}
+}
+
+func TestNormalization_String(t *testing.T) {
+	if s := identity.Normalization(0).String(); s != "none" {
+		t.Fatal("wrong string for no normalization:", s)
+	}
+	if s := (identity.NormalizedWhitespace | identity.NormalizedPadding).String(); s != "whitespace, padding" {
+		t.Fatal("wrong string for normalizations:", s)
+	}
+}
@@ -0,0 +1,388 @@
+package identity
+
+import (
+	"crypto/ed25519"
+	"encoding/base64"
+	"fmt"
+	"slices"
+
+	"github.com/TankerHQ/identity-go/v3/internal/app"
+	"github.com/TankerHQ/identity-go/v3/internal/crypto"
+	"golang.org/x/crypto/blake2b"
+)
+
+// Kind tells which kind of identity a parsed identity is
+type Kind int
+
+const (
+	// KindSecretPermanent is the kind of identities returned by Create
+	KindSecretPermanent Kind = iota + 1
+	// KindSecretProvisional is the kind of identities returned by
+	// CreateProvisional
+	KindSecretProvisional
+	// KindPublicPermanent is the kind of public identities of registered
+	// users
+	KindPublicPermanent
+	// KindPublicProvisional is the kind of public identities of users who
+	// are not registered yet
+	KindPublicProvisional
+)
+
+func (k Kind) String() string {
+	switch k {
+	case KindSecretPermanent:
+		return "secret permanent"
+	case KindSecretProvisional:
+		return "secret provisional"
+	case KindPublicPermanent:
+		return "public permanent"
+	case KindPublicProvisional:
+		return "public provisional"
+	default:
+		return "unknown"
+	}
+}
+
+// IsSecret returns whether identities of kind k hold private keys
+func (k Kind) IsSecret() bool {
+	return k == KindSecretPermanent || k == KindSecretProvisional
+}
+
+// IsPublic returns whether identities of kind k are public identities,
+// safe to share with other users
+func (k Kind) IsPublic() bool {
+	return k == KindPublicPermanent || k == KindPublicProvisional
+}
+
+// ProvisionalKind tells apart public provisional identities by their
+// target
+type ProvisionalKind int
+
+const (
+	// ProvisionalHashedEmail is the kind of public identities of email
+	// addresses
+	ProvisionalHashedEmail ProvisionalKind = iota + 1
+	// ProvisionalHashedPhoneNumber is the kind of public identities of
+	// phone numbers
+	ProvisionalHashedPhoneNumber
+	// ProvisionalLegacyEmail is the kind of public identities of email
+	// addresses created by former versions, which hold the email unhashed.
+	// UpgradeIdentity turns them into ProvisionalHashedEmail identities.
+	ProvisionalLegacyEmail
+	// ProvisionalPhoneNumber is the kind of public identities holding
+	// their phone number unhashed, which GetPublicIdentity never returns
+	ProvisionalPhoneNumber
+)
+
+func (k ProvisionalKind) String() string {
+	switch k {
+	case ProvisionalHashedEmail:
+		return "hashed email"
+	case ProvisionalHashedPhoneNumber:
+		return "hashed phone number"
+	case ProvisionalLegacyEmail:
+		return "legacy email"
+	case ProvisionalPhoneNumber:
+		return "phone number"
+	default:
+		return "unknown"
+	}
+}
+
+// NeedsUpgrade returns whether UpgradeIdentity changes public provisional
+// identities of kind k
+func (k ProvisionalKind) NeedsUpgrade() bool {
+	return k == ProvisionalLegacyEmail
+}
+
+// provisionalKindOf returns the kind of public provisional identities of
+// target, or 0 for targets of other identities
+func provisionalKindOf(target string) ProvisionalKind {
+	switch target {
+	case "hashed_email":
+		return ProvisionalHashedEmail
+	case "hashed_phone_number":
+		return ProvisionalHashedPhoneNumber
+	case "email":
+		return ProvisionalLegacyEmail
+	case "phone_number":
+		return ProvisionalPhoneNumber
+	default:
+		return 0
+	}
+}
+
+// Identity is implemented by all the identity types returned by
+// ParseIdentity and ParsePublicIdentity
+//
+// The accessors of the fields common to all identities are prefixed with
+// Get since the identity types have fields of the same name.
+type Identity interface {
+	// Kind returns the kind of the identity
+	Kind() Kind
+	// GetTrustchainID returns the trustchain_id of the identity, that is
+	// the decoded ID of its app
+	GetTrustchainID() []byte
+	// GetTarget returns the target of the identity
+	GetTarget() string
+	// GetValue returns the value of the identity, which is hashed for
+	// permanent identities and hashed provisional identities
+	GetValue() string
+}
+
+// GetTrustchainID implements Identity
+func (i publicIdentity) GetTrustchainID() []byte { return i.TrustchainID }
+
+// GetTarget implements Identity
+func (i publicIdentity) GetTarget() string { return i.Target }
+
+// GetValue implements Identity
+func (i publicIdentity) GetValue() string { return i.Value }
+
+// Kind implements Identity
+func (PublicPermanentIdentity) Kind() Kind { return KindPublicPermanent }
+
+// Kind implements Identity
+func (SecretPermanentIdentity) Kind() Kind { return KindSecretPermanent }
+
+// Kind implements Identity
+func (PublicProvisionalIdentity) Kind() Kind { return KindPublicProvisional }
+
+// Kind implements Identity
+func (SecretProvisionalIdentity) Kind() Kind { return KindSecretProvisional }
+
+// ProvisionalKind returns the kind of the public provisional identity,
+// which tells apart the identities Kind returns KindPublicProvisional for
+func (i PublicProvisionalIdentity) ProvisionalKind() ProvisionalKind {
+	return provisionalKindOf(i.Target)
+}
+
+// ParseIdentity decodes b64Identity, which can be any kind of identity,
+// and returns it as one of *SecretPermanentIdentity,
+// *SecretProvisionalIdentity, *PublicPermanentIdentity or
+// *PublicProvisionalIdentity.
+//
+// The identity is checked strictly: it must not be longer than
+// MaxIdentityLength, must hold all the fields required by its kind with
+// their expected sizes, and no other field.
+func ParseIdentity(b64Identity string) (Identity, error) {
+	raw, kind, err := decodeStrict(b64Identity, false)
+	if err != nil {
+		return nil, err
+	}
+	return raw.identity(kind), nil
+}
+
+// strictIdentity holds every field an identity can have, so that an
+// identity is decoded once and then checked against the fields of its
+// kind
+type strictIdentity struct {
+	TrustchainID                 []byte  `json:"trustchain_id"`
+	Target                       *string `json:"target"`
+	Value                        *string `json:"value"`
+	DelegationSignature          []byte  `json:"delegation_signature"`
+	EphemeralPublicSignatureKey  []byte  `json:"ephemeral_public_signature_key"`
+	EphemeralPrivateSignatureKey []byte  `json:"ephemeral_private_signature_key"`
+	UserSecret                   []byte  `json:"user_secret"`
+	PublicEncryptionKey          []byte  `json:"public_encryption_key"`
+	PrivateEncryptionKey         []byte  `json:"private_encryption_key"`
+	PublicSignatureKey           []byte  `json:"public_signature_key"`
+	PrivateSignatureKey          []byte  `json:"private_signature_key"`
+}
+
+type keyField struct {
+	name     string
+	size     int
+	required bool
+}
+
+// kindFields lists the key fields of each kind of identity, besides
+// trustchain_id, target and value which all identities have.
+var kindFields = map[Kind][]keyField{
+	KindSecretPermanent: {
+		{name: "delegation_signature", size: ed25519.SignatureSize, required: true},
+		{name: "ephemeral_public_signature_key", size: ed25519.PublicKeySize, required: true},
+		{name: "ephemeral_private_signature_key", size: ed25519.PrivateKeySize, required: true},
+		{name: "user_secret", size: userSecretSize, required: true},
+	},
+	KindPublicPermanent: nil,
+	KindSecretProvisional: {
+		{name: "public_encryption_key", size: crypto.KeySize, required: true},
+		{name: "private_encryption_key", size: crypto.KeySize, required: true},
+		{name: "public_signature_key", size: ed25519.PublicKeySize, required: true},
+		{name: "private_signature_key", size: ed25519.PrivateKeySize, required: true},
+	},
+	KindPublicProvisional: {
+		{name: "public_encryption_key", size: crypto.KeySize, required: true},
+		{name: "public_signature_key", size: ed25519.PublicKeySize, required: true},
+	},
+}
+
+// decodeStrict decodes b64Identity and checks it as described by
+// ParseIdentity. With allowUnknown, fields unknown to this package are
+// ignored instead of rejected.
+func decodeStrict(b64Identity string, allowUnknown bool) (*strictIdentity, Kind, error) {
+	buf, err := decodeBase64Identity(b64Identity)
+	if err != nil {
+		return nil, 0, err
+	}
+	raw := new(strictIdentity)
+	if err := unmarshalIdentity(buf, raw, allowUnknown); err != nil {
+		return nil, 0, err
+	}
+	kind, err := raw.kind()
+	if err != nil {
+		return nil, 0, err
+	}
+	if err := raw.check(kind); err != nil {
+		return nil, 0, err
+	}
+	return raw, kind, nil
+}
+
+func (raw *strictIdentity) kind() (Kind, error) {
+	if raw.Target == nil {
+		return 0, fieldError(ErrMalformedIdentity, "target", "is missing")
+	}
+	switch *raw.Target {
+	case "user":
+		if raw.UserSecret != nil {
+			return KindSecretPermanent, nil
+		}
+		return KindPublicPermanent, nil
+	case "email", "phone_number":
+		if raw.PrivateEncryptionKey != nil {
+			return KindSecretProvisional, nil
+		}
+		return KindPublicProvisional, nil
+	case "hashed_email", "hashed_phone_number":
+		return KindPublicProvisional, nil
+	default:
+		return 0, ErrUnsupportedTarget
+	}
+}
+
+func (raw *strictIdentity) check(kind Kind) error {
+	if raw.TrustchainID == nil {
+		return fieldError(ErrMalformedIdentity, "trustchain_id", "is missing")
+	}
+	if err := checkSize(ErrMalformedIdentity, "trustchain_id", raw.TrustchainID, app.AppPublicKeySize); err != nil {
+		return err
+	}
+
+	if raw.Value == nil || *raw.Value == "" {
+		return fieldError(ErrMalformedIdentity, "value", "is missing")
+	}
+	switch *raw.Target {
+	case "user", "hashed_email", "hashed_phone_number":
+		// the value is a hash
+		hash, err := base64.StdEncoding.DecodeString(*raw.Value)
+		if err != nil {
+			return &FieldError{Err: ErrMalformedIdentity, Field: "value", Reason: "is not valid base64", Cause: err}
+		}
+		if err := checkSize(ErrMalformedIdentity, "value", hash, blake2b.Size256); err != nil {
+			return err
+		}
+	}
+
+	fields := kindFields[kind]
+	for _, name := range keyFieldNames {
+		value := raw.keyField(name)
+		i := slices.IndexFunc(fields, func(field keyField) bool { return field.name == name })
+		if i < 0 {
+			if value != nil {
+				return fieldError(ErrMalformedIdentity, name, fmt.Sprintf("is not allowed in a %v identity", kind))
+			}
+			continue
+		}
+		if value == nil {
+			if fields[i].required {
+				return fieldError(ErrMalformedIdentity, name, "is missing")
+			}
+			continue
+		}
+		if err := checkSize(ErrMalformedIdentity, name, value, fields[i].size); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// keyFieldNames lists the key fields of strictIdentity in keyIndexes
+// order, so they are checked in the order they are written
+var keyFieldNames = []string{
+	"delegation_signature",
+	"ephemeral_public_signature_key",
+	"ephemeral_private_signature_key",
+	"user_secret",
+	"public_encryption_key",
+	"private_encryption_key",
+	"public_signature_key",
+	"private_signature_key",
+}
+
+func (raw *strictIdentity) keyField(name string) []byte {
+	switch name {
+	case "delegation_signature":
+		return raw.DelegationSignature
+	case "ephemeral_public_signature_key":
+		return raw.EphemeralPublicSignatureKey
+	case "ephemeral_private_signature_key":
+		return raw.EphemeralPrivateSignatureKey
+	case "user_secret":
+		return raw.UserSecret
+	case "public_encryption_key":
+		return raw.PublicEncryptionKey
+	case "private_encryption_key":
+		return raw.PrivateEncryptionKey
+	case "public_signature_key":
+		return raw.PublicSignatureKey
+	case "private_signature_key":
+		return raw.PrivateSignatureKey
+	default:
+		return nil
+	}
+}
+
+func (raw *strictIdentity) identity(kind Kind) Identity {
+	public := publicIdentity{TrustchainID: raw.TrustchainID, Target: *raw.Target, Value: *raw.Value}
+	publicProvisional := PublicProvisionalIdentity{
+		publicIdentity:      public,
+		PublicEncryptionKey: raw.PublicEncryptionKey,
+		PublicSignatureKey:  raw.PublicSignatureKey,
+	}
+	switch kind {
+	case KindSecretPermanent:
+		return &SecretPermanentIdentity{
+			PublicPermanentIdentity:      PublicPermanentIdentity{publicIdentity: public},
+			DelegationSignature:          raw.DelegationSignature,
+			EphemeralPublicSignatureKey:  raw.EphemeralPublicSignatureKey,
+			EphemeralPrivateSignatureKey: raw.EphemeralPrivateSignatureKey,
+			UserSecret:                   raw.UserSecret,
+		}
+	case KindPublicPermanent:
+		return &PublicPermanentIdentity{publicIdentity: public}
+	case KindSecretProvisional:
+		return &SecretProvisionalIdentity{
+			PublicProvisionalIdentity: publicProvisional,
+			PrivateEncryptionKey:      raw.PrivateEncryptionKey,
+			PrivateSignatureKey:       raw.PrivateSignatureKey,
+		}
+	default:
+		return &publicProvisional
+	}
+}
+
+// ParsePublicIdentity is like ParseIdentity but only accepts public
+// identities, that is *PublicPermanentIdentity or
+// *PublicProvisionalIdentity
+func ParsePublicIdentity(b64PublicIdentity string) (Identity, error) {
+	parsed, err := ParseIdentity(b64PublicIdentity)
+	if err != nil {
+		return nil, err
+	}
+	if parsed.Kind().IsSecret() {
+		return nil, fmt.Errorf("%w: expected a public identity, got a %v identity", ErrWrongKind, parsed.Kind())
+	}
+	return parsed, nil
+}
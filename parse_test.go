@@ -0,0 +1,337 @@
+package identity_test
+
+import (
+	"encoding/base64"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/TankerHQ/identity-go/v3"
+)
+
+func TestParseIdentity(t *testing.T) {
+	id, err := identity.Create(validConf, "userID")
+	if err != nil {
+		panic("error creating identity")
+	}
+	pub, err := identity.GetPublicIdentity(*id)
+	if err != nil {
+		panic("error getting public identity")
+	}
+
+	t.Run("SecretPermanent", func(t *testing.T) {
+		parsed, err := identity.ParseIdentity(*id)
+		if err != nil {
+			t.Fatal("error parsing identity")
+		}
+		secret, ok := parsed.(*identity.SecretPermanentIdentity)
+		if !ok || parsed.Kind() != identity.KindSecretPermanent {
+			t.Fatal("wrong identity kind")
+		}
+		if base64.StdEncoding.EncodeToString(secret.TrustchainID) != validAppId {
+			t.Fatal("wrong trustchain ID")
+		}
+		if secret.Target != "user" || len(secret.UserSecret) == 0 {
+			t.Fatal("wrong identity content")
+		}
+	})
+
+	t.Run("PublicPermanent", func(t *testing.T) {
+		parsed, err := identity.ParseIdentity(*pub)
+		if err != nil {
+			t.Fatal("error parsing identity")
+		}
+		if _, ok := parsed.(*identity.PublicPermanentIdentity); !ok || parsed.Kind() != identity.KindPublicPermanent {
+			t.Fatal("wrong identity kind")
+		}
+	})
+
+	for _, target := range validTargets {
+		prov, err := identity.CreateProvisional(validConf, target, "userID")
+		if err != nil {
+			panic("error creating provisional identity")
+		}
+		pubProv, err := identity.GetPublicIdentity(*prov)
+		if err != nil {
+			panic("error getting public identity")
+		}
+
+		t.Run("SecretProvisional/"+target, func(t *testing.T) {
+			parsed, err := identity.ParseIdentity(*prov)
+			if err != nil {
+				t.Fatal("error parsing identity")
+			}
+			secret, ok := parsed.(*identity.SecretProvisionalIdentity)
+			if !ok || parsed.Kind() != identity.KindSecretProvisional {
+				t.Fatal("wrong identity kind")
+			}
+			if secret.Target != target || secret.Value != "userID" {
+				t.Fatal("wrong identity content")
+			}
+		})
+
+		t.Run("PublicProvisional/"+target, func(t *testing.T) {
+			parsed, err := identity.ParseIdentity(*pubProv)
+			if err != nil {
+				t.Fatal("error parsing identity")
+			}
+			public, ok := parsed.(*identity.PublicProvisionalIdentity)
+			if !ok || parsed.Kind() != identity.KindPublicProvisional {
+				t.Fatal("wrong identity kind")
+			}
+			if public.Target != "hashed_"+target || len(public.PublicSignatureKey) == 0 {
+				t.Fatal("wrong identity content")
+			}
+		})
+	}
+}
+
+func TestParseIdentity_Accessors(t *testing.T) {
+	id, err := identity.Create(validConf, "userID")
+	if err != nil {
+		panic("error creating identity")
+	}
+	prov, err := identity.CreateProvisional(validConf, "email", "alice@example.com")
+	if err != nil {
+		panic("error creating provisional identity")
+	}
+	pub, _ := identity.GetPublicIdentity(*id)
+	pubProv, _ := identity.GetPublicIdentity(*prov)
+
+	vectors := []struct {
+		identity string
+		target   string
+	}{
+		{identity: *id, target: "user"},
+		{identity: *pub, target: "user"},
+		{identity: *prov, target: "email"},
+		{identity: *pubProv, target: "hashed_email"},
+	}
+	for _, vec := range vectors {
+		parsed, err := identity.ParseIdentity(vec.identity)
+		if err != nil {
+			t.Fatal("error parsing identity:", err)
+		}
+		if base64.StdEncoding.EncodeToString(parsed.GetTrustchainID()) != validAppId {
+			t.Fatal("wrong trustchain ID")
+		}
+		if parsed.GetTarget() != vec.target || parsed.GetValue() == "" {
+			t.Fatal("wrong target or value")
+		}
+	}
+	parsed, _ := identity.ParseIdentity(*prov)
+	if parsed.GetValue() != "alice@example.com" {
+		t.Fatal("wrong provisional value")
+	}
+}
+
+func TestParseIdentity_RoundTrip(t *testing.T) {
+	id, err := identity.Create(validConf, "userID")
+	if err != nil {
+		panic("error creating identity")
+	}
+
+	parsed, err := identity.ParseIdentity(*id)
+	if err != nil {
+		t.Fatal("error parsing identity")
+	}
+	encoded, err := identity.Encode(parsed)
+	if err != nil {
+		t.Fatal("error encoding identity")
+	}
+	if *encoded != *id {
+		t.Fatal("identity changed after parse and encode")
+	}
+}
+
+func TestParseIdentity_Error(t *testing.T) {
+	t.Run("InvalidBase64", func(t *testing.T) {
+		_, err := identity.ParseIdentity(notBase64Identity)
+		if err == nil {
+			t.Fatal("no error parsing identity")
+		}
+	})
+
+	t.Run("NoTarget", func(t *testing.T) {
+		noTarget, _ := identity.Encode(map[string]string{})
+		_, err := identity.ParseIdentity(*noTarget)
+		if err == nil {
+			t.Fatal("no error parsing identity")
+		}
+	})
+
+	t.Run("BadTarget", func(t *testing.T) {
+		badTarget, _ := identity.Encode(map[string]string{"target": invalidTarget})
+		_, err := identity.ParseIdentity(*badTarget)
+		if err == nil {
+			t.Fatal("no error parsing identity")
+		}
+	})
+}
+
+func TestParsePublicIdentity(t *testing.T) {
+	id, err := identity.Create(validConf, "userID")
+	if err != nil {
+		panic("error creating identity")
+	}
+	pub, err := identity.GetPublicIdentity(*id)
+	if err != nil {
+		panic("error getting public identity")
+	}
+
+	parsed, err := identity.ParsePublicIdentity(*pub)
+	if err != nil {
+		t.Fatal("error parsing public identity")
+	}
+	public := parsed.(*identity.PublicPermanentIdentity)
+
+	secret, _ := identity.ParseIdentity(*id)
+	if public.Value != secret.(*identity.SecretPermanentIdentity).Value {
+		t.Fatal("public and secret identity values differ")
+	}
+
+	_, err = identity.ParsePublicIdentity(*id)
+	if err == nil {
+		t.Fatal("no error parsing secret identity as public")
+	}
+}
+
+// modifyIdentity returns b64Identity with its decoded fields changed by
+// modify
+func modifyIdentity(b64Identity string, modify func(map[string]interface{})) string {
+	decoded := map[string]interface{}{}
+	if err := identity.Decode(b64Identity, &decoded); err != nil {
+		panic("error decoding identity")
+	}
+	modify(decoded)
+	encoded, err := identity.Encode(decoded)
+	if err != nil {
+		panic("error encoding identity")
+	}
+	return *encoded
+}
+
+// replaceJSON replaces old with new in the JSON of b64Identity, for
+// changes modifyIdentity cannot make
+func replaceJSON(b64Identity string, old string, new string) string {
+	buf, err := base64.StdEncoding.DecodeString(b64Identity)
+	if err != nil || !strings.Contains(string(buf), old) {
+		panic("error replacing identity JSON")
+	}
+	return base64.StdEncoding.EncodeToString([]byte(strings.Replace(string(buf), old, new, 1)))
+}
+
+func TestParseIdentity_Strict(t *testing.T) {
+	id, err := identity.Create(validConf, "userID")
+	if err != nil {
+		panic("error creating identity")
+	}
+	prov, err := identity.CreateProvisional(validConf, "email", "userID")
+	if err != nil {
+		panic("error creating provisional identity")
+	}
+	set := func(b64Identity string, field string, value interface{}) string {
+		return modifyIdentity(b64Identity, func(decoded map[string]interface{}) { decoded[field] = value })
+	}
+	remove := func(b64Identity string, fields ...string) string {
+		return modifyIdentity(b64Identity, func(decoded map[string]interface{}) {
+			for _, field := range fields {
+				delete(decoded, field)
+			}
+		})
+	}
+	phone, err := identity.CreateProvisional(validConf, "phone_number", "+33611223344")
+	if err != nil {
+		panic("error creating provisional identity")
+	}
+	publicEmail, err := identity.GetPublicIdentity(*prov)
+	if err != nil {
+		panic("error getting public identity")
+	}
+	publicPhone, err := identity.GetPublicIdentity(*phone)
+	if err != nil {
+		panic("error getting public identity")
+	}
+
+	vectors := []struct {
+		desc     string
+		identity string
+		field    string
+	}{
+		{desc: "UnknownField", identity: set(*id, "unknown", "value")},
+		{desc: "UppercaseField", identity: replaceJSON(*id, `"target":`, `"TARGET":`)},
+		{desc: "FoldedField", identity: replaceJSON(*id, `"user_secret":`, `"uſer_secret":`)},
+		{desc: "DuplicateField", identity: replaceJSON(*id, `"target":"user"`, `"target":"user","target":"user"`)},
+		{desc: "TrailingData", identity: base64.StdEncoding.EncodeToString([]byte(`{"target":"user"} {}`))},
+		{desc: "TooLong", identity: set(*id, "value", strings.Repeat("a", identity.MaxIdentityLength))},
+		{desc: "MissingTrustchainID", identity: remove(*id, "trustchain_id"), field: "trustchain_id"},
+		{desc: "ShortTrustchainID", identity: set(*id, "trustchain_id", base64id(31)), field: "trustchain_id"},
+		{desc: "MissingValue", identity: remove(*prov, "value"), field: "value"},
+		{desc: "EmptyValue", identity: set(*prov, "value", ""), field: "value"},
+		{desc: "UserValueNotAHash", identity: set(*id, "value", "userID"), field: "value"},
+		{desc: "UserValueWrongSize", identity: set(*id, "value", base64id(16)), field: "value"},
+		{desc: "MissingDelegationSignature", identity: remove(*id, "delegation_signature"), field: "delegation_signature"},
+		{desc: "LongDelegationSignature", identity: set(*id, "delegation_signature", base64id(65)), field: "delegation_signature"},
+		{desc: "ShortUserSecret", identity: set(*id, "user_secret", base64id(31)), field: "user_secret"},
+		{desc: "MissingPublicSignatureKey", identity: remove(*prov, "public_signature_key"), field: "public_signature_key"},
+		{desc: "ShortPrivateSignatureKey", identity: set(*prov, "private_signature_key", base64id(32)), field: "private_signature_key"},
+		{desc: "ProvisionalWithUserSecret", identity: set(*prov, "user_secret", base64id(32)), field: "user_secret"},
+		{desc: "PermanentWithEncryptionKey", identity: set(*id, "public_encryption_key", base64id(32)), field: "public_encryption_key"},
+		{desc: "KeylessHashedEmail", identity: remove(*publicEmail, "public_encryption_key", "public_signature_key"), field: "public_encryption_key"},
+		{desc: "HashedEmailWithOneKey", identity: remove(*publicEmail, "public_signature_key"), field: "public_signature_key"},
+		{desc: "KeylessHashedPhoneNumber", identity: remove(*publicPhone, "public_encryption_key", "public_signature_key"), field: "public_encryption_key"},
+		{desc: "KeylessLegacyEmail", identity: remove(withoutPrivateKeys(*prov), "public_encryption_key", "public_signature_key"), field: "public_encryption_key"},
+	}
+
+	for _, vec := range vectors {
+		t.Run(vec.desc, func(t *testing.T) {
+			_, err := identity.ParseIdentity(vec.identity)
+			if !errors.Is(err, identity.ErrMalformedIdentity) {
+				t.Fatal("expected ErrMalformedIdentity, got", err)
+			}
+			if _, err := identity.GetPublicIdentity(vec.identity); !errors.Is(err, identity.ErrMalformedIdentity) {
+				t.Fatal("expected ErrMalformedIdentity from GetPublicIdentity, got", err)
+			}
+			if vec.field == "" {
+				return
+			}
+			var fieldErr *identity.FieldError
+			if !errors.As(err, &fieldErr) || fieldErr.Field != vec.field {
+				t.Fatalf("expected an error on %s, got %v", vec.field, err)
+			}
+		})
+	}
+}
+
+func TestUpgradeIdentity_UnknownFields(t *testing.T) {
+	prov, err := identity.CreateProvisional(validConf, "email", "userID")
+	if err != nil {
+		panic("error creating provisional identity")
+	}
+	withUnknown := modifyIdentity(withoutPrivateKeys(*prov), func(decoded map[string]interface{}) {
+		decoded["unknown"] = "value"
+	})
+
+	upgraded, err := identity.UpgradeIdentity(withUnknown)
+	if err != nil {
+		t.Fatal("error upgrading identity with unknown field:", err)
+	}
+	decoded := map[string]interface{}{}
+	if err := identity.Decode(*upgraded, &decoded); err != nil || decoded["unknown"] != "value" {
+		t.Fatal("unknown field not kept by UpgradeIdentity")
+	}
+
+	if _, err := identity.UpgradeIdentity(replaceJSON(withUnknown, `"target":`, `"TARGET":`)); !errors.Is(err, identity.ErrMalformedIdentity) {
+		t.Fatal("expected ErrMalformedIdentity upgrading identity with an uppercase field, got", err)
+	}
+	if _, err := identity.UpgradeIdentity(replaceJSON(withUnknown, `"unknown":"value"`, `"unknown":"value","unknown":"value"`)); !errors.Is(err, identity.ErrMalformedIdentity) {
+		t.Fatal("expected ErrMalformedIdentity upgrading identity with a duplicate field, got", err)
+	}
+
+	tampered := modifyIdentity(withUnknown, func(decoded map[string]interface{}) {
+		decoded["public_signature_key"] = base64id(31)
+	})
+	if _, err := identity.UpgradeIdentity(tampered); !errors.Is(err, identity.ErrMalformedIdentity) {
+		t.Fatal("expected ErrMalformedIdentity, got", err)
+	}
+}
@@ -0,0 +1,64 @@
+package identity
+
+import (
+	"encoding/base64"
+)
+
+// PublicIdentityFromUserID returns the public identity of userID in the
+// app identified by appID. The result is the same as calling
+// GetPublicIdentity on the identity returned by Create for this user,
+// but only the app ID is needed.
+func PublicIdentityFromUserID(appID string, userID string) (*string, error) {
+	trustchainID, err := decodeAppID(appID)
+	if err != nil {
+		return nil, err
+	}
+	return Encode(newPublicPermanentIdentity(trustchainID, userID))
+}
+
+// PublicIdentitiesFromUserIDs is like PublicIdentityFromUserID for
+// several users at once. The returned public identities are in the same
+// order as userIDs.
+func PublicIdentitiesFromUserIDs(appID string, userIDs []string) ([]string, error) {
+	trustchainID, err := decodeAppID(appID)
+	if err != nil {
+		return nil, err
+	}
+
+	publicIdentities := make([]string, 0, len(userIDs))
+	for _, userID := range userIDs {
+		publicIdentity, err := Encode(newPublicPermanentIdentity(trustchainID, userID))
+		if err != nil {
+			return nil, err
+		}
+		publicIdentities = append(publicIdentities, *publicIdentity)
+	}
+	return publicIdentities, nil
+}
+
+func newPublicPermanentIdentity(trustchainID []byte, userID string) *PublicPermanentIdentity {
+	return &PublicPermanentIdentity{
+		publicIdentity: publicIdentity{
+			TrustchainID: trustchainID,
+			Target:       "user",
+			Value:        base64.StdEncoding.EncodeToString(hashUserID(trustchainID, userID)),
+		},
+	}
+}
+
+// HashedEmail returns the value of the public provisional identities of
+// email, that is the value of the public identity returned by
+// GetPublicIdentity for an identity created with
+// CreateProvisional(config, "email", email), in any app.
+//
+// It is a lookup value, not an identity: it can be compared with the
+// GetValue of public provisional identities with the "hashed_email"
+// target, or used to find them. Sharing with the email address requires
+// its public identity, which holds keys only known to whoever holds the
+// secret provisional identity.
+//
+// The email is hashed as is, so callers must pass it exactly as it was
+// given to CreateProvisional.
+func HashedEmail(email string) string {
+	return hashProvisionalIdentityEmail(email)
+}
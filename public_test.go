@@ -0,0 +1,92 @@
+package identity_test
+
+import (
+	"testing"
+
+	"github.com/TankerHQ/identity-go/v3"
+)
+
+func TestPublicIdentityFromUserID(t *testing.T) {
+	id, err := identity.Create(validConf, "userID")
+	if err != nil {
+		panic("error creating identity")
+	}
+	expected, err := identity.GetPublicIdentity(*id)
+	if err != nil {
+		panic("error getting public identity")
+	}
+
+	pub, err := identity.PublicIdentityFromUserID(validAppId, "userID")
+	if err != nil {
+		t.Fatal("error computing public identity")
+	}
+	if *pub != *expected {
+		t.Fatal("public identity differs from GetPublicIdentity")
+	}
+}
+
+func TestPublicIdentityFromUserID_Error(t *testing.T) {
+	t.Run("NotBase64AppId", func(t *testing.T) {
+		_, err := identity.PublicIdentityFromUserID("app ID", "userID")
+		if err == nil {
+			t.Fatal("no error computing public identity")
+		}
+	})
+
+	t.Run("WrongSizeAppId", func(t *testing.T) {
+		_, err := identity.PublicIdentityFromUserID(wrongSizeAppId, "userID")
+		if err == nil {
+			t.Fatal("no error computing public identity")
+		}
+	})
+}
+
+func TestPublicIdentitiesFromUserIDs(t *testing.T) {
+	userIDs := []string{"alice", "bob", "charlie"}
+
+	pubs, err := identity.PublicIdentitiesFromUserIDs(validAppId, userIDs)
+	if err != nil {
+		t.Fatal("error computing public identities")
+	}
+	if len(pubs) != len(userIDs) {
+		t.Fatal("wrong number of public identities")
+	}
+
+	for i, userID := range userIDs {
+		expected, err := identity.PublicIdentityFromUserID(validAppId, userID)
+		if err != nil {
+			panic("error computing public identity")
+		}
+		if pubs[i] != *expected {
+			t.Fatalf("public identity of %s differs", userID)
+		}
+	}
+
+	_, err = identity.PublicIdentitiesFromUserIDs(wrongSizeAppId, userIDs)
+	if err == nil {
+		t.Fatal("no error computing public identities")
+	}
+}
+
+func TestHashedEmail(t *testing.T) {
+	email := "alice@example.com"
+	prov, err := identity.CreateProvisional(validConf, "email", email)
+	if err != nil {
+		panic("error creating provisional identity")
+	}
+	pub, err := identity.GetPublicIdentity(*prov)
+	if err != nil {
+		panic("error getting public identity")
+	}
+	parsed, err := identity.ParsePublicIdentity(*pub)
+	if err != nil {
+		panic("error parsing public identity")
+	}
+
+	if identity.HashedEmail(email) != parsed.GetValue() {
+		t.Fatal("hashed email does not match the public identity value")
+	}
+	if identity.HashedEmail("bob@example.com") == parsed.GetValue() {
+		t.Fatal("hashed emails of different addresses match")
+	}
+}
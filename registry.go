@@ -0,0 +1,251 @@
+package identity
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// Registry holds the validated configs of several apps, keyed by app ID,
+// for backends serving more than one app. Identities are matched to their
+// app by their trustchain_id, and identities of apps which are not
+// registered are rejected with ErrUnknownApp.
+//
+// A Registry is safe for concurrent use by multiple goroutines.
+type Registry struct {
+	mu      sync.RWMutex
+	configs map[string]Config
+	issuers map[string]*Issuer
+}
+
+// NewRegistry returns a Registry holding configs, or an error if one of
+// them is invalid
+func NewRegistry(configs ...Config) (*Registry, error) {
+	r := &Registry{configs: map[string]Config{}, issuers: map[string]*Issuer{}}
+	for _, config := range configs {
+		if err := r.Register(config); err != nil {
+			return nil, err
+		}
+	}
+	return r, nil
+}
+
+// NewRegistryFromDir returns a Registry holding the configs of the JSON
+// files of dir, that is the files with a .json extension, each holding
+// the "app_id" and "app_secret" of an app
+func NewRegistryFromDir(dir string) (*Registry, error) {
+	paths, err := filepath.Glob(filepath.Join(dir, "*.json"))
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(paths)
+
+	r, _ := NewRegistry()
+	for _, path := range paths {
+		config, err := readConfigFile(path)
+		if err != nil {
+			return nil, err
+		}
+		if err := r.Register(config); err != nil {
+			return nil, fmt.Errorf("%s: %w", filepath.Base(path), err)
+		}
+	}
+	return r, nil
+}
+
+func readConfigFile(path string) (Config, error) {
+	buf, err := os.ReadFile(path)
+	if err != nil {
+		return Config{}, err
+	}
+	var fileConfig struct {
+		AppID     string `json:"app_id"`
+		AppSecret string `json:"app_secret"`
+	}
+	// the error is not wrapped since it may quote the app secret
+	if err := json.Unmarshal(buf, &fileConfig); err != nil {
+		return Config{}, fmt.Errorf("%w: %s is not a valid JSON config file", ErrInvalidConfig, filepath.Base(path))
+	}
+	return Config{AppID: fileConfig.AppID, AppSecret: fileConfig.AppSecret}, nil
+}
+
+// NewRegistryFromEnv returns a Registry holding the configs found in
+// environ, a list of "key=value" strings such as the one returned by
+// os.Environ. Each app is given by a pair of TANKER_APP_<NAME>_ID and
+// TANKER_APP_<NAME>_SECRET variables, where NAME is any name, and by
+// TANKER_APP_ID and TANKER_APP_SECRET for a single unnamed app.
+func NewRegistryFromEnv(environ []string) (*Registry, error) {
+	const prefix = "TANKER_APP_"
+	ids := map[string]string{}
+	secrets := map[string]string{}
+	for _, variable := range environ {
+		key, value, _ := strings.Cut(variable, "=")
+		if !strings.HasPrefix(key, prefix) {
+			continue
+		}
+		switch name := strings.TrimPrefix(key, prefix); {
+		case name == "ID":
+			ids[""] = value
+		case name == "SECRET":
+			secrets[""] = value
+		case strings.HasSuffix(name, "_ID"):
+			ids[strings.TrimSuffix(name, "_ID")] = value
+		case strings.HasSuffix(name, "_SECRET"):
+			secrets[strings.TrimSuffix(name, "_SECRET")] = value
+		}
+	}
+
+	names := make([]string, 0, len(ids))
+	for name := range ids {
+		names = append(names, name)
+	}
+	for name := range secrets {
+		if _, found := ids[name]; !found {
+			return nil, fmt.Errorf("%w: %s%s_SECRET has no matching app ID", ErrInvalidConfig, prefix, name)
+		}
+	}
+	sort.Strings(names)
+
+	r, _ := NewRegistry()
+	for _, name := range names {
+		secret, found := secrets[name]
+		if !found {
+			return nil, fmt.Errorf("%w: app %q has no app secret", ErrInvalidConfig, name)
+		}
+		if err := r.Register(Config{AppID: ids[name], AppSecret: secret}); err != nil {
+			return nil, err
+		}
+	}
+	return r, nil
+}
+
+// Register validates config and adds it to r. Registering the same config
+// twice is not an error, but registering another config for an app ID
+// already registered is. The app ID and secret are compared and kept in
+// their canonical base64 encoding, which Config returns.
+func (r *Registry) Register(config Config) error {
+	issuer, err := NewIssuer(config)
+	if err != nil {
+		return err
+	}
+	// base64 decoding accepts several encodings of the same bytes, for
+	// instance with line breaks, so apps are registered under the
+	// canonical encoding, which is the one of the identities
+	config = Config{
+		AppID:     base64.StdEncoding.EncodeToString(issuer.config.AppID),
+		AppSecret: base64.StdEncoding.EncodeToString(issuer.config.AppSecret),
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if registered, found := r.configs[config.AppID]; found && registered.AppSecret != config.AppSecret {
+		return fmt.Errorf("%w: app %s is already registered with another app secret", ErrInvalidConfig, config.AppID)
+	}
+	r.configs[config.AppID] = config
+	r.issuers[config.AppID] = issuer
+	return nil
+}
+
+// canonicalAppID returns the canonical encoding of appID, as registered
+// by Register, or appID itself if it is not valid
+func canonicalAppID(appID string) string {
+	trustchainID, err := decodeAppID(appID)
+	if err != nil {
+		return appID
+	}
+	return base64.StdEncoding.EncodeToString(trustchainID)
+}
+
+// AppIDs returns the IDs of the registered apps, sorted
+func (r *Registry) AppIDs() []string {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	appIDs := make([]string, 0, len(r.configs))
+	for appID := range r.configs {
+		appIDs = append(appIDs, appID)
+	}
+	sort.Strings(appIDs)
+	return appIDs
+}
+
+// Config returns the config of the app identified by appID, or
+// ErrUnknownApp
+func (r *Registry) Config(appID string) (Config, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	config, found := r.configs[canonicalAppID(appID)]
+	if !found {
+		return Config{}, ErrUnknownApp
+	}
+	return config, nil
+}
+
+// Issuer returns the Issuer of the app identified by appID, or
+// ErrUnknownApp
+func (r *Registry) Issuer(appID string) (*Issuer, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	issuer, found := r.issuers[canonicalAppID(appID)]
+	if !found {
+		return nil, ErrUnknownApp
+	}
+	return issuer, nil
+}
+
+// Resolve returns the config of the app b64Identity belongs to, which can
+// be any kind of identity checked as by ParseIdentity, or ErrUnknownApp if
+// the app is not registered
+func (r *Registry) Resolve(b64Identity string) (Config, error) {
+	return r.resolve(b64Identity, false)
+}
+
+func (r *Registry) resolve(b64Identity string, allowUnknown bool) (Config, error) {
+	raw, _, err := decodeStrict(b64Identity, allowUnknown)
+	if err != nil {
+		return Config{}, err
+	}
+	return r.Config(base64.StdEncoding.EncodeToString(raw.TrustchainID))
+}
+
+// GetPublicIdentity is like the package level GetPublicIdentity, but
+// rejects identities of apps which are not registered
+func (r *Registry) GetPublicIdentity(b64Identity string) (*string, error) {
+	if _, err := r.Resolve(b64Identity); err != nil {
+		return nil, err
+	}
+	return GetPublicIdentity(b64Identity)
+}
+
+// UpgradeIdentity is like the package level UpgradeIdentity, but rejects
+// identities of apps which are not registered
+func (r *Registry) UpgradeIdentity(b64Identity string) (*string, error) {
+	if _, err := r.resolve(b64Identity, true); err != nil {
+		return nil, err
+	}
+	return UpgradeIdentity(b64Identity)
+}
+
+// VerifyIdentity is like the package level VerifyIdentity, using the
+// config of the app b64Identity belongs to, which must be registered
+func (r *Registry) VerifyIdentity(b64Identity string, userID string) error {
+	config, err := r.Resolve(b64Identity)
+	if err != nil {
+		return err
+	}
+	return VerifyIdentity(config, b64Identity, userID)
+}
+
+// ValidateProvisionalIdentity is like the package level
+// ValidateProvisionalIdentity, but rejects identities of apps which are
+// not registered
+func (r *Registry) ValidateProvisionalIdentity(b64Identity string) error {
+	if _, err := r.Resolve(b64Identity); err != nil {
+		return err
+	}
+	return ValidateProvisionalIdentity(b64Identity)
+}
@@ -0,0 +1,183 @@
+package identity_test
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/TankerHQ/identity-go/v3"
+	"github.com/TankerHQ/identity-go/v3/store/storetest"
+)
+
+func TestRegistry(t *testing.T) {
+	other := storetest.NewConfig()
+	registry, err := identity.NewRegistry(validConf, other)
+	if err != nil {
+		t.Fatal("error creating registry:", err)
+	}
+
+	for _, config := range []identity.Config{validConf, other} {
+		secret, _ := identity.Create(config, "alice")
+		provisional, _ := identity.CreateProvisional(config, "email", "alice@example.com")
+		public, _ := identity.GetPublicIdentity(*secret)
+
+		for _, b64 := range []string{*secret, *provisional, *public} {
+			resolved, err := registry.Resolve(b64)
+			if err != nil {
+				t.Fatal("error resolving identity:", err)
+			}
+			if resolved.AppID != config.AppID {
+				t.Fatal("identity resolved to the wrong app")
+			}
+		}
+		if _, err := registry.GetPublicIdentity(*provisional); err != nil {
+			t.Fatal("error getting public identity:", err)
+		}
+		if _, err := registry.UpgradeIdentity(*public); err != nil {
+			t.Fatal("error upgrading identity:", err)
+		}
+		if err := registry.VerifyIdentity(*secret, "alice"); err != nil {
+			t.Fatal("error verifying identity:", err)
+		}
+		if err := registry.ValidateProvisionalIdentity(*provisional); err != nil {
+			t.Fatal("error validating provisional identity:", err)
+		}
+	}
+}
+
+func TestRegistry_UnknownApp(t *testing.T) {
+	registry, _ := identity.NewRegistry(validConf)
+	unknown := storetest.NewConfig()
+	secret, _ := identity.Create(unknown, "alice")
+	provisional, _ := identity.CreateProvisional(unknown, "email", "alice@example.com")
+	public, _ := identity.GetPublicIdentity(*secret)
+
+	if _, err := registry.GetPublicIdentity(*secret); !errors.Is(err, identity.ErrUnknownApp) {
+		t.Fatal("expected ErrUnknownApp, got", err)
+	}
+	if _, err := registry.UpgradeIdentity(*public); !errors.Is(err, identity.ErrUnknownApp) {
+		t.Fatal("expected ErrUnknownApp, got", err)
+	}
+	if err := registry.VerifyIdentity(*secret, "alice"); !errors.Is(err, identity.ErrUnknownApp) {
+		t.Fatal("expected ErrUnknownApp, got", err)
+	}
+	if err := registry.ValidateProvisionalIdentity(*provisional); !errors.Is(err, identity.ErrUnknownApp) {
+		t.Fatal("expected ErrUnknownApp, got", err)
+	}
+	if _, err := registry.Issuer(unknown.AppID); !errors.Is(err, identity.ErrUnknownApp) {
+		t.Fatal("expected ErrUnknownApp, got", err)
+	}
+	if _, err := registry.Resolve(notBase64Identity); !errors.Is(err, identity.ErrMalformedIdentity) {
+		t.Fatal("expected ErrMalformedIdentity, got", err)
+	}
+}
+
+func TestRegistry_Register(t *testing.T) {
+	registry, _ := identity.NewRegistry()
+	if err := registry.Register(validConf); err != nil {
+		t.Fatal("error registering config:", err)
+	}
+	if err := registry.Register(validConf); err != nil {
+		t.Fatal("error registering the same config twice:", err)
+	}
+	if err := registry.Register(identity.Config{AppID: validConf.AppID, AppSecret: storetest.NewConfig().AppSecret}); !errors.Is(err, identity.ErrInvalidConfig) {
+		t.Fatal("expected ErrInvalidConfig, got", err)
+	}
+	for _, badConf := range badConfsVector {
+		if _, err := identity.NewRegistry(badConf.config); !errors.Is(err, identity.ErrInvalidConfig) {
+			t.Fatalf("expected ErrInvalidConfig for %s, got %v", badConf.desc, err)
+		}
+	}
+	if appIDs := registry.AppIDs(); len(appIDs) != 1 || appIDs[0] != validConf.AppID {
+		t.Fatal("wrong registered app IDs")
+	}
+}
+
+func TestRegistry_RegisterNonCanonical(t *testing.T) {
+	registry, _ := identity.NewRegistry()
+	config := identity.Config{AppID: validConf.AppID + "\n", AppSecret: validConf.AppSecret[:40] + "\r\n" + validConf.AppSecret[40:]}
+	if err := registry.Register(config); err != nil {
+		t.Fatal("error registering config:", err)
+	}
+	if err := registry.Register(validConf); err != nil {
+		t.Fatal("error registering the canonical config:", err)
+	}
+	if appIDs := registry.AppIDs(); len(appIDs) != 1 || appIDs[0] != validConf.AppID {
+		t.Fatal("app registered under a non-canonical app ID")
+	}
+	if registered, err := registry.Config(config.AppID); err != nil || registered != validConf {
+		t.Fatal("error getting config by non-canonical app ID:", err)
+	}
+
+	id, err := identity.Create(validConf, "alice")
+	if err != nil {
+		panic("error creating identity")
+	}
+	if _, err := registry.Resolve(*id); err != nil {
+		t.Fatal("error resolving identity:", err)
+	}
+	if _, err := registry.GetPublicIdentity(*id); err != nil {
+		t.Fatal("error getting public identity:", err)
+	}
+}
+
+func TestNewRegistryFromDir(t *testing.T) {
+	dir := t.TempDir()
+	other := storetest.NewConfig()
+	files := map[string]string{
+		"app1.json":  `{"app_id": "` + validConf.AppID + `", "app_secret": "` + validConf.AppSecret + `"}`,
+		"app2.json":  `{"app_id": "` + other.AppID + `", "app_secret": "` + other.AppSecret + `"}`,
+		"README.txt": "not a config",
+	}
+	for name, content := range files {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0o600); err != nil {
+			panic("error writing config file")
+		}
+	}
+
+	registry, err := identity.NewRegistryFromDir(dir)
+	if err != nil {
+		t.Fatal("error loading registry:", err)
+	}
+	if len(registry.AppIDs()) != 2 {
+		t.Fatal("wrong number of registered apps")
+	}
+	if config, err := registry.Config(other.AppID); err != nil || config.AppSecret != other.AppSecret {
+		t.Fatal("config not loaded")
+	}
+
+	if err := os.WriteFile(filepath.Join(dir, "broken.json"), []byte(`{"app_secret": "`+validConf.AppSecret), 0o600); err != nil {
+		panic("error writing config file")
+	}
+	if _, err := identity.NewRegistryFromDir(dir); !errors.Is(err, identity.ErrInvalidConfig) {
+		t.Fatal("expected ErrInvalidConfig, got", err)
+	}
+}
+
+func TestNewRegistryFromEnv(t *testing.T) {
+	other := storetest.NewConfig()
+	registry, err := identity.NewRegistryFromEnv([]string{
+		"PATH=/usr/bin",
+		"TANKER_APP_ID=" + validConf.AppID,
+		"TANKER_APP_SECRET=" + validConf.AppSecret,
+		"TANKER_APP_STAGING_ID=" + other.AppID,
+		"TANKER_APP_STAGING_SECRET=" + other.AppSecret,
+	})
+	if err != nil {
+		t.Fatal("error loading registry:", err)
+	}
+	if len(registry.AppIDs()) != 2 {
+		t.Fatal("wrong number of registered apps")
+	}
+
+	incomplete := [][]string{
+		{"TANKER_APP_STAGING_ID=" + other.AppID},
+		{"TANKER_APP_STAGING_SECRET=" + other.AppSecret},
+	}
+	for _, environ := range incomplete {
+		if _, err := identity.NewRegistryFromEnv(environ); !errors.Is(err, identity.ErrInvalidConfig) {
+			t.Fatal("expected ErrInvalidConfig, got", err)
+		}
+	}
+}
@@ -0,0 +1,82 @@
+package server
+
+import (
+	"errors"
+	"net/http"
+)
+
+// ErrUnauthenticated is returned by an Authenticator when a request does
+// not carry valid credentials
+var ErrUnauthenticated = errors.New("unauthenticated")
+
+// Authenticator resolves the user making a request
+type Authenticator interface {
+	// Authenticate returns the ID of the user making r. It must return an
+	// error, typically ErrUnauthenticated, if r is not authenticated.
+	Authenticate(r *http.Request) (userID string, err error)
+}
+
+// AuthenticatorFunc is an adapter to use ordinary functions as
+// Authenticators
+type AuthenticatorFunc func(r *http.Request) (string, error)
+
+// Authenticate calls f(r)
+func (f AuthenticatorFunc) Authenticate(r *http.Request) (string, error) {
+	return f(r)
+}
+
+// Contact is an email address or a phone number of a user
+type Contact struct {
+	// Target is "email" or "phone_number"
+	Target string
+	// Value is the email address or phone number
+	Value string
+}
+
+// ContactVerifier is implemented by Authenticators which know the email
+// addresses and phone numbers the caller has proven to own. The server
+// only delivers provisional secret identities for these.
+type ContactVerifier interface {
+	// VerifiedContacts returns the verified contacts of the user making r,
+	// who was authenticated first
+	VerifiedContacts(r *http.Request) ([]Contact, error)
+}
+
+// HeaderAuthenticator trusts a request header set by an authenticating
+// reverse proxy to hold the user ID. It must only be used when the
+// server cannot be reached without going through such a proxy.
+type HeaderAuthenticator struct {
+	// Header is the name of the header holding the user ID
+	Header string
+	// EmailHeader and PhoneNumberHeader are the names of the headers
+	// holding the verified email address and phone number of the user, if
+	// the proxy sets them. The proxy must not set them for unverified
+	// contacts.
+	EmailHeader       string
+	PhoneNumberHeader string
+}
+
+// Authenticate implements Authenticator
+func (a HeaderAuthenticator) Authenticate(r *http.Request) (string, error) {
+	userID := r.Header.Get(a.Header)
+	if userID == "" {
+		return "", ErrUnauthenticated
+	}
+	return userID, nil
+}
+
+// VerifiedContacts implements ContactVerifier
+func (a HeaderAuthenticator) VerifiedContacts(r *http.Request) ([]Contact, error) {
+	var contacts []Contact
+	if a.EmailHeader != "" {
+		if email := r.Header.Get(a.EmailHeader); email != "" {
+			contacts = append(contacts, Contact{Target: "email", Value: email})
+		}
+	}
+	if a.PhoneNumberHeader != "" {
+		if phoneNumber := r.Header.Get(a.PhoneNumberHeader); phoneNumber != "" {
+			contacts = append(contacts, Contact{Target: "phone_number", Value: phoneNumber})
+		}
+	}
+	return contacts, nil
+}
@@ -0,0 +1,261 @@
+// Package server provides an HTTP handler delivering Tanker identities to
+// authenticated users, following the flow described in the README.
+//
+// The handler serves the following endpoints:
+//
+//	GET /identity
+//	    returns the secret identity of the caller, creating it on first call
+//	GET /public-identities?user_id=alice&user_id=bob
+//	    returns the public identities of existing users, at most
+//	    MaxPublicIdentities per request
+//	GET /provisional-identity?target=email&value=bob@example.com
+//	    returns the public provisional identity for an email or phone
+//	    number, creating the secret provisional identity on first call; the
+//	    value is normalized with store.NormalizeProvisionalValue
+//	GET /pending-provisional-identities
+//	    returns the provisional secret identities for the email address and
+//	    phone number of the caller, as verified by the Authenticator, which
+//	    must implement ContactVerifier, that are not claimed yet; see
+//	    store.MarkClaimed
+//
+// All responses are JSON objects. Errors are reported as {"error": "..."}
+// with an appropriate status code.
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+
+	"github.com/TankerHQ/identity-go/v3"
+	"github.com/TankerHQ/identity-go/v3/store"
+)
+
+// MaxPublicIdentities is the maximum number of user_id values accepted by
+// GET /public-identities
+const MaxPublicIdentities = 100
+
+// Server is an http.Handler delivering identities for a single app
+type Server struct {
+	issuer *identity.Issuer
+	auth   Authenticator
+	store  store.IdentityStore
+	mux    *http.ServeMux
+	logger *slog.Logger
+}
+
+// Option configures a Server
+type Option func(*Server)
+
+// WithLogger makes the Server log internal errors to logger instead of
+// slog.Default()
+func WithLogger(logger *slog.Logger) Option {
+	return func(s *Server) {
+		s.logger = logger
+	}
+}
+
+// New returns a Server delivering identities of the app described by
+// config to the users authenticated by auth, and persisting them in store
+func New(config identity.Config, auth Authenticator, identityStore store.IdentityStore, opts ...Option) (*Server, error) {
+	issuer, err := identity.NewIssuer(config)
+	if err != nil {
+		return nil, err
+	}
+
+	s := &Server{
+		issuer: issuer,
+		auth:   auth,
+		store:  identityStore,
+		mux:    http.NewServeMux(),
+		logger: slog.Default(),
+	}
+	for _, opt := range opts {
+		opt(s)
+	}
+
+	s.mux.HandleFunc("GET /identity", s.handleIdentity)
+	s.mux.HandleFunc("GET /public-identities", s.handlePublicIdentities)
+	s.mux.HandleFunc("GET /provisional-identity", s.handleProvisionalIdentity)
+	s.mux.HandleFunc("GET /pending-provisional-identities", s.handlePendingProvisionalIdentities)
+	return s, nil
+}
+
+// ServeHTTP implements http.Handler
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mux.ServeHTTP(w, r)
+}
+
+func (s *Server) handleIdentity(w http.ResponseWriter, r *http.Request) {
+	userID, ok := s.authenticate(w, r)
+	if !ok {
+		return
+	}
+
+	id, err := store.GetOrCreateWithIssuer(r.Context(), s.store, s.issuer, userID)
+	if err != nil {
+		s.writeError(w, r, err)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, map[string]string{"identity": id})
+}
+
+func (s *Server) handlePublicIdentities(w http.ResponseWriter, r *http.Request) {
+	if _, ok := s.authenticate(w, r); !ok {
+		return
+	}
+
+	userIDs := r.URL.Query()["user_id"]
+	if len(userIDs) == 0 {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "missing user_id"})
+		return
+	}
+
+	if len(userIDs) > MaxPublicIdentities {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "too many user_id"})
+		return
+	}
+
+	// public identities are derived from the user IDs: the secret
+	// identities only need to exist, not to be read
+	publicIdentities := make([]string, 0, len(userIDs))
+	for _, userID := range userIDs {
+		exists, err := store.Exists(r.Context(), s.store, store.UserKey(s.issuer.AppID(), userID))
+		if err != nil {
+			s.writeError(w, r, err)
+			return
+		}
+		if !exists {
+			s.writeError(w, r, store.ErrNotFound)
+			return
+		}
+		public, err := s.issuer.PublicIdentity(userID)
+		if err != nil {
+			s.writeError(w, r, err)
+			return
+		}
+		publicIdentities = append(publicIdentities, *public)
+	}
+
+	writeJSON(w, http.StatusOK, map[string][]string{"public_identities": publicIdentities})
+}
+
+func (s *Server) handleProvisionalIdentity(w http.ResponseWriter, r *http.Request) {
+	if _, ok := s.authenticate(w, r); !ok {
+		return
+	}
+
+	target := r.URL.Query().Get("target")
+	value := r.URL.Query().Get("value")
+	if target == "" || value == "" {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "missing target or value"})
+		return
+	}
+
+	_, public, err := store.GetOrCreateProvisionalWithIssuer(r.Context(), s.store, s.issuer, target, value)
+	if err != nil {
+		s.writeError(w, r, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]string{"public_identity": public})
+}
+
+type pendingProvisionalIdentity struct {
+	Target         string `json:"target"`
+	Value          string `json:"value"`
+	Identity       string `json:"identity"`
+	PublicIdentity string `json:"public_identity"`
+}
+
+func (s *Server) handlePendingProvisionalIdentities(w http.ResponseWriter, r *http.Request) {
+	userID, ok := s.authenticate(w, r)
+	if !ok {
+		return
+	}
+	verifier, ok := s.auth.(ContactVerifier)
+	if !ok {
+		writeJSON(w, http.StatusForbidden, map[string]string{"error": "contact verification unsupported"})
+		return
+	}
+	contacts, err := verifier.VerifiedContacts(r)
+	if err != nil {
+		writeJSON(w, http.StatusUnauthorized, map[string]string{"error": "unauthenticated"})
+		return
+	}
+
+	pending := []pendingProvisionalIdentity{}
+	for _, contact := range contacts {
+		value, err := store.NormalizeProvisionalValue(contact.Target, contact.Value)
+		if err != nil {
+			s.writeError(w, r, err)
+			return
+		}
+		key := store.ProvisionalKey(s.issuer.AppID(), contact.Target, value)
+		record, err := s.store.Get(r.Context(), key)
+		if errors.Is(err, store.ErrNotFound) {
+			continue
+		}
+		if err != nil {
+			s.writeError(w, r, err)
+			return
+		}
+		claimant, err := store.Claimant(r.Context(), s.store, key)
+		if err == nil {
+			if claimant != userID {
+				s.logger.WarnContext(r.Context(), "verified contact claimed by another user", "target", contact.Target)
+			}
+			continue
+		}
+		if !errors.Is(err, store.ErrNotFound) {
+			s.writeError(w, r, err)
+			return
+		}
+
+		public, err := identity.GetPublicIdentity(record.Identity)
+		if err != nil {
+			s.writeError(w, r, err)
+			return
+		}
+		pending = append(pending, pendingProvisionalIdentity{
+			Target:         contact.Target,
+			Value:          value,
+			Identity:       record.Identity,
+			PublicIdentity: *public,
+		})
+	}
+
+	writeJSON(w, http.StatusOK, map[string][]pendingProvisionalIdentity{"provisional_identities": pending})
+}
+
+func (s *Server) authenticate(w http.ResponseWriter, r *http.Request) (string, bool) {
+	userID, err := s.auth.Authenticate(r)
+	if err != nil {
+		writeJSON(w, http.StatusUnauthorized, map[string]string{"error": "unauthenticated"})
+		return "", false
+	}
+	return userID, true
+}
+
+// writeError reports err to the client without exposing internal details
+func (s *Server) writeError(w http.ResponseWriter, r *http.Request, err error) {
+	switch {
+	case errors.Is(err, store.ErrNotFound):
+		writeJSON(w, http.StatusNotFound, map[string]string{"error": "not found"})
+	case errors.Is(err, identity.ErrUnsupportedTarget):
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "unsupported target"})
+	case errors.Is(err, store.ErrInvalidValue):
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid value"})
+	default:
+		s.logger.ErrorContext(r.Context(), "identity server error", "path", r.URL.Path, "error", err)
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "internal error"})
+	}
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v) //nolint: errcheck
+}
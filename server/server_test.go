@@ -0,0 +1,308 @@
+package server_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/TankerHQ/identity-go/v3"
+	"github.com/TankerHQ/identity-go/v3/server"
+	"github.com/TankerHQ/identity-go/v3/store"
+	"github.com/TankerHQ/identity-go/v3/store/storetest"
+)
+
+var (
+	validConf = storetest.NewConfig()
+)
+
+// failingStore makes every lookup fail
+type failingStore struct {
+	*store.Memory
+}
+
+func (failingStore) Get(context.Context, store.Key) (store.Record, error) {
+	return store.Record{}, errors.New("database is down")
+}
+
+const userHeader = "X-User-ID"
+
+func newTestServer(t *testing.T, identityStore store.IdentityStore) *httptest.Server {
+	t.Helper()
+	srv, err := server.New(validConf, server.HeaderAuthenticator{Header: userHeader}, identityStore,
+		server.WithLogger(slog.New(slog.NewTextHandler(io.Discard, nil))))
+	if err != nil {
+		t.Fatal("error creating server")
+	}
+	ts := httptest.NewServer(srv)
+	t.Cleanup(ts.Close)
+	return ts
+}
+
+func get(t *testing.T, ts *httptest.Server, userID string, path string, into interface{}) int {
+	t.Helper()
+	req, err := http.NewRequest(http.MethodGet, ts.URL+path, nil)
+	if err != nil {
+		panic("error creating request")
+	}
+	if userID != "" {
+		req.Header.Set(userHeader, userID)
+	}
+	resp, err := ts.Client().Do(req)
+	if err != nil {
+		t.Fatal("error sending request")
+	}
+	defer resp.Body.Close()
+	if into != nil {
+		if err := json.NewDecoder(resp.Body).Decode(into); err != nil {
+			t.Fatal("error decoding response")
+		}
+	}
+	return resp.StatusCode
+}
+
+func TestNew_Error(t *testing.T) {
+	_, err := server.New(identity.Config{}, server.HeaderAuthenticator{Header: userHeader}, store.NewMemory())
+	if err == nil {
+		t.Fatal("no error creating server with invalid config")
+	}
+}
+
+func TestIdentity(t *testing.T) {
+	ts := newTestServer(t, store.NewMemory())
+
+	var first, second struct{ Identity string }
+	if get(t, ts, "alice", "/identity", &first) != http.StatusOK {
+		t.Fatal("error getting identity")
+	}
+	if err := identity.VerifyIdentity(validConf, first.Identity, "alice"); err != nil {
+		t.Fatal("delivered identity is invalid:", err)
+	}
+
+	if get(t, ts, "alice", "/identity", &second) != http.StatusOK {
+		t.Fatal("error getting identity")
+	}
+	if first.Identity != second.Identity {
+		t.Fatal("identity changed between calls")
+	}
+}
+
+func TestIdentity_Error(t *testing.T) {
+	ts := newTestServer(t, store.NewMemory())
+
+	if get(t, ts, "", "/identity", nil) != http.StatusUnauthorized {
+		t.Fatal("no error getting identity without authentication")
+	}
+
+	failing := newTestServer(t, failingStore{store.NewMemory()})
+	var body struct{ Error string }
+	if get(t, failing, "alice", "/identity", &body) != http.StatusInternalServerError {
+		t.Fatal("no error getting identity with failing store")
+	}
+	if body.Error != "internal error" {
+		t.Fatal("internal error details leaked:", body.Error)
+	}
+
+	resp, err := ts.Client().Post(ts.URL+"/identity", "application/json", bytes.NewReader(nil))
+	if err != nil {
+		t.Fatal("error sending request")
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusMethodNotAllowed {
+		t.Fatal("POST should not be allowed")
+	}
+}
+
+func TestPublicIdentities(t *testing.T) {
+	ts := newTestServer(t, store.NewMemory())
+
+	var alice, bob struct{ Identity string }
+	get(t, ts, "alice", "/identity", &alice)
+	get(t, ts, "bob", "/identity", &bob)
+
+	var body struct {
+		PublicIdentities []string `json:"public_identities"`
+	}
+	if get(t, ts, "alice", "/public-identities?user_id=alice&user_id=bob", &body) != http.StatusOK {
+		t.Fatal("error getting public identities")
+	}
+	if len(body.PublicIdentities) != 2 {
+		t.Fatal("wrong number of public identities")
+	}
+	for i, id := range []string{alice.Identity, bob.Identity} {
+		expected, _ := identity.GetPublicIdentity(id)
+		if body.PublicIdentities[i] != *expected {
+			t.Fatal("wrong public identity")
+		}
+	}
+}
+
+func TestPublicIdentities_Error(t *testing.T) {
+	ts := newTestServer(t, store.NewMemory())
+
+	if get(t, ts, "", "/public-identities?user_id=alice", nil) != http.StatusUnauthorized {
+		t.Fatal("no error getting public identities without authentication")
+	}
+	if get(t, ts, "alice", "/public-identities", nil) != http.StatusBadRequest {
+		t.Fatal("no error getting public identities without user_id")
+	}
+	if get(t, ts, "alice", "/public-identities?user_id=nobody", nil) != http.StatusNotFound {
+		t.Fatal("no error getting public identity of unknown user")
+	}
+	query := strings.Repeat("user_id=alice&", server.MaxPublicIdentities+1)
+	if get(t, ts, "alice", "/public-identities?"+query, nil) != http.StatusBadRequest {
+		t.Fatal("no error getting too many public identities")
+	}
+}
+
+func TestProvisionalIdentity(t *testing.T) {
+	ts := newTestServer(t, store.NewMemory())
+	query := "/provisional-identity?" + url.Values{"target": {"email"}, "value": {"bob@example.com"}}.Encode()
+
+	var first, second struct {
+		PublicIdentity string `json:"public_identity"`
+	}
+	if get(t, ts, "alice", query, &first) != http.StatusOK {
+		t.Fatal("error getting provisional identity")
+	}
+	otherQuery := "/provisional-identity?" + url.Values{"target": {"email"}, "value": {" Bob@Example.com"}}.Encode()
+	if get(t, ts, "charlie", otherQuery, &second) != http.StatusOK {
+		t.Fatal("error getting provisional identity")
+	}
+	if first.PublicIdentity != second.PublicIdentity {
+		t.Fatal("provisional identity changed between calls")
+	}
+
+	parsed, err := identity.ParsePublicIdentity(first.PublicIdentity)
+	if err != nil || parsed.Kind() != identity.KindPublicProvisional {
+		t.Fatal("not a public provisional identity")
+	}
+}
+
+func TestProvisionalIdentity_Error(t *testing.T) {
+	ts := newTestServer(t, store.NewMemory())
+
+	if get(t, ts, "", "/provisional-identity?target=email&value=bob", nil) != http.StatusUnauthorized {
+		t.Fatal("no error getting provisional identity without authentication")
+	}
+	if get(t, ts, "alice", "/provisional-identity?target=email", nil) != http.StatusBadRequest {
+		t.Fatal("no error getting provisional identity without value")
+	}
+	if get(t, ts, "alice", "/provisional-identity?target=fax&value=bob", nil) != http.StatusBadRequest {
+		t.Fatal("no error getting provisional identity with unsupported target")
+	}
+	if get(t, ts, "alice", "/provisional-identity?target=email&value=bob", nil) != http.StatusBadRequest {
+		t.Fatal("no error getting provisional identity with invalid email")
+	}
+}
+
+const emailHeader = "X-Verified-Email"
+
+func TestPendingProvisionalIdentities(t *testing.T) {
+	ctx := context.Background()
+	identityStore := store.NewMemory()
+	auth := server.HeaderAuthenticator{Header: userHeader, EmailHeader: emailHeader}
+	srv, err := server.New(validConf, auth, identityStore, server.WithLogger(slog.New(slog.NewTextHandler(io.Discard, nil))))
+	if err != nil {
+		t.Fatal("error creating server")
+	}
+	ts := httptest.NewServer(srv)
+	defer ts.Close()
+
+	pending := func(userID string, email string) []map[string]string {
+		req, _ := http.NewRequest(http.MethodGet, ts.URL+"/pending-provisional-identities", nil)
+		req.Header.Set(userHeader, userID)
+		if email != "" {
+			req.Header.Set(emailHeader, email)
+		}
+		resp, err := ts.Client().Do(req)
+		if err != nil {
+			t.Fatal("error sending request")
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			t.Fatal("error getting pending provisional identities:", resp.StatusCode)
+		}
+		var body struct {
+			ProvisionalIdentities []map[string]string `json:"provisional_identities"`
+		}
+		if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+			t.Fatal("error decoding response")
+		}
+		return body.ProvisionalIdentities
+	}
+
+	if len(pending("bob", "bob@example.com")) != 0 {
+		t.Fatal("pending provisional identity before any was created")
+	}
+
+	secret, public, err := store.GetOrCreateProvisional(ctx, identityStore, validConf, "email", "bob@example.com")
+	if err != nil {
+		panic("error creating provisional identity")
+	}
+	if len(pending("bob", "")) != 0 {
+		t.Fatal("provisional identity delivered without verified email")
+	}
+	if len(pending("mallory", "mallory@example.com")) != 0 {
+		t.Fatal("provisional identity delivered for another email")
+	}
+
+	identities := pending("bob", "Bob@example.com")
+	if len(identities) != 1 || identities[0]["identity"] != secret || identities[0]["public_identity"] != public || identities[0]["value"] != "bob@example.com" {
+		t.Fatal("wrong pending provisional identities")
+	}
+
+	if err := store.MarkClaimed(ctx, identityStore, validConf.AppID, "email", "bob@example.com", "bob"); err != nil {
+		t.Fatal("error marking provisional identity claimed:", err)
+	}
+	if len(pending("bob", "bob@example.com")) != 0 {
+		t.Fatal("claimed provisional identity still pending")
+	}
+}
+
+func TestPendingProvisionalIdentities_Error(t *testing.T) {
+	ts := newTestServer(t, store.NewMemory())
+	if get(t, ts, "", "/pending-provisional-identities", nil) != http.StatusUnauthorized {
+		t.Fatal("no error getting pending provisional identities without authentication")
+	}
+
+	auth := server.AuthenticatorFunc(func(r *http.Request) (string, error) { return "bob", nil })
+	srv, err := server.New(validConf, auth, store.NewMemory())
+	if err != nil {
+		t.Fatal("error creating server")
+	}
+	ts = httptest.NewServer(srv)
+	defer ts.Close()
+	if get(t, ts, "", "/pending-provisional-identities", nil) != http.StatusForbidden {
+		t.Fatal("no error getting pending provisional identities without contact verification")
+	}
+}
+
+func TestAuthenticatorFunc(t *testing.T) {
+	auth := server.AuthenticatorFunc(func(r *http.Request) (string, error) {
+		if r.URL.Query().Get("token") != "secret" {
+			return "", server.ErrUnauthenticated
+		}
+		return "alice", nil
+	})
+	srv, err := server.New(validConf, auth, store.NewMemory())
+	if err != nil {
+		t.Fatal("error creating server")
+	}
+	ts := httptest.NewServer(srv)
+	defer ts.Close()
+
+	if get(t, ts, "", "/identity?token=secret", nil) != http.StatusOK {
+		t.Fatal("error getting identity with valid token")
+	}
+	if get(t, ts, "", "/identity?token=wrong", nil) != http.StatusUnauthorized {
+		t.Fatal("no error getting identity with wrong token")
+	}
+}
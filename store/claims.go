@@ -0,0 +1,123 @@
+package store
+
+import (
+	"context"
+	"errors"
+)
+
+// ErrAlreadyClaimed is returned by MarkClaimed when a provisional identity
+// was claimed by another user
+var ErrAlreadyClaimed = errors.New("provisional identity already claimed")
+
+// ErrClaimsUnsupported is returned by MarkClaimed when the store does not
+// implement ClaimStore
+var ErrClaimsUnsupported = errors.New("identity store does not keep claims")
+
+// ClaimStore is implemented by stores which keep track of the provisional
+// identities claimed by users, see MarkClaimed.
+//
+// Claims are kept apart from the identity records: Range does not return
+// them, and deleting the record of a provisional identity leaves its
+// claim. Implementations must be safe for concurrent use.
+type ClaimStore interface {
+	// Claim records that userID claimed the provisional identity of key,
+	// unless another user already did. It returns the ID of the user who
+	// claimed key once the operation is done.
+	Claim(ctx context.Context, key Key, userID string) (string, error)
+	// Claimant returns the ID of the user who claimed key, or ErrNotFound
+	Claimant(ctx context.Context, key Key) (string, error)
+	// ClaimedBy returns the keys claimed by userID in appID, in the order
+	// they were claimed
+	ClaimedBy(ctx context.Context, appID string, userID string) ([]Key, error)
+	// Unclaim removes the claims of userID in appID. Removing the claims
+	// of a user with none is not an error.
+	Unclaim(ctx context.Context, appID string, userID string) error
+}
+
+// MarkClaimed records that userID claimed the provisional identity for
+// target and value stored in s, typically once they attached it with the
+// Tanker SDK. value is normalized with NormalizeProvisionalValue.
+//
+// s must implement ClaimStore, or ErrClaimsUnsupported is returned.
+// Marking the same claim again is a no-op, but a provisional identity
+// claimed by another user cannot be claimed, and ErrAlreadyClaimed is
+// returned.
+func MarkClaimed(ctx context.Context, s IdentityStore, appID string, target string, value string, userID string) error {
+	claims, ok := s.(ClaimStore)
+	if !ok {
+		return ErrClaimsUnsupported
+	}
+	normalized, err := NormalizeProvisionalValue(target, value)
+	if err != nil {
+		return err
+	}
+	provisional := ProvisionalKey(appID, target, normalized)
+	if _, err := s.Get(ctx, provisional); err != nil {
+		return err
+	}
+
+	claimant, err := claims.Claim(ctx, provisional, userID)
+	if err != nil {
+		return err
+	}
+	if claimant != userID {
+		return ErrAlreadyClaimed
+	}
+	return nil
+}
+
+// ClaimedBy returns the keys of the provisional identities claimed by
+// userID, in the order they were claimed. Stores which do not implement
+// ClaimStore have no claims.
+func ClaimedBy(ctx context.Context, s IdentityStore, appID string, userID string) ([]Key, error) {
+	claims, ok := s.(ClaimStore)
+	if !ok {
+		return nil, nil
+	}
+	return claims.ClaimedBy(ctx, appID, userID)
+}
+
+// Claimant returns the ID of the user who claimed the provisional
+// identity stored for key, or ErrNotFound if it was not claimed
+func Claimant(ctx context.Context, s IdentityStore, key Key) (string, error) {
+	claims, ok := s.(ClaimStore)
+	if !ok {
+		return "", ErrNotFound
+	}
+	return claims.Claimant(ctx, key)
+}
+
+// claimIndex keeps the claims of Memory and File, which must guard it
+type claimIndex struct {
+	claimants map[Key]string
+	// claimed lists the keys claimed by each user, under their UserKey
+	claimed map[Key][]Key
+}
+
+func newClaimIndex() claimIndex {
+	return claimIndex{claimants: map[Key]string{}, claimed: map[Key][]Key{}}
+}
+
+// claim records the claim of key by userID unless key is claimed, and
+// returns the claimant of key
+func (c *claimIndex) claim(key Key, userID string) string {
+	if claimant, found := c.claimants[key]; found {
+		return claimant
+	}
+	c.claimants[key] = userID
+	user := UserKey(key.AppID, userID)
+	c.claimed[user] = append(c.claimed[user], key)
+	return userID
+}
+
+func (c *claimIndex) claimedBy(appID string, userID string) []Key {
+	return append([]Key(nil), c.claimed[UserKey(appID, userID)]...)
+}
+
+func (c *claimIndex) unclaim(appID string, userID string) {
+	user := UserKey(appID, userID)
+	for _, key := range c.claimed[user] {
+		delete(c.claimants, key)
+	}
+	delete(c.claimed, user)
+}
@@ -0,0 +1,152 @@
+package store_test
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+
+	"github.com/TankerHQ/identity-go/v3/store"
+	"github.com/TankerHQ/identity-go/v3/store/storetest"
+)
+
+func TestMarkClaimed(t *testing.T) {
+	ctx := context.Background()
+	config := storetest.NewConfig()
+	s := store.NewMemory()
+
+	for _, target := range []string{"email", "phone_number"} {
+		value := map[string]string{"email": "bob@example.com", "phone_number": "+33600000000"}[target]
+		if _, _, err := store.GetOrCreateProvisional(ctx, s, config, target, value); err != nil {
+			panic("error creating provisional identity")
+		}
+	}
+
+	if err := store.MarkClaimed(ctx, s, config.AppID, "email", " Bob@Example.com", "bob"); err != nil {
+		t.Fatal("error marking provisional identity claimed:", err)
+	}
+	if err := store.MarkClaimed(ctx, s, config.AppID, "phone_number", "+33 6 00 00 00 00", "bob"); err != nil {
+		t.Fatal("error marking provisional identity claimed:", err)
+	}
+	// marking a claim again is a no-op
+	if err := store.MarkClaimed(ctx, s, config.AppID, "email", "bob@example.com", "bob"); err != nil {
+		t.Fatal("error marking provisional identity claimed again:", err)
+	}
+
+	claimed, err := store.ClaimedBy(ctx, s, config.AppID, "bob")
+	if err != nil {
+		t.Fatal("error getting claimed provisional identities:", err)
+	}
+	expected := []store.Key{
+		store.ProvisionalKey(config.AppID, "email", "bob@example.com"),
+		store.ProvisionalKey(config.AppID, "phone_number", "+33600000000"),
+	}
+	if len(claimed) != len(expected) || claimed[0] != expected[0] || claimed[1] != expected[1] {
+		t.Fatalf("wrong claimed provisional identities: %v", claimed)
+	}
+
+	claimant, err := store.Claimant(ctx, s, expected[0])
+	if err != nil || claimant != "bob" {
+		t.Fatal("wrong claimant")
+	}
+
+	if claimed, err := store.ClaimedBy(ctx, s, config.AppID, "alice"); err != nil || len(claimed) != 0 {
+		t.Fatal("claims of another user returned")
+	}
+}
+
+func TestMarkClaimed_Error(t *testing.T) {
+	ctx := context.Background()
+	config := storetest.NewConfig()
+	s := store.NewMemory()
+	if _, _, err := store.GetOrCreateProvisional(ctx, s, config, "email", "bob@example.com"); err != nil {
+		panic("error creating provisional identity")
+	}
+
+	if err := store.MarkClaimed(ctx, s, config.AppID, "email", "alice@example.com", "alice"); !errors.Is(err, store.ErrNotFound) {
+		t.Fatal("expected ErrNotFound, got", err)
+	}
+	if err := store.MarkClaimed(ctx, s, config.AppID, "email", "bob", "bob"); !errors.Is(err, store.ErrInvalidValue) {
+		t.Fatal("expected ErrInvalidValue, got", err)
+	}
+	if err := store.MarkClaimed(ctx, s, config.AppID, "email", "bob@example.com", "bob"); err != nil {
+		t.Fatal("error marking provisional identity claimed:", err)
+	}
+	if err := store.MarkClaimed(ctx, s, config.AppID, "email", "bob@example.com", "mallory"); !errors.Is(err, store.ErrAlreadyClaimed) {
+		t.Fatal("expected ErrAlreadyClaimed, got", err)
+	}
+	if _, err := store.Claimant(ctx, s, store.ProvisionalKey(config.AppID, "email", "alice@example.com")); !errors.Is(err, store.ErrNotFound) {
+		t.Fatal("expected ErrNotFound, got", err)
+	}
+}
+
+func TestMarkClaimed_Concurrent(t *testing.T) {
+	ctx := context.Background()
+	config := storetest.NewConfig()
+	s := store.NewMemory()
+	emails := []string{"a@example.com", "b@example.com", "c@example.com", "d@example.com"}
+	for _, email := range emails {
+		if _, _, err := store.GetOrCreateProvisional(ctx, s, config, "email", email); err != nil {
+			panic("error creating provisional identity")
+		}
+	}
+
+	var wg sync.WaitGroup
+	for _, email := range emails {
+		wg.Add(1)
+		go func(email string) {
+			defer wg.Done()
+			if err := store.MarkClaimed(ctx, s, config.AppID, "email", email, "bob"); err != nil {
+				t.Error("error marking provisional identity claimed:", err)
+			}
+		}(email)
+	}
+	wg.Wait()
+
+	claimed, err := store.ClaimedBy(ctx, s, config.AppID, "bob")
+	if err != nil || len(claimed) != len(emails) {
+		t.Fatalf("expected %d claims, got %d", len(emails), len(claimed))
+	}
+}
+
+func TestMarkClaimed_Unsupported(t *testing.T) {
+	ctx := context.Background()
+	config := storetest.NewConfig()
+	// hide the ClaimStore methods of the Memory store
+	s := struct{ store.IdentityStore }{store.NewMemory()}
+	if _, _, err := store.GetOrCreateProvisional(ctx, s, config, "email", "bob@example.com"); err != nil {
+		panic("error creating provisional identity")
+	}
+
+	if err := store.MarkClaimed(ctx, s, config.AppID, "email", "bob@example.com", "bob"); !errors.Is(err, store.ErrClaimsUnsupported) {
+		t.Fatal("expected ErrClaimsUnsupported, got", err)
+	}
+	if _, err := store.Claimant(ctx, s, store.ProvisionalKey(config.AppID, "email", "bob@example.com")); !errors.Is(err, store.ErrNotFound) {
+		t.Fatal("expected ErrNotFound, got", err)
+	}
+	if claimed, err := store.ClaimedBy(ctx, s, config.AppID, "bob"); err != nil || len(claimed) != 0 {
+		t.Fatal("claims returned by a store without claims")
+	}
+}
+
+func TestMarkClaimed_NotRecords(t *testing.T) {
+	ctx := context.Background()
+	config := storetest.NewConfig()
+	s := store.NewMemory()
+	if _, _, err := store.GetOrCreateProvisional(ctx, s, config, "email", "bob@example.com"); err != nil {
+		panic("error creating provisional identity")
+	}
+	if err := store.MarkClaimed(ctx, s, config.AppID, "email", "bob@example.com", "bob"); err != nil {
+		t.Fatal("error marking provisional identity claimed:", err)
+	}
+
+	var keys []store.Key
+	s.Range(ctx, func(record store.Record) error { //nolint: errcheck
+		keys = append(keys, record.Key)
+		return nil
+	})
+	key := store.ProvisionalKey(config.AppID, "email", "bob@example.com")
+	if len(keys) != 1 || keys[0] != key {
+		t.Fatalf("claims returned as records: %v", keys)
+	}
+}
@@ -0,0 +1,549 @@
+// Package envelope encrypts the secret identities kept in a
+// store.IdentityStore.
+//
+// Each record is encrypted with its own random data key using
+// XChaCha20-Poly1305, and the data key is stored next to the ciphertext,
+// wrapped with a key-encryption key (KEK) through a KeyWrapper. The app
+// ID, the target and a hash of the user ID or provisional value are bound
+// to the ciphertext as associated data, so a record copied under another
+// key fails to decrypt.
+//
+// Rotating the KEK only requires re-wrapping data keys, which Store.Rewrap
+// does without decrypting the identities.
+//
+// With WithKeyStore, data keys are kept in a separate store instead, so
+// that destroying a data key makes its identity unrecoverable even from
+// backups of the encrypted records. Store.Erase relies on this to erase
+// all the identities of a user.
+package envelope
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/TankerHQ/identity-go/v3/store"
+	"golang.org/x/crypto/blake2b"
+	"golang.org/x/crypto/chacha20poly1305"
+)
+
+// ErrDecrypt is returned when a stored record cannot be decrypted
+var ErrDecrypt = errors.New("unable to decrypt identity")
+
+const sealedVersion = 1
+
+// dataKeyTarget is the Key target of the data keys kept in a key store
+const dataKeyTarget = "data_key"
+
+// wrappedKey is a data key wrapped by the KEK identified by KeyID
+type wrappedKey struct {
+	KeyID      string `json:"kek_id,omitempty"`
+	WrappedKey []byte `json:"wrapped_key,omitempty"`
+}
+
+// sealed is the encrypted form of an identity, stored as JSON in the
+// wrapped store. Its wrapped data key is either embedded, or kept in the
+// key store under DataKeyID.
+type sealed struct {
+	Version int `json:"v"`
+	wrappedKey
+	DataKeyID  string `json:"data_key_id,omitempty"`
+	Nonce      []byte `json:"nonce"`
+	Ciphertext []byte `json:"ciphertext"`
+}
+
+// storedKey is a data key, stored as JSON in the key store
+type storedKey struct {
+	Version int `json:"v"`
+	wrappedKey
+}
+
+// Store is a store.IdentityStore encrypting the identities it stores in
+// another store
+type Store struct {
+	inner       store.IdentityStore
+	keys        store.IdentityStore
+	wrapper     KeyWrapper
+	wrappers    map[string]KeyWrapper
+	random      io.Reader
+	provisional ProvisionalKeysFunc
+	receiptKey  []byte
+}
+
+var (
+	_ store.IdentityStore = (*Store)(nil)
+	_ store.ClaimStore    = (*Store)(nil)
+)
+
+// Option configures a Store
+type Option func(*Store)
+
+// WithPreviousKeys lets the Store decrypt records whose data keys were
+// wrapped by one of wrappers, typically retired KEKs whose records have
+// not been re-wrapped yet
+func WithPreviousKeys(wrappers ...KeyWrapper) Option {
+	return func(s *Store) {
+		for _, wrapper := range wrappers {
+			s.wrappers[wrapper.KeyID()] = wrapper
+		}
+	}
+}
+
+// WithRandom makes the Store draw data keys and nonces from random
+// instead of crypto/rand.Reader
+func WithRandom(random io.Reader) Option {
+	return func(s *Store) {
+		s.random = random
+	}
+}
+
+// WithKeyStore makes the Store keep the wrapped data keys of the records
+// it writes in keys instead of next to the identities. Deleting a data
+// key from keys then makes its identity unrecoverable, including from the
+// backups of the wrapped store, which is what Delete and Erase do. keys
+// should therefore not be backed up for longer than erasures must take to
+// be effective.
+func WithKeyStore(keys store.IdentityStore) Option {
+	return func(s *Store) {
+		s.keys = keys
+	}
+}
+
+// New returns a Store keeping encrypted identities in inner, whose data
+// keys are wrapped by wrapper
+func New(inner store.IdentityStore, wrapper KeyWrapper, opts ...Option) *Store {
+	s := &Store{
+		inner:    inner,
+		wrapper:  wrapper,
+		wrappers: map[string]KeyWrapper{},
+		random:   rand.Reader,
+	}
+	for _, opt := range opts {
+		opt(s)
+	}
+	s.wrappers[wrapper.KeyID()] = wrapper
+	return s
+}
+
+// Get implements store.IdentityStore
+func (s *Store) Get(ctx context.Context, key store.Key) (store.Record, error) {
+	record, err := s.inner.Get(ctx, key)
+	if err != nil {
+		return store.Record{}, err
+	}
+	return s.open(ctx, record)
+}
+
+// Exists implements store.Exister without decrypting the record
+func (s *Store) Exists(ctx context.Context, key store.Key) (bool, error) {
+	return store.Exists(ctx, s.inner, key)
+}
+
+// Put implements store.IdentityStore. The record is replaced with
+// CompareAndSwap on the wrapped store, so that the data key of exactly
+// the record it replaced is destroyed, even when Puts run concurrently.
+func (s *Store) Put(ctx context.Context, key store.Key, identity string) error {
+	encrypted, envelope, err := s.seal(ctx, key, identity)
+	if err != nil {
+		return err
+	}
+	previous, err := s.swap(ctx, key, encrypted)
+	if err != nil {
+		s.destroyDataKey(ctx, key.AppID, envelope) //nolint: errcheck
+		return err
+	}
+	return s.destroyPrevious(ctx, key.AppID, previous)
+}
+
+// swap stores encrypted for key whatever the current record, and returns
+// the encrypted record it replaced
+func (s *Store) swap(ctx context.Context, key store.Key, encrypted string) (store.Record, error) {
+	for {
+		current, err := s.inner.Get(ctx, key)
+		if err != nil && !errors.Is(err, store.ErrNotFound) {
+			return store.Record{}, err
+		}
+		_, swapped, err := s.inner.CompareAndSwap(ctx, key, current.Identity, encrypted)
+		if err != nil {
+			return store.Record{}, err
+		}
+		if swapped {
+			return current, nil
+		}
+		// the record changed since it was read, read it again
+	}
+}
+
+// CompareAndSwap implements store.IdentityStore. Since old is compared to
+// the decrypted identity, the current record is decrypted first, then
+// swapped in the wrapped store if it did not change in the meantime.
+func (s *Store) CompareAndSwap(ctx context.Context, key store.Key, old string, identity string) (store.Record, bool, error) {
+	encrypted, envelope, err := s.seal(ctx, key, identity)
+	if err != nil {
+		return store.Record{}, false, err
+	}
+	record, swapped, previous, err := s.compareAndSwap(ctx, key, old, encrypted)
+	if err != nil || !swapped {
+		s.destroyDataKey(ctx, key.AppID, envelope) //nolint: errcheck
+		return record, swapped, err
+	}
+	record.Identity = identity
+	return record, true, s.destroyPrevious(ctx, key.AppID, previous)
+}
+
+// compareAndSwap stores encrypted if the decrypted identity stored for
+// key is old, and returns the encrypted record it replaced
+func (s *Store) compareAndSwap(ctx context.Context, key store.Key, old string, encrypted string) (store.Record, bool, store.Record, error) {
+	for {
+		var current store.Record
+		if old != "" {
+			var err error
+			current, err = s.inner.Get(ctx, key)
+			if errors.Is(err, store.ErrNotFound) {
+				return store.Record{}, false, store.Record{}, nil
+			}
+			if err != nil {
+				return store.Record{}, false, store.Record{}, err
+			}
+			decrypted, err := s.open(ctx, current)
+			if err != nil {
+				return store.Record{}, false, store.Record{}, err
+			}
+			if decrypted.Identity != old {
+				return decrypted, false, store.Record{}, nil
+			}
+		}
+
+		record, swapped, err := s.inner.CompareAndSwap(ctx, key, current.Identity, encrypted)
+		if err != nil {
+			return store.Record{}, false, store.Record{}, err
+		}
+		if swapped {
+			return record, true, current, nil
+		}
+		if old == "" {
+			decrypted, err := s.open(ctx, record)
+			return decrypted, false, store.Record{}, err
+		}
+		// the record changed since it was read, compare again
+	}
+}
+
+// Touch implements store.IdentityStore
+func (s *Store) Touch(ctx context.Context, key store.Key, at time.Time) error {
+	return s.inner.Touch(ctx, key, at)
+}
+
+// Delete implements store.IdentityStore. When the data key of the record
+// is kept in the key store, it is destroyed first.
+func (s *Store) Delete(ctx context.Context, key store.Key) error {
+	_, _, err := s.delete(ctx, key)
+	return err
+}
+
+// delete destroys the data key of the record stored for key, then deletes
+// the record. It returns whether there was a record, and whether its data
+// key was destroyed.
+func (s *Store) delete(ctx context.Context, key store.Key) (bool, bool, error) {
+	record, err := s.inner.Get(ctx, key)
+	if errors.Is(err, store.ErrNotFound) {
+		return false, false, nil
+	}
+	if err != nil {
+		return false, false, err
+	}
+	shredded := false
+	// records which cannot be decoded hold no data key to destroy
+	if envelope, err := decodeSealed(record.Identity); err == nil && envelope.DataKeyID != "" {
+		if err := s.destroyDataKey(ctx, key.AppID, envelope); err != nil {
+			return true, false, err
+		}
+		shredded = true
+	}
+	return true, shredded, s.inner.Delete(ctx, key)
+}
+
+// Range implements store.IdentityStore. It stops with an error at the
+// first record that cannot be decrypted.
+func (s *Store) Range(ctx context.Context, fn func(store.Record) error) error {
+	return s.inner.Range(ctx, func(record store.Record) error {
+		decrypted, err := s.open(ctx, record)
+		if err != nil {
+			return err
+		}
+		return fn(decrypted)
+	})
+}
+
+// Claim implements store.ClaimStore by claiming key in the wrapped store,
+// or returns store.ErrClaimsUnsupported if it does not keep claims.
+// Claims hold no identity, so they are not encrypted.
+func (s *Store) Claim(ctx context.Context, key store.Key, userID string) (string, error) {
+	claims, ok := s.inner.(store.ClaimStore)
+	if !ok {
+		return "", store.ErrClaimsUnsupported
+	}
+	return claims.Claim(ctx, key, userID)
+}
+
+// Claimant implements store.ClaimStore
+func (s *Store) Claimant(ctx context.Context, key store.Key) (string, error) {
+	return store.Claimant(ctx, s.inner, key)
+}
+
+// ClaimedBy implements store.ClaimStore
+func (s *Store) ClaimedBy(ctx context.Context, appID string, userID string) ([]store.Key, error) {
+	return store.ClaimedBy(ctx, s.inner, appID, userID)
+}
+
+// Unclaim implements store.ClaimStore
+func (s *Store) Unclaim(ctx context.Context, appID string, userID string) error {
+	claims, ok := s.inner.(store.ClaimStore)
+	if !ok {
+		return nil
+	}
+	return claims.Unclaim(ctx, appID, userID)
+}
+
+// Rewrap wraps again under the current KEK the data keys wrapped by a
+// previous one, in the wrapped store and in the key store if any, and
+// returns how many data keys were updated. The identities themselves are
+// not decrypted. Once it returns without error, the previous KEKs are no
+// longer needed.
+func (s *Store) Rewrap(ctx context.Context) (int, error) {
+	rewrapped := 0
+	rewrap := func(target store.IdentityStore, record store.Record, envelope interface{}, wk *wrappedKey) error {
+		if wk.KeyID == s.wrapper.KeyID() {
+			return nil
+		}
+		dataKey, err := s.unwrap(ctx, *wk)
+		if err != nil {
+			return err
+		}
+		wk.KeyID = s.wrapper.KeyID()
+		wk.WrappedKey, err = s.wrapper.Wrap(ctx, dataKey)
+		if err != nil {
+			return err
+		}
+		encoded, err := json.Marshal(envelope)
+		if err != nil {
+			return err
+		}
+		// a record replaced concurrently was sealed under the current KEK
+		_, swapped, err := target.CompareAndSwap(ctx, record.Key, record.Identity, string(encoded))
+		if swapped {
+			rewrapped++
+		}
+		return err
+	}
+
+	err := s.inner.Range(ctx, func(record store.Record) error {
+		envelope, err := decodeSealed(record.Identity)
+		if err != nil {
+			return err
+		}
+		if envelope.DataKeyID != "" {
+			return nil
+		}
+		return rewrap(s.inner, record, envelope, &envelope.wrappedKey)
+	})
+	if err != nil || s.keys == nil {
+		return rewrapped, err
+	}
+
+	err = s.keys.Range(ctx, func(record store.Record) error {
+		stored, err := decodeStoredKey(record.Identity)
+		if err != nil {
+			return err
+		}
+		return rewrap(s.keys, record, stored, &stored.wrappedKey)
+	})
+	return rewrapped, err
+}
+
+// seal encrypts identity with a new data key, storing the data key in
+// the key store if any, and returns the encoded and decoded envelope
+func (s *Store) seal(ctx context.Context, key store.Key, identity string) (string, *sealed, error) {
+	dataKey := make([]byte, chacha20poly1305.KeySize)
+	nonce := make([]byte, chacha20poly1305.NonceSizeX)
+	if _, err := io.ReadFull(s.random, dataKey); err != nil {
+		return "", nil, err
+	}
+	if _, err := io.ReadFull(s.random, nonce); err != nil {
+		return "", nil, err
+	}
+
+	aead, err := chacha20poly1305.NewX(dataKey)
+	if err != nil {
+		return "", nil, err
+	}
+	wrapped, err := s.wrapper.Wrap(ctx, dataKey)
+	if err != nil {
+		return "", nil, err
+	}
+	envelope := &sealed{
+		Version:    sealedVersion,
+		Nonce:      nonce,
+		Ciphertext: aead.Seal(nil, nonce, []byte(identity), associatedData(key)),
+	}
+	wk := wrappedKey{KeyID: s.wrapper.KeyID(), WrappedKey: wrapped}
+
+	if s.keys == nil {
+		envelope.wrappedKey = wk
+	} else {
+		id := make([]byte, 16)
+		if _, err := io.ReadFull(s.random, id); err != nil {
+			return "", nil, err
+		}
+		envelope.DataKeyID = base64.RawURLEncoding.EncodeToString(id)
+		encodedKey, err := json.Marshal(storedKey{Version: sealedVersion, wrappedKey: wk})
+		if err != nil {
+			return "", nil, err
+		}
+		if err := s.keys.Put(ctx, dataKeyKey(key.AppID, envelope.DataKeyID), string(encodedKey)); err != nil {
+			return "", nil, err
+		}
+	}
+
+	encoded, err := json.Marshal(envelope)
+	if err != nil {
+		s.destroyDataKey(ctx, key.AppID, envelope) //nolint: errcheck
+		return "", nil, err
+	}
+	return string(encoded), envelope, nil
+}
+
+func (s *Store) open(ctx context.Context, record store.Record) (store.Record, error) {
+	envelope, err := decodeSealed(record.Identity)
+	if err != nil {
+		return store.Record{}, err
+	}
+	wk := envelope.wrappedKey
+	if envelope.DataKeyID != "" {
+		if wk, err = s.storedKey(ctx, record.Key.AppID, envelope.DataKeyID); err != nil {
+			return store.Record{}, err
+		}
+	}
+	dataKey, err := s.unwrap(ctx, wk)
+	if err != nil {
+		return store.Record{}, err
+	}
+	aead, err := chacha20poly1305.NewX(dataKey)
+	if err != nil {
+		return store.Record{}, fmt.Errorf("%w: %w", ErrDecrypt, err)
+	}
+	if len(envelope.Nonce) != aead.NonceSize() {
+		return store.Record{}, fmt.Errorf("%w: wrong nonce size", ErrDecrypt)
+	}
+	identity, err := aead.Open(nil, envelope.Nonce, envelope.Ciphertext, associatedData(record.Key))
+	if err != nil {
+		return store.Record{}, fmt.Errorf("%w: %w", ErrDecrypt, err)
+	}
+	record.Identity = string(identity)
+	return record, nil
+}
+
+func (s *Store) storedKey(ctx context.Context, appID string, dataKeyID string) (wrappedKey, error) {
+	if s.keys == nil {
+		return wrappedKey{}, fmt.Errorf("%w: no key store to read data key from", ErrDecrypt)
+	}
+	record, err := s.keys.Get(ctx, dataKeyKey(appID, dataKeyID))
+	if errors.Is(err, store.ErrNotFound) {
+		return wrappedKey{}, fmt.Errorf("%w: data key was destroyed", ErrDecrypt)
+	}
+	if err != nil {
+		return wrappedKey{}, err
+	}
+	stored, err := decodeStoredKey(record.Identity)
+	if err != nil {
+		return wrappedKey{}, err
+	}
+	return stored.wrappedKey, nil
+}
+
+func (s *Store) unwrap(ctx context.Context, wk wrappedKey) ([]byte, error) {
+	wrapper, found := s.wrappers[wk.KeyID]
+	if !found {
+		return nil, fmt.Errorf("%w: unknown KEK %q", ErrDecrypt, wk.KeyID)
+	}
+	dataKey, err := wrapper.Unwrap(ctx, wk.WrappedKey)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrDecrypt, err)
+	}
+	return dataKey, nil
+}
+
+// destroyDataKey deletes the data key of envelope from the key store, if
+// it is kept there
+func (s *Store) destroyDataKey(ctx context.Context, appID string, envelope *sealed) error {
+	if envelope.DataKeyID == "" {
+		return nil
+	}
+	if s.keys == nil {
+		return fmt.Errorf("no key store to destroy data key %s from", envelope.DataKeyID)
+	}
+	return s.keys.Delete(ctx, dataKeyKey(appID, envelope.DataKeyID))
+}
+
+// destroyPrevious destroys the data key of a record which was replaced
+func (s *Store) destroyPrevious(ctx context.Context, appID string, previous store.Record) error {
+	if previous.Identity == "" {
+		return nil
+	}
+	envelope, err := decodeSealed(previous.Identity)
+	if err != nil {
+		return nil
+	}
+	if err := s.destroyDataKey(ctx, appID, envelope); err != nil {
+		return fmt.Errorf("identity stored, but the data key of the one it replaced was not destroyed: %w", err)
+	}
+	return nil
+}
+
+func dataKeyKey(appID string, dataKeyID string) store.Key {
+	return store.Key{AppID: appID, Target: dataKeyTarget, Value: dataKeyID}
+}
+
+func decodeSealed(encoded string) (*sealed, error) {
+	envelope := new(sealed)
+	if err := json.Unmarshal([]byte(encoded), envelope); err != nil {
+		return nil, fmt.Errorf("%w: record is not encrypted", ErrDecrypt)
+	}
+	if envelope.Version != sealedVersion {
+		return nil, fmt.Errorf("%w: unsupported version %d", ErrDecrypt, envelope.Version)
+	}
+	return envelope, nil
+}
+
+func decodeStoredKey(encoded string) (*storedKey, error) {
+	stored := new(storedKey)
+	if err := json.Unmarshal([]byte(encoded), stored); err != nil {
+		return nil, fmt.Errorf("%w: data key is malformed", ErrDecrypt)
+	}
+	if stored.Version != sealedVersion {
+		return nil, fmt.Errorf("%w: unsupported data key version %d", ErrDecrypt, stored.Version)
+	}
+	return stored, nil
+}
+
+// associatedData binds a ciphertext to the app ID, the target and a hash
+// of the user ID or provisional value of its record
+func associatedData(key store.Key) []byte {
+	valueHash := valueHash(key)
+	ad := make([]byte, 0, len(key.AppID)+len(key.Target)+len(valueHash)+2)
+	ad = append(ad, key.AppID...)
+	ad = append(ad, 0)
+	ad = append(ad, key.Target...)
+	ad = append(ad, 0)
+	return append(ad, valueHash[:]...)
+}
+
+func valueHash(key store.Key) []byte {
+	hash := blake2b.Sum256([]byte(key.Value))
+	return hash[:]
+}
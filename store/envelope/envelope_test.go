@@ -0,0 +1,192 @@
+package envelope_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/TankerHQ/identity-go/v3"
+	"github.com/TankerHQ/identity-go/v3/store"
+	"github.com/TankerHQ/identity-go/v3/store/envelope"
+	"github.com/TankerHQ/identity-go/v3/store/storetest"
+)
+
+func newWrapper(id string) *envelope.LocalKeyWrapper {
+	kek := make([]byte, 32)
+	copy(kek, id)
+	wrapper, err := envelope.NewLocalKeyWrapper(id, kek, nil)
+	if err != nil {
+		panic("error creating key wrapper")
+	}
+	return wrapper
+}
+
+func TestStore(t *testing.T) {
+	storetest.Run(t, func(t *testing.T) store.IdentityStore {
+		return envelope.New(store.NewMemory(), newWrapper("kek"))
+	})
+}
+
+func TestStore_EncryptedAtRest(t *testing.T) {
+	ctx := context.Background()
+	inner := store.NewMemory()
+	s := envelope.New(inner, newWrapper("kek"))
+	config := storetest.NewConfig()
+
+	id, err := store.GetOrCreate(ctx, s, config, "alice")
+	if err != nil {
+		t.Fatal("error creating identity:", err)
+	}
+	stored, err := inner.Get(ctx, store.UserKey(config.AppID, "alice"))
+	if err != nil {
+		t.Fatal("error getting stored record:", err)
+	}
+	if stored.Identity == id || strings.Contains(stored.Identity, id[:16]) {
+		t.Fatal("identity stored in clear")
+	}
+
+	record, err := s.Get(ctx, store.UserKey(config.AppID, "alice"))
+	if err != nil || record.Identity != id {
+		t.Fatal("wrong decrypted identity")
+	}
+	if err := identity.VerifyIdentity(config, record.Identity, "alice"); err != nil {
+		t.Fatal("error verifying decrypted identity:", err)
+	}
+}
+
+func TestStore_AssociatedData(t *testing.T) {
+	ctx := context.Background()
+	inner := store.NewMemory()
+	s := envelope.New(inner, newWrapper("kek"))
+	alice := store.UserKey("app", "alice")
+	bob := store.UserKey("app", "bob")
+
+	if err := s.Put(ctx, alice, "alice identity"); err != nil {
+		t.Fatal("error putting identity:", err)
+	}
+	stored, _ := inner.Get(ctx, alice)
+	for _, key := range []store.Key{bob, store.UserKey("otherApp", "alice"), store.ProvisionalKey("app", "email", "alice")} {
+		if err := inner.Put(ctx, key, stored.Identity); err != nil {
+			panic("error putting record")
+		}
+		if _, err := s.Get(ctx, key); !errors.Is(err, envelope.ErrDecrypt) {
+			t.Fatalf("expected ErrDecrypt for record copied to %v, got %v", key, err)
+		}
+	}
+}
+
+func TestStore_Error(t *testing.T) {
+	ctx := context.Background()
+	inner := store.NewMemory()
+	key := store.UserKey("app", "alice")
+	if err := envelope.New(inner, newWrapper("kek")).Put(ctx, key, "identity"); err != nil {
+		t.Fatal("error putting identity:", err)
+	}
+
+	t.Run("UnknownKEK", func(t *testing.T) {
+		s := envelope.New(inner, newWrapper("other"))
+		if _, err := s.Get(ctx, key); !errors.Is(err, envelope.ErrDecrypt) {
+			t.Fatal("expected ErrDecrypt, got", err)
+		}
+	})
+
+	t.Run("WrongKEK", func(t *testing.T) {
+		wrong, _ := envelope.NewLocalKeyWrapper("kek", make([]byte, 32), nil)
+		s := envelope.New(inner, wrong)
+		if _, err := s.Get(ctx, key); !errors.Is(err, envelope.ErrDecrypt) {
+			t.Fatal("expected ErrDecrypt, got", err)
+		}
+	})
+
+	t.Run("NotEncrypted", func(t *testing.T) {
+		plain := store.NewMemory()
+		if err := plain.Put(ctx, key, "identity"); err != nil {
+			panic("error putting record")
+		}
+		s := envelope.New(plain, newWrapper("kek"))
+		if _, err := s.Get(ctx, key); !errors.Is(err, envelope.ErrDecrypt) {
+			t.Fatal("expected ErrDecrypt, got", err)
+		}
+	})
+
+	t.Run("BadKEKSize", func(t *testing.T) {
+		if _, err := envelope.NewLocalKeyWrapper("kek", make([]byte, 16), nil); err == nil {
+			t.Fatal("no error creating key wrapper with a short KEK")
+		}
+	})
+}
+
+func TestStore_Exists(t *testing.T) {
+	ctx := context.Background()
+	inner := store.NewMemory()
+	key := store.UserKey("app", "alice")
+	if err := envelope.New(inner, newWrapper("kek")).Put(ctx, key, "identity"); err != nil {
+		t.Fatal("error putting identity:", err)
+	}
+
+	// existence is checked without decrypting, so any KEK will do
+	s := envelope.New(inner, newWrapper("other"))
+	if exists, err := store.Exists(ctx, s, key); err != nil || !exists {
+		t.Fatal("error checking existing record:", err)
+	}
+	if exists, err := store.Exists(ctx, s, store.UserKey("app", "bob")); err != nil || exists {
+		t.Fatal("error checking missing record:", err)
+	}
+}
+
+func sealedRecord(t *testing.T, s store.IdentityStore, key store.Key) map[string]interface{} {
+	record, err := s.Get(context.Background(), key)
+	if err != nil {
+		t.Fatal("error getting stored record:", err)
+	}
+	sealed := map[string]interface{}{}
+	if err := json.Unmarshal([]byte(record.Identity), &sealed); err != nil {
+		t.Fatal("error decoding stored record:", err)
+	}
+	return sealed
+}
+
+func TestStore_Rewrap(t *testing.T) {
+	ctx := context.Background()
+	inner := store.NewMemory()
+	oldKEK, newKEK := newWrapper("old"), newWrapper("new")
+	keys := []store.Key{store.UserKey("app", "alice"), store.UserKey("app", "bob")}
+
+	old := envelope.New(inner, oldKEK)
+	for _, key := range keys {
+		if err := old.Put(ctx, key, key.Value+" identity"); err != nil {
+			t.Fatal("error putting identity:", err)
+		}
+	}
+	before := sealedRecord(t, inner, keys[0])
+
+	s := envelope.New(inner, newKEK, envelope.WithPreviousKeys(oldKEK))
+	rewrapped, err := s.Rewrap(ctx)
+	if err != nil || rewrapped != len(keys) {
+		t.Fatalf("expected %d records rewrapped, got %d (%v)", len(keys), rewrapped, err)
+	}
+
+	after := sealedRecord(t, inner, keys[0])
+	if after["kek_id"] != "new" || after["wrapped_key"] == before["wrapped_key"] {
+		t.Fatal("data key not rewrapped")
+	}
+	if after["ciphertext"] != before["ciphertext"] || after["nonce"] != before["nonce"] {
+		t.Fatal("identity encrypted again by Rewrap")
+	}
+
+	// the old KEK is no longer needed
+	s = envelope.New(inner, newKEK)
+	for _, key := range keys {
+		record, err := s.Get(ctx, key)
+		if err != nil || record.Identity != key.Value+" identity" {
+			t.Fatal("wrong identity after Rewrap")
+		}
+	}
+
+	rewrapped, err = s.Rewrap(ctx)
+	if err != nil || rewrapped != 0 {
+		t.Fatal("Rewrap is not idempotent")
+	}
+}
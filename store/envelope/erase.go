@@ -0,0 +1,145 @@
+package envelope
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"io"
+	"time"
+
+	"github.com/TankerHQ/identity-go/v3/store"
+)
+
+// ProvisionalKeysFunc returns the keys of the provisional identities
+// associated with userID, such as the ones for their email address and
+// phone number, which Erase erases along with their permanent identity
+type ProvisionalKeysFunc func(ctx context.Context, appID string, userID string) ([]store.Key, error)
+
+// WithProvisionalKeys makes Erase also erase the records returned by
+// provisional, such as the provisional identities returned by
+// store.ClaimedBy
+func WithProvisionalKeys(provisional ProvisionalKeysFunc) Option {
+	return func(s *Store) {
+		s.provisional = provisional
+	}
+}
+
+// WithReceiptKey makes Erase identify the erased user and records in its
+// receipts by their HMAC-SHA256 under key. User IDs, email addresses and
+// phone numbers are easily enumerated, so only someone holding key can
+// tell which ones a receipt is about. key should be at least 32 random
+// bytes, and kept as long as the receipts need to be matched.
+func WithReceiptKey(key []byte) Option {
+	return func(s *Store) {
+		s.receiptKey = key
+	}
+}
+
+// Receipt records an erasure, for compliance records. The erased user and
+// records are only identified by keyed hashes when the Store has a
+// receipt key, see WithReceiptKey, and not at all otherwise.
+type Receipt struct {
+	// ID is a random identifier of the erasure
+	ID string `json:"id"`
+	// AppID is the app the erased user belonged to
+	AppID string `json:"app_id"`
+	// UserIDHash is the base64 HMAC-SHA256 of the erased user ID under
+	// the receipt key, empty without one
+	UserIDHash string `json:"user_id_hash,omitempty"`
+	// ErasedAt is when the erasure completed
+	ErasedAt time.Time `json:"erased_at"`
+	// Records lists the records which were erased, if any
+	Records []ErasedRecord `json:"records"`
+}
+
+// ErasedRecord describes one of the records erased by Erase
+type ErasedRecord struct {
+	// Target is the target of the record key, such as "user", "email" or
+	// "phone_number"
+	Target string `json:"target"`
+	// ValueHash is the base64 HMAC-SHA256 of the record key value under
+	// the receipt key, empty without one
+	ValueHash string `json:"value_hash,omitempty"`
+	// Shredded tells whether the data key of the record was destroyed,
+	// which makes copies of the record unrecoverable. It is false for
+	// records whose data key was stored next to the identity, which were
+	// only deleted, and may still be recovered from backups. Only records
+	// stored before the key store was set up can be in that case.
+	Shredded bool `json:"shredded"`
+}
+
+// ErrNoKeyStore is returned by Erase when the Store has no key store, see
+// WithKeyStore. Deleting the records would leave them recoverable from
+// backups, so nothing is erased.
+var ErrNoKeyStore = errors.New("erasure requires a key store")
+
+// Erase erases the permanent identity of userID, and the provisional
+// identities returned by the function given to WithProvisionalKeys if
+// any. The data key of each record is destroyed before the record is
+// deleted, so that an interrupted erasure leaves no readable identity
+// behind and can safely be retried. The claims of userID, see
+// store.MarkClaimed, are removed last.
+//
+// Erasing a user with no records is not an error: the returned receipt
+// then lists no records. Erase fails with ErrNoKeyStore if the Store has
+// no key store.
+func (s *Store) Erase(ctx context.Context, appID string, userID string) (*Receipt, error) {
+	if s.keys == nil {
+		return nil, ErrNoKeyStore
+	}
+	userKey := store.UserKey(appID, userID)
+	keys := []store.Key{userKey}
+	if s.provisional != nil {
+		provisional, err := s.provisional(ctx, appID, userID)
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, provisional...)
+	}
+
+	id := make([]byte, 16)
+	if _, err := io.ReadFull(s.random, id); err != nil {
+		return nil, err
+	}
+	receipt := &Receipt{
+		ID:         hex.EncodeToString(id),
+		AppID:      appID,
+		UserIDHash: s.receiptHash(userKey),
+		Records:    []ErasedRecord{},
+	}
+
+	for _, key := range keys {
+		found, shredded, err := s.delete(ctx, key)
+		if err != nil {
+			return nil, err
+		}
+		if !found {
+			continue
+		}
+		receipt.Records = append(receipt.Records, ErasedRecord{
+			Target:    key.Target,
+			ValueHash: s.receiptHash(key),
+			Shredded:  shredded,
+		})
+	}
+	if err := s.Unclaim(ctx, appID, userID); err != nil {
+		return nil, err
+	}
+
+	receipt.ErasedAt = time.Now().UTC()
+	return receipt, nil
+}
+
+// receiptHash identifies the value of key in receipts, if the Store has a
+// receipt key
+func (s *Store) receiptHash(key store.Key) string {
+	if s.receiptKey == nil {
+		return ""
+	}
+	mac := hmac.New(sha256.New, s.receiptKey)
+	mac.Write([]byte(key.Value))
+	return base64.StdEncoding.EncodeToString(mac.Sum(nil))
+}
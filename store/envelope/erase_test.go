@@ -0,0 +1,260 @@
+package envelope_test
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+
+	"github.com/TankerHQ/identity-go/v3/store"
+	"github.com/TankerHQ/identity-go/v3/store/envelope"
+	"github.com/TankerHQ/identity-go/v3/store/storetest"
+)
+
+func TestStore_KeyStore(t *testing.T) {
+	storetest.Run(t, func(t *testing.T) store.IdentityStore {
+		return envelope.New(store.NewMemory(), newWrapper("kek"), envelope.WithKeyStore(store.NewMemory()))
+	})
+}
+
+func countRecords(s store.IdentityStore) int {
+	count := 0
+	if err := s.Range(context.Background(), func(store.Record) error {
+		count++
+		return nil
+	}); err != nil {
+		panic("error ranging over records")
+	}
+	return count
+}
+
+func TestStore_KeyStoreReplace(t *testing.T) {
+	ctx := context.Background()
+	keys := store.NewMemory()
+	s := envelope.New(store.NewMemory(), newWrapper("kek"), envelope.WithKeyStore(keys))
+	key := store.UserKey("app", "alice")
+
+	if err := s.Put(ctx, key, "first"); err != nil {
+		t.Fatal("error putting identity:", err)
+	}
+	if err := s.Put(ctx, key, "second"); err != nil {
+		t.Fatal("error putting identity:", err)
+	}
+	if _, swapped, err := s.CompareAndSwap(ctx, key, "second", "third"); err != nil || !swapped {
+		t.Fatal("error swapping identity:", err)
+	}
+	if _, swapped, err := s.CompareAndSwap(ctx, key, "", "fourth"); err != nil || swapped {
+		t.Fatal("CompareAndSwap replaced an existing record")
+	}
+	if countRecords(keys) != 1 {
+		t.Fatal("data keys of replaced records not destroyed")
+	}
+
+	if err := s.Delete(ctx, key); err != nil {
+		t.Fatal("error deleting identity:", err)
+	}
+	if countRecords(keys) != 0 {
+		t.Fatal("data key of deleted record not destroyed")
+	}
+}
+
+// barrierStore makes its first n calls to Get wait for each other, so
+// that they all read the same record
+type barrierStore struct {
+	store.IdentityStore
+	n       int32
+	calls   atomic.Int32
+	waiting sync.WaitGroup
+}
+
+func newBarrierStore(inner store.IdentityStore, n int) *barrierStore {
+	s := &barrierStore{IdentityStore: inner, n: int32(n)}
+	s.waiting.Add(n)
+	return s
+}
+
+func (s *barrierStore) Get(ctx context.Context, key store.Key) (store.Record, error) {
+	record, err := s.IdentityStore.Get(ctx, key)
+	if s.calls.Add(1) <= s.n {
+		s.waiting.Done()
+		s.waiting.Wait()
+	}
+	return record, err
+}
+
+func TestStore_KeyStoreConcurrentPut(t *testing.T) {
+	ctx := context.Background()
+	keys := store.NewMemory()
+	const puts = 10
+	s := envelope.New(newBarrierStore(store.NewMemory(), puts), newWrapper("kek"), envelope.WithKeyStore(keys))
+	key := store.UserKey("app", "alice")
+
+	var wg sync.WaitGroup
+	for i := 0; i < puts; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			if err := s.Put(ctx, key, fmt.Sprint(i)); err != nil {
+				t.Error("error putting identity:", err)
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	// only the data key of the last record written is left
+	if countRecords(keys) != 1 {
+		t.Fatal("data keys of replaced records not destroyed")
+	}
+	if _, err := s.Get(ctx, key); err != nil {
+		t.Fatal("error getting identity:", err)
+	}
+}
+
+func TestStore_KeyStoreRewrap(t *testing.T) {
+	ctx := context.Background()
+	inner, keys := store.NewMemory(), store.NewMemory()
+	oldKEK, newKEK := newWrapper("old"), newWrapper("new")
+	key := store.UserKey("app", "alice")
+
+	if err := envelope.New(inner, oldKEK, envelope.WithKeyStore(keys)).Put(ctx, key, "identity"); err != nil {
+		t.Fatal("error putting identity:", err)
+	}
+	s := envelope.New(inner, newKEK, envelope.WithKeyStore(keys), envelope.WithPreviousKeys(oldKEK))
+	if rewrapped, err := s.Rewrap(ctx); err != nil || rewrapped != 1 {
+		t.Fatal("error rewrapping data keys:", err)
+	}
+
+	s = envelope.New(inner, newKEK, envelope.WithKeyStore(keys))
+	if record, err := s.Get(ctx, key); err != nil || record.Identity != "identity" {
+		t.Fatal("wrong identity after Rewrap")
+	}
+}
+
+func receiptHash(key []byte, value string) string {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(value))
+	return base64.StdEncoding.EncodeToString(mac.Sum(nil))
+}
+
+func TestErase(t *testing.T) {
+	ctx := context.Background()
+	inner, keys := store.NewMemory(), store.NewMemory()
+	emailKey := store.ProvisionalKey("app", "email", "alice@example.com")
+	phoneKey := store.ProvisionalKey("app", "phone_number", "+33600000000")
+	receiptKey := make([]byte, 32)
+	s := envelope.New(inner, newWrapper("kek"),
+		envelope.WithKeyStore(keys),
+		envelope.WithReceiptKey(receiptKey),
+		envelope.WithProvisionalKeys(func(ctx context.Context, appID string, userID string) ([]store.Key, error) {
+			return []store.Key{emailKey, phoneKey}, nil
+		}),
+	)
+
+	userKey := store.UserKey("app", "alice")
+	bobKey := store.UserKey("app", "bob")
+	for _, key := range []store.Key{userKey, emailKey, bobKey} {
+		if err := s.Put(ctx, key, key.Value+" identity"); err != nil {
+			t.Fatal("error putting identity:", err)
+		}
+	}
+
+	if _, err := s.Claim(ctx, emailKey, "alice"); err != nil {
+		t.Fatal("error claiming provisional identity:", err)
+	}
+
+	backup := store.NewMemory()
+	if err := inner.Range(ctx, func(record store.Record) error {
+		return backup.Put(ctx, record.Key, record.Identity)
+	}); err != nil {
+		panic("error backing up records")
+	}
+
+	receipt, err := s.Erase(ctx, "app", "alice")
+	if err != nil {
+		t.Fatal("error erasing user:", err)
+	}
+	if receipt.AppID != "app" || receipt.ID == "" || receipt.ErasedAt.IsZero() {
+		t.Fatal("incomplete receipt")
+	}
+	if len(receipt.Records) != 2 || receipt.Records[0].Target != "user" || receipt.Records[1].Target != "email" {
+		t.Fatalf("wrong erased records: %+v", receipt.Records)
+	}
+	for _, record := range receipt.Records {
+		if !record.Shredded {
+			t.Fatal("data key not destroyed")
+		}
+	}
+	encoded, _ := json.Marshal(receipt)
+	if strings.Contains(string(encoded), "alice") {
+		t.Fatal("receipt contains personal data")
+	}
+	if receipt.UserIDHash != receiptHash(receiptKey, "alice") || receipt.Records[1].ValueHash != receiptHash(receiptKey, emailKey.Value) {
+		t.Fatal("wrong hashes in receipt")
+	}
+
+	for _, key := range []store.Key{userKey, emailKey} {
+		if _, err := s.Get(ctx, key); !errors.Is(err, store.ErrNotFound) {
+			t.Fatal("erased record still stored")
+		}
+	}
+	if _, err := s.Get(ctx, bobKey); err != nil {
+		t.Fatal("record of another user erased")
+	}
+	if _, err := store.Claimant(ctx, inner, emailKey); !errors.Is(err, store.ErrNotFound) {
+		t.Fatal("claim left after erasure")
+	}
+
+	restored := envelope.New(backup, newWrapper("kek"), envelope.WithKeyStore(keys))
+	for _, key := range []store.Key{userKey, emailKey} {
+		if _, err := restored.Get(ctx, key); !errors.Is(err, envelope.ErrDecrypt) {
+			t.Fatal("erased record recovered from backup")
+		}
+	}
+
+	receipt, err = s.Erase(ctx, "app", "alice")
+	if err != nil || len(receipt.Records) != 0 {
+		t.Fatal("erasing an erased user is not a no-op")
+	}
+}
+
+func TestErase_NoKeyStore(t *testing.T) {
+	ctx := context.Background()
+	s := envelope.New(store.NewMemory(), newWrapper("kek"))
+	key := store.UserKey("app", "alice")
+	if err := s.Put(ctx, key, "identity"); err != nil {
+		t.Fatal("error putting identity:", err)
+	}
+
+	if _, err := s.Erase(ctx, "app", "alice"); !errors.Is(err, envelope.ErrNoKeyStore) {
+		t.Fatal("expected ErrNoKeyStore, got", err)
+	}
+	if _, err := s.Get(ctx, key); err != nil {
+		t.Fatal("record deleted by a failed erasure")
+	}
+}
+
+func TestErase_NoReceiptKey(t *testing.T) {
+	ctx := context.Background()
+	s := envelope.New(store.NewMemory(), newWrapper("kek"), envelope.WithKeyStore(store.NewMemory()))
+	if err := s.Put(ctx, store.UserKey("app", "alice"), "identity"); err != nil {
+		t.Fatal("error putting identity:", err)
+	}
+
+	receipt, err := s.Erase(ctx, "app", "alice")
+	if err != nil {
+		t.Fatal("error erasing user:", err)
+	}
+	if len(receipt.Records) != 1 || !receipt.Records[0].Shredded {
+		t.Fatal("wrong erased records")
+	}
+	if receipt.UserIDHash != "" || receipt.Records[0].ValueHash != "" {
+		t.Fatal("hashes in receipt without a receipt key")
+	}
+}
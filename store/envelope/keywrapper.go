@@ -0,0 +1,76 @@
+package envelope
+
+import (
+	"context"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"io"
+
+	"golang.org/x/crypto/chacha20poly1305"
+)
+
+// KeyWrapper encrypts data keys with a key-encryption key (KEK), usually
+// held by a KMS or an HSM so that it never reaches the application
+type KeyWrapper interface {
+	// KeyID identifies the KEK. It is stored next to each wrapped data
+	// key, so that the matching KeyWrapper can be found to unwrap it.
+	KeyID() string
+	// Wrap returns dataKey encrypted with the KEK
+	Wrap(ctx context.Context, dataKey []byte) ([]byte, error)
+	// Unwrap returns the data key encrypted in wrapped by Wrap
+	Unwrap(ctx context.Context, wrapped []byte) ([]byte, error)
+}
+
+// ErrUnwrap is returned when a data key cannot be unwrapped
+var ErrUnwrap = errors.New("unable to unwrap data key")
+
+// LocalKeyWrapper is a KeyWrapper whose KEK is held in memory. It
+// encrypts data keys with XChaCha20-Poly1305.
+type LocalKeyWrapper struct {
+	id     string
+	kek    []byte
+	random io.Reader
+}
+
+// NewLocalKeyWrapper returns a LocalKeyWrapper for kek, a 32 bytes key
+// identified by id. random is used to draw nonces, crypto/rand.Reader if
+// nil.
+func NewLocalKeyWrapper(id string, kek []byte, random io.Reader) (*LocalKeyWrapper, error) {
+	if len(kek) != chacha20poly1305.KeySize {
+		return nil, fmt.Errorf("wrong byte size for KEK: %d, should be %d", len(kek), chacha20poly1305.KeySize)
+	}
+	if random == nil {
+		random = rand.Reader
+	}
+	return &LocalKeyWrapper{id: id, kek: append([]byte(nil), kek...), random: random}, nil
+}
+
+// KeyID implements KeyWrapper
+func (w *LocalKeyWrapper) KeyID() string {
+	return w.id
+}
+
+// Wrap implements KeyWrapper
+func (w *LocalKeyWrapper) Wrap(ctx context.Context, dataKey []byte) ([]byte, error) {
+	aead, _ := chacha20poly1305.NewX(w.kek)
+	nonce := make([]byte, aead.NonceSize(), aead.NonceSize()+len(dataKey)+aead.Overhead())
+	if _, err := io.ReadFull(w.random, nonce); err != nil {
+		return nil, err
+	}
+	return aead.Seal(nonce, nonce, dataKey, []byte(w.id)), nil
+}
+
+// Unwrap implements KeyWrapper
+func (w *LocalKeyWrapper) Unwrap(ctx context.Context, wrapped []byte) ([]byte, error) {
+	aead, _ := chacha20poly1305.NewX(w.kek)
+	if len(wrapped) < aead.NonceSize() {
+		return nil, ErrUnwrap
+	}
+	nonce, ciphertext := wrapped[:aead.NonceSize()], wrapped[aead.NonceSize():]
+	dataKey, err := aead.Open(nil, nonce, ciphertext, []byte(w.id))
+	if err != nil {
+		return nil, ErrUnwrap
+	}
+	return dataKey, nil
+}
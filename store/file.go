@@ -0,0 +1,486 @@
+package store
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+const (
+	fileOpPut    = "put"
+	fileOpTouch  = "touch"
+	fileOpDelete = "delete"
+	// fileOpClaim records the claim of the provisional identity of Key
+	// by UserID, and fileOpUnclaim removes the claims of the user whose
+	// UserKey is Key
+	fileOpClaim   = "claim"
+	fileOpUnclaim = "unclaim"
+
+	// compactMinEntries is the number of log entries under which a File
+	// is never compacted automatically
+	compactMinEntries = 1024
+)
+
+type fileEntry struct {
+	Op         string     `json:"op"`
+	Key        Key        `json:"key"`
+	Identity   string     `json:"identity,omitempty"`
+	UserID     string     `json:"user_id,omitempty"`
+	CreatedAt  *time.Time `json:"created_at,omitempty"`
+	AccessedAt *time.Time `json:"accessed_at,omitempty"`
+}
+
+func putEntry(record Record) fileEntry {
+	return fileEntry{Op: fileOpPut, Key: record.Key, Identity: record.Identity, CreatedAt: &record.CreatedAt, AccessedAt: &record.AccessedAt}
+}
+
+// File is an IdentityStore and ClaimStore backed by an append-only log
+// file. Every
+// change is appended to the log and synced to disk before the method
+// returns, so a crash never loses an acknowledged write. An incomplete
+// last entry, left by a crash in the middle of a write, is discarded when
+// the file is opened again.
+//
+// The log is compacted, that is rewritten with only the live records and
+// claims, when it holds more than twice as many entries as live records
+// and claims, or when Compact is called.
+//
+// The records are cached in memory, so a log must only be used by one
+// File at a time: OpenFile takes an exclusive lock on the log, and fails
+// with ErrFileLocked if another File, in this process or another one,
+// holds it. On platforms without flock, this is not checked.
+type File struct {
+	mu      sync.RWMutex
+	path    string
+	file    *os.File
+	records map[Key]Record
+	claims  claimIndex
+	entries int
+	// offset is the end of the last complete entry of the log
+	offset int64
+	// err is set when a failed write could not be rolled back, after
+	// which the log is not written anymore
+	err error
+}
+
+var _ ClaimStore = (*File)(nil)
+
+// ErrFileLocked is returned by OpenFile when the log is already in use
+var ErrFileLocked = errors.New("identity store file is already in use")
+
+// OpenFile opens the store logged in the file at path, creating it if
+// needed
+func OpenFile(path string) (*File, error) {
+	file, err := openLocked(path)
+	if err != nil {
+		return nil, err
+	}
+
+	f := &File{path: path, file: file, records: map[Key]Record{}, claims: newClaimIndex()}
+	if err := f.replay(); err != nil {
+		file.Close()
+		return nil, err
+	}
+	if f.stampRecords() {
+		if err := f.compact(); err != nil {
+			f.file.Close()
+			return nil, err
+		}
+	}
+	return f, nil
+}
+
+// openLocked opens the log at path and locks it. Since compaction
+// replaces the log, the file is opened again if it was replaced before
+// being locked.
+func openLocked(path string) (*os.File, error) {
+	for {
+		file, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0o600)
+		if err != nil {
+			return nil, err
+		}
+		if err := lockFile(file); err != nil {
+			file.Close()
+			return nil, err
+		}
+		opened, err := file.Stat()
+		if err != nil {
+			file.Close()
+			return nil, err
+		}
+		current, err := os.Stat(path)
+		if err == nil && os.SameFile(opened, current) {
+			return file, nil
+		}
+		file.Close()
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			return nil, err
+		}
+	}
+}
+
+// stampRecords sets the timestamps of the records logged before they were
+// tracked to the current time, so that their retention starts now, and
+// returns whether there were any
+func (f *File) stampRecords() bool {
+	now := time.Now().UTC()
+	stamped := false
+	for key, record := range f.records {
+		if record.CreatedAt.IsZero() {
+			record.CreatedAt = now
+			record.AccessedAt = now
+			f.records[key] = record
+			stamped = true
+		}
+	}
+	return stamped
+}
+
+// replay loads the records from the log, and truncates an incomplete
+// last entry
+func (f *File) replay() error {
+	reader := bufio.NewReader(f.file)
+	var offset int64
+	for {
+		line, err := reader.ReadBytes('\n')
+		if errors.Is(err, io.EOF) {
+			// a last line without newline is an interrupted write
+			break
+		}
+		if err != nil {
+			return err
+		}
+
+		var entry fileEntry
+		if err := json.Unmarshal(line, &entry); err != nil {
+			if _, peekErr := reader.Peek(1); errors.Is(peekErr, io.EOF) {
+				// a garbled last line is an interrupted write too
+				break
+			}
+			return fmt.Errorf("corrupted identity store %s at offset %d", f.path, offset)
+		}
+		f.apply(entry)
+		offset += int64(len(line))
+	}
+
+	if err := f.file.Truncate(offset); err != nil {
+		return err
+	}
+	f.offset = offset
+	_, err := f.file.Seek(offset, io.SeekStart)
+	return err
+}
+
+func (f *File) apply(entry fileEntry) {
+	switch entry.Op {
+	case fileOpPut:
+		record := Record{Key: entry.Key, Identity: entry.Identity}
+		if entry.CreatedAt != nil {
+			record.CreatedAt = *entry.CreatedAt
+		}
+		if entry.AccessedAt != nil {
+			record.AccessedAt = *entry.AccessedAt
+		}
+		f.records[entry.Key] = record
+	case fileOpTouch:
+		if record, found := f.records[entry.Key]; found && entry.AccessedAt != nil {
+			record.AccessedAt = *entry.AccessedAt
+			f.records[entry.Key] = record
+		}
+	case fileOpDelete:
+		delete(f.records, entry.Key)
+	case fileOpClaim:
+		f.claims.claim(entry.Key, entry.UserID)
+	case fileOpUnclaim:
+		f.claims.unclaim(entry.Key.AppID, entry.Key.Value)
+	}
+	f.entries++
+}
+
+// Get implements IdentityStore
+func (f *File) Get(ctx context.Context, key Key) (Record, error) {
+	if err := ctx.Err(); err != nil {
+		return Record{}, err
+	}
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+	record, found := f.records[key]
+	if !found {
+		return Record{}, ErrNotFound
+	}
+	return record, nil
+}
+
+// Put implements IdentityStore
+func (f *File) Put(ctx context.Context, key Key, identity string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.write(putEntry(newRecord(key, identity)))
+}
+
+// CompareAndSwap implements IdentityStore
+func (f *File) CompareAndSwap(ctx context.Context, key Key, old string, identity string) (Record, bool, error) {
+	if err := ctx.Err(); err != nil {
+		return Record{}, false, err
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	current := f.records[key]
+	if current.Identity != old {
+		return current, false, nil
+	}
+	record := newRecord(key, identity)
+	if err := f.write(putEntry(record)); err != nil {
+		return Record{}, false, err
+	}
+	return record, true, nil
+}
+
+// Touch implements IdentityStore
+func (f *File) Touch(ctx context.Context, key Key, at time.Time) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if _, found := f.records[key]; !found {
+		return ErrNotFound
+	}
+	return f.write(fileEntry{Op: fileOpTouch, Key: key, AccessedAt: &at})
+}
+
+// Delete implements IdentityStore
+func (f *File) Delete(ctx context.Context, key Key) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if _, found := f.records[key]; !found {
+		return nil
+	}
+	return f.write(fileEntry{Op: fileOpDelete, Key: key})
+}
+
+// Range implements IdentityStore
+func (f *File) Range(ctx context.Context, fn func(Record) error) error {
+	f.mu.RLock()
+	records := make([]Record, 0, len(f.records))
+	for _, record := range f.records {
+		records = append(records, record)
+	}
+	f.mu.RUnlock()
+
+	for _, record := range records {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if err := fn(record); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Claim implements ClaimStore
+func (f *File) Claim(ctx context.Context, key Key, userID string) (string, error) {
+	if err := ctx.Err(); err != nil {
+		return "", err
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if claimant, found := f.claims.claimants[key]; found {
+		return claimant, nil
+	}
+	if err := f.write(fileEntry{Op: fileOpClaim, Key: key, UserID: userID}); err != nil {
+		return "", err
+	}
+	return userID, nil
+}
+
+// Claimant implements ClaimStore
+func (f *File) Claimant(ctx context.Context, key Key) (string, error) {
+	if err := ctx.Err(); err != nil {
+		return "", err
+	}
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+	claimant, found := f.claims.claimants[key]
+	if !found {
+		return "", ErrNotFound
+	}
+	return claimant, nil
+}
+
+// ClaimedBy implements ClaimStore
+func (f *File) ClaimedBy(ctx context.Context, appID string, userID string) ([]Key, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+	return f.claims.claimedBy(appID, userID), nil
+}
+
+// Unclaim implements ClaimStore
+func (f *File) Unclaim(ctx context.Context, appID string, userID string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if len(f.claims.claimed[UserKey(appID, userID)]) == 0 {
+		return nil
+	}
+	return f.write(fileEntry{Op: fileOpUnclaim, Key: UserKey(appID, userID)})
+}
+
+// Compact rewrites the log with only the live records and claims
+func (f *File) Compact() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.compact()
+}
+
+// Close closes the log file
+func (f *File) Close() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.file.Close()
+}
+
+// write appends entry to the log and applies it, f.mu must be held.
+//
+// When the entry cannot be written, the log is truncated back to its
+// last complete entry, so that it stays consistent with the records in
+// memory. Once the entry is written, errors from the automatic
+// compaction are ignored: the log is still valid, and compaction is
+// attempted again on the next write.
+func (f *File) write(entry fileEntry) error {
+	if f.err != nil {
+		return f.err
+	}
+	line, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	line = append(line, '\n')
+	if _, err := f.file.Write(line); err != nil {
+		return f.rollback(err)
+	}
+	if err := f.file.Sync(); err != nil {
+		return f.rollback(err)
+	}
+	f.offset += int64(len(line))
+	f.apply(entry)
+
+	if f.entries > compactMinEntries && f.entries > 2*f.live() {
+		f.compact() //nolint: errcheck
+	}
+	return nil
+}
+
+// rollback truncates the log back to its last complete entry after err
+// occurred while writing, f.mu must be held. If that fails too, the File
+// refuses further writes, which could otherwise follow a partial entry
+// and make the log unreadable.
+func (f *File) rollback(err error) error {
+	if truncErr := f.file.Truncate(f.offset); truncErr != nil {
+		f.err = fmt.Errorf("identity store %s is in an unknown state, reopen it: %w", f.path, err)
+		return f.err
+	}
+	if _, seekErr := f.file.Seek(f.offset, io.SeekStart); seekErr != nil {
+		f.err = fmt.Errorf("identity store %s is in an unknown state, reopen it: %w", f.path, err)
+		return f.err
+	}
+	return err
+}
+
+// live returns the number of entries of the compacted log, f.mu must be
+// held
+func (f *File) live() int {
+	return len(f.records) + len(f.claims.claimants)
+}
+
+// compact writes the live records and claims to a temporary file, then
+// atomically replaces the log with it, f.mu must be held
+func (f *File) compact() error {
+	entries := make([]fileEntry, 0, f.live())
+	for _, record := range f.records {
+		entries = append(entries, putEntry(record))
+	}
+	// the claims of each user are written in order, so that ClaimedBy
+	// returns them in the same order once the log is replayed
+	for user, keys := range f.claims.claimed {
+		for _, key := range keys {
+			entries = append(entries, fileEntry{Op: fileOpClaim, Key: key, UserID: user.Value})
+		}
+	}
+
+	var buf bytes.Buffer
+	for _, entry := range entries {
+		line, err := json.Marshal(entry)
+		if err != nil {
+			return err
+		}
+		buf.Write(line)
+		buf.WriteByte('\n')
+	}
+
+	tmpPath := f.path + ".compact"
+	tmp, err := os.OpenFile(tmpPath, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0o600)
+	if err != nil {
+		return err
+	}
+	// the new log must be locked before it replaces the current one
+	if err := lockFile(tmp); err != nil {
+		tmp.Close()
+		os.Remove(tmpPath)
+		return err
+	}
+	if _, err := tmp.Write(buf.Bytes()); err != nil {
+		tmp.Close()
+		os.Remove(tmpPath)
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		os.Remove(tmpPath)
+		return err
+	}
+	if err := os.Rename(tmpPath, f.path); err != nil {
+		tmp.Close()
+		os.Remove(tmpPath)
+		return err
+	}
+	syncDir(filepath.Dir(f.path))
+
+	f.file.Close()
+	f.file = tmp
+	f.entries = len(entries)
+	f.offset = int64(buf.Len())
+	_, err = f.file.Seek(0, io.SeekEnd)
+	return err
+}
+
+// syncDir makes a rename in dir durable. Errors are ignored since not
+// all platforms support syncing directories.
+func syncDir(dir string) {
+	d, err := os.Open(dir)
+	if err != nil {
+		return
+	}
+	d.Sync() //nolint: errcheck
+	d.Close()
+}
@@ -0,0 +1,85 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestFile_WriteError(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "identities.log")
+	f, err := OpenFile(path)
+	if err != nil {
+		t.Fatal("error opening file store:", err)
+	}
+	if err := f.Put(ctx, UserKey("app", "alice"), "alice"); err != nil {
+		t.Fatal("error putting identity:", err)
+	}
+
+	// a read-only handle fails both the write and its rollback
+	readOnly, err := os.Open(path)
+	if err != nil {
+		panic("error opening log")
+	}
+	file := f.file
+	f.file = readOnly
+	if err := f.Put(ctx, UserKey("app", "bob"), "bob"); err == nil {
+		t.Fatal("no error putting identity in a read-only log")
+	}
+	if _, err := f.Get(ctx, UserKey("app", "bob")); !errors.Is(err, ErrNotFound) {
+		t.Fatal("failed write applied in memory")
+	}
+	f.file = file
+	readOnly.Close()
+	if err := f.Put(ctx, UserKey("app", "carol"), "carol"); err == nil {
+		t.Fatal("no error writing after a failed rollback")
+	}
+	f.Close()
+
+	f, err = OpenFile(path)
+	if err != nil {
+		t.Fatal("error reopening file store after failed write:", err)
+	}
+	defer f.Close()
+	if record, err := f.Get(ctx, UserKey("app", "alice")); err != nil || record.Identity != "alice" {
+		t.Fatal("record lost after failed write")
+	}
+}
+
+func TestFile_WriteErrorRollback(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "identities.log")
+	f, err := OpenFile(path)
+	if err != nil {
+		t.Fatal("error opening file store:", err)
+	}
+	if err := f.Put(ctx, UserKey("app", "alice"), "alice"); err != nil {
+		t.Fatal("error putting identity:", err)
+	}
+
+	// simulate a write which failed after reaching the disk partially
+	if _, err := f.file.WriteString(`{"op":"put","key":{"app_id":"app","tar`); err != nil {
+		panic("error writing log")
+	}
+	if err := f.rollback(errors.New("disk full")); err == nil {
+		t.Fatal("rollback did not return the write error")
+	}
+	if err := f.Put(ctx, UserKey("app", "bob"), "bob"); err != nil {
+		t.Fatal("error putting identity after rollback:", err)
+	}
+	f.Close()
+
+	f, err = OpenFile(path)
+	if err != nil {
+		t.Fatal("error reopening file store after rollback:", err)
+	}
+	defer f.Close()
+	for _, userID := range []string{"alice", "bob"} {
+		if record, err := f.Get(ctx, UserKey("app", userID)); err != nil || record.Identity != userID {
+			t.Fatal("record lost after rollback")
+		}
+	}
+}
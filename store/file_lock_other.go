@@ -0,0 +1,10 @@
+//go:build !unix
+
+package store
+
+import "os"
+
+// lockFile does nothing on platforms without flock
+func lockFile(file *os.File) error {
+	return nil
+}
@@ -0,0 +1,268 @@
+package store_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/TankerHQ/identity-go/v3/store"
+	"github.com/TankerHQ/identity-go/v3/store/storetest"
+)
+
+func openFile(t *testing.T, path string) *store.File {
+	t.Helper()
+	f, err := store.OpenFile(path)
+	if err != nil {
+		t.Fatal("error opening file store:", err)
+	}
+	t.Cleanup(func() { f.Close() })
+	return f
+}
+
+func TestFile(t *testing.T) {
+	storetest.Run(t, func(t *testing.T) store.IdentityStore {
+		return openFile(t, filepath.Join(t.TempDir(), "identities.log"))
+	})
+}
+
+func TestFile_Reopen(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "identities.log")
+
+	f := openFile(t, path)
+	if err := f.Put(ctx, store.UserKey("app", "alice"), "alice"); err != nil {
+		t.Fatal("error putting identity:", err)
+	}
+	if err := f.Put(ctx, store.UserKey("app", "bob"), "bob"); err != nil {
+		t.Fatal("error putting identity:", err)
+	}
+	if err := f.Delete(ctx, store.UserKey("app", "bob")); err != nil {
+		t.Fatal("error deleting identity:", err)
+	}
+	f.Close()
+
+	f = openFile(t, path)
+	record, err := f.Get(ctx, store.UserKey("app", "alice"))
+	if err != nil || record.Identity != "alice" {
+		t.Fatal("record lost after reopening")
+	}
+	if _, err := f.Get(ctx, store.UserKey("app", "bob")); !errors.Is(err, store.ErrNotFound) {
+		t.Fatal("deleted record came back after reopening")
+	}
+}
+
+func TestFile_ReopenClaims(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "identities.log")
+	emails := []store.Key{
+		store.ProvisionalKey("app", "email", "b@example.com"),
+		store.ProvisionalKey("app", "email", "a@example.com"),
+		store.ProvisionalKey("app", "email", "c@example.com"),
+	}
+
+	f := openFile(t, path)
+	for _, key := range emails {
+		if _, err := f.Claim(ctx, key, "alice"); err != nil {
+			t.Fatal("error claiming provisional identity:", err)
+		}
+	}
+	if _, err := f.Claim(ctx, store.ProvisionalKey("app", "email", "bob@example.com"), "bob"); err != nil {
+		t.Fatal("error claiming provisional identity:", err)
+	}
+	if err := f.Unclaim(ctx, "app", "bob"); err != nil {
+		t.Fatal("error removing claims:", err)
+	}
+	f.Close()
+
+	// claims must survive both the replay of the log and its compaction
+	for i := 0; i < 2; i++ {
+		f = openFile(t, path)
+		claimed, err := f.ClaimedBy(ctx, "app", "alice")
+		if err != nil || len(claimed) != len(emails) {
+			t.Fatal("claims lost after reopening")
+		}
+		for i := range emails {
+			if claimed[i] != emails[i] {
+				t.Fatalf("claims reordered after reopening: %v", claimed)
+			}
+		}
+		if claimed, err := f.ClaimedBy(ctx, "app", "bob"); err != nil || len(claimed) != 0 {
+			t.Fatal("removed claims came back after reopening")
+		}
+		if err := f.Compact(); err != nil {
+			t.Fatal("error compacting:", err)
+		}
+		f.Close()
+	}
+}
+
+func TestFile_LegacyRecords(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "identities.log")
+	legacy := `{"op":"put","key":{"app_id":"app","target":"email","value":"alice@example.com"},"identity":"alice"}` + "\n"
+	if err := os.WriteFile(path, []byte(legacy), 0o600); err != nil {
+		panic("error writing log")
+	}
+
+	before := time.Now()
+	f := openFile(t, path)
+	record, err := f.Get(ctx, store.ProvisionalKey("app", "email", "alice@example.com"))
+	if err != nil || record.Identity != "alice" {
+		t.Fatal("legacy record lost")
+	}
+	if record.CreatedAt.Before(before) || record.AccessedAt.Before(before) {
+		t.Fatal("legacy record timestamps not set when opening the store")
+	}
+	f.Close()
+
+	f = openFile(t, path)
+	reopened, err := f.Get(ctx, record.Key)
+	if err != nil || !reopened.CreatedAt.Equal(record.CreatedAt) {
+		t.Fatal("legacy record timestamps not persisted")
+	}
+}
+
+func TestFile_InterruptedWrite(t *testing.T) {
+	ctx := context.Background()
+
+	for _, tail := range []string{`{"op":"put","key":{"app_id":"app","tar`, "{garbage}\n"} {
+		path := filepath.Join(t.TempDir(), "identities.log")
+		f := openFile(t, path)
+		if err := f.Put(ctx, store.UserKey("app", "alice"), "alice"); err != nil {
+			t.Fatal("error putting identity:", err)
+		}
+		f.Close()
+
+		// simulate a crash in the middle of a write
+		file, err := os.OpenFile(path, os.O_APPEND|os.O_WRONLY, 0o600)
+		if err != nil {
+			panic("error opening log")
+		}
+		file.WriteString(tail) //nolint: errcheck
+		file.Close()
+
+		f = openFile(t, path)
+		if record, err := f.Get(ctx, store.UserKey("app", "alice")); err != nil || record.Identity != "alice" {
+			t.Fatal("record lost after interrupted write")
+		}
+		if err := f.Put(ctx, store.UserKey("app", "bob"), "bob"); err != nil {
+			t.Fatal("error putting identity after interrupted write:", err)
+		}
+		f.Close()
+
+		f = openFile(t, path)
+		if record, err := f.Get(ctx, store.UserKey("app", "bob")); err != nil || record.Identity != "bob" {
+			t.Fatal("record written after recovery lost")
+		}
+	}
+}
+
+func TestFile_Corrupted(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "identities.log")
+	content := "{garbage}\n" + `{"op":"put","key":{"app_id":"app","target":"user","value":"alice"},"identity":"alice"}` + "\n"
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		panic("error writing log")
+	}
+
+	if _, err := store.OpenFile(path); err == nil {
+		t.Fatal("no error opening corrupted store")
+	}
+}
+
+func TestFile_Locked(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "identities.log")
+	f := openFile(t, path)
+
+	if _, err := store.OpenFile(path); !errors.Is(err, store.ErrFileLocked) {
+		t.Fatal("expected ErrFileLocked, got", err)
+	}
+	if err := f.Compact(); err != nil {
+		t.Fatal("error compacting:", err)
+	}
+	if _, err := store.OpenFile(path); !errors.Is(err, store.ErrFileLocked) {
+		t.Fatal("expected ErrFileLocked after compaction, got", err)
+	}
+
+	f.Close()
+	openFile(t, path)
+}
+
+func TestFile_Compact(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "identities.log")
+	f := openFile(t, path)
+
+	for i := 0; i < 100; i++ {
+		if err := f.Put(ctx, store.UserKey("app", "alice"), fmt.Sprint(i)); err != nil {
+			t.Fatal("error putting identity:", err)
+		}
+	}
+	before, _ := os.Stat(path)
+	if err := f.Compact(); err != nil {
+		t.Fatal("error compacting:", err)
+	}
+	after, _ := os.Stat(path)
+	if after.Size() >= before.Size() {
+		t.Fatal("compaction did not shrink the log")
+	}
+
+	if err := f.Put(ctx, store.UserKey("app", "bob"), "bob"); err != nil {
+		t.Fatal("error putting identity after compaction:", err)
+	}
+	f.Close()
+
+	f = openFile(t, path)
+	if record, err := f.Get(ctx, store.UserKey("app", "alice")); err != nil || record.Identity != "99" {
+		t.Fatal("record lost after compaction")
+	}
+	if record, err := f.Get(ctx, store.UserKey("app", "bob")); err != nil || record.Identity != "bob" {
+		t.Fatal("record written after compaction lost")
+	}
+}
+
+func TestFile_AutoCompact(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "identities.log")
+	f := openFile(t, path)
+
+	for i := 0; i < 3000; i++ {
+		if err := f.Put(ctx, store.UserKey("app", "alice"), fmt.Sprint(i)); err != nil {
+			t.Fatal("error putting identity:", err)
+		}
+	}
+
+	info, _ := os.Stat(path)
+	// 3000 entries would take well over 500kB
+	if info.Size() > 300*1024 {
+		t.Fatal("log was not compacted automatically")
+	}
+}
+
+func TestFile_AutoCompactError(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "identities.log")
+	f := openFile(t, path)
+
+	// the temporary log of the compaction cannot be created
+	if err := os.Mkdir(path+".compact", 0o700); err != nil {
+		panic("error creating directory")
+	}
+	for i := 0; i < 3000; i++ {
+		if err := f.Put(ctx, store.UserKey("app", "alice"), fmt.Sprint(i)); err != nil {
+			t.Fatal("error putting identity when compaction fails:", err)
+		}
+	}
+	if err := f.Compact(); err == nil {
+		t.Fatal("no error compacting")
+	}
+	f.Close()
+
+	f = openFile(t, path)
+	if record, err := f.Get(ctx, store.UserKey("app", "alice")); err != nil || record.Identity != "2999" {
+		t.Fatal("record lost when compaction failed")
+	}
+}
@@ -0,0 +1,126 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"sync"
+	"time"
+
+	"github.com/TankerHQ/identity-go/v3"
+)
+
+// GetOrCreate returns the identity of userID stored in s, creating and
+// storing it first if needed.
+//
+// Concurrent calls for the same user are guaranteed to return the same
+// identity: calls within the process are deduplicated, and s.CompareAndSwap
+// makes sure that only the first identity created is ever stored, even
+// across processes sharing the same store.
+func GetOrCreate(ctx context.Context, s IdentityStore, config identity.Config, userID string) (string, error) {
+	key := UserKey(config.AppID, userID)
+	return getOrCreate(ctx, s, key, false, func() (*string, error) {
+		return identity.Create(config, userID)
+	})
+}
+
+// GetOrCreateWithIssuer is GetOrCreate for the app of issuer, which saves
+// decoding the app config every time an identity is created
+func GetOrCreateWithIssuer(ctx context.Context, s IdentityStore, issuer *identity.Issuer, userID string) (string, error) {
+	key := UserKey(issuer.AppID(), userID)
+	return getOrCreate(ctx, s, key, false, func() (*string, error) {
+		return issuer.Create(userID)
+	})
+}
+
+// getOrCreate returns the identity stored for key, or stores the one
+// returned by create. With touch, the AccessedAt of a stored record is
+// updated if it is older than AccessResolution.
+func getOrCreate(ctx context.Context, s IdentityStore, key Key, touch bool, create func() (*string, error)) (string, error) {
+	return inflight.do(ctx, s, key, func() (string, error) {
+		record, err := s.Get(ctx, key)
+		if err == nil {
+			if now := time.Now().UTC(); touch && now.Sub(record.AccessedAt) >= AccessResolution {
+				if err := s.Touch(ctx, key, now); err != nil && !errors.Is(err, ErrNotFound) {
+					return "", err
+				}
+			}
+			return record.Identity, nil
+		}
+		if !errors.Is(err, ErrNotFound) {
+			return "", err
+		}
+
+		created, err := create()
+		if err != nil {
+			return "", err
+		}
+		// if another process stored an identity in the meantime, ours is
+		// discarded and theirs returned
+		record, _, err = s.CompareAndSwap(ctx, key, "", *created)
+		if err != nil {
+			return "", err
+		}
+		return record.Identity, nil
+	})
+}
+
+var inflight = &callGroup{calls: map[callKey]*call{}}
+
+type callKey struct {
+	store IdentityStore
+	key   Key
+}
+
+type call struct {
+	done     chan struct{}
+	identity string
+	err      error
+}
+
+// callGroup deduplicates concurrent calls for the same key of the same
+// store
+type callGroup struct {
+	mu    sync.Mutex
+	calls map[callKey]*call
+}
+
+func (g *callGroup) do(ctx context.Context, s IdentityStore, key Key, fn func() (string, error)) (string, error) {
+	// stores that cannot be used as map keys are not deduplicated, which
+	// is safe since CompareAndSwap is what guarantees uniqueness
+	if !reflect.TypeOf(s).Comparable() {
+		return fn()
+	}
+
+	k := callKey{store: s, key: key}
+	g.mu.Lock()
+	if c, found := g.calls[k]; found {
+		g.mu.Unlock()
+		select {
+		case <-c.done:
+		case <-ctx.Done():
+			return "", ctx.Err()
+		}
+		if isContextError(c.err) && ctx.Err() == nil {
+			// the leader gave up because of its own context, try again
+			return g.do(ctx, s, key, fn)
+		}
+		return c.identity, c.err
+	}
+	c := &call{done: make(chan struct{})}
+	g.calls[k] = c
+	g.mu.Unlock()
+
+	c.identity, c.err = fn()
+
+	g.mu.Lock()
+	delete(g.calls, k)
+	g.mu.Unlock()
+	close(c.done)
+
+	return c.identity, c.err
+}
+
+func isContextError(err error) bool {
+	return errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded)
+}
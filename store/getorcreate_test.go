@@ -0,0 +1,184 @@
+package store_test
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"testing"
+
+	"github.com/TankerHQ/identity-go/v3"
+	"github.com/TankerHQ/identity-go/v3/store"
+	"github.com/TankerHQ/identity-go/v3/store/storetest"
+)
+
+// uncomparableStore cannot be deduplicated in-process, so that only
+// CompareAndSwap protects it
+type uncomparableStore struct {
+	store.IdentityStore
+	_ []int
+}
+
+// countingStore counts the identities actually stored
+type countingStore struct {
+	store.IdentityStore
+	swaps *int32
+}
+
+func (s countingStore) CompareAndSwap(ctx context.Context, key store.Key, old string, id string) (store.Record, bool, error) {
+	record, swapped, err := s.IdentityStore.CompareAndSwap(ctx, key, old, id)
+	if swapped {
+		atomic.AddInt32(s.swaps, 1)
+	}
+	return record, swapped, err
+}
+
+func hammerGetOrCreate(t *testing.T, s store.IdentityStore, config identity.Config) []string {
+	t.Helper()
+	const workers = 64
+
+	var wg sync.WaitGroup
+	ids := make([]string, workers)
+	errs := make([]error, workers)
+	start := make(chan struct{})
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			<-start
+			ids[w], errs[w] = store.GetOrCreate(context.Background(), s, config, "alice")
+		}(w)
+	}
+	close(start)
+	wg.Wait()
+
+	for w := range ids {
+		if errs[w] != nil {
+			t.Fatal("error in GetOrCreate:", errs[w])
+		}
+		if ids[w] != ids[0] {
+			t.Fatal("GetOrCreate returned different identities for the same user")
+		}
+	}
+	return ids
+}
+
+func TestGetOrCreate(t *testing.T) {
+	config := storetest.NewConfig()
+	s := store.NewMemory()
+
+	id, err := store.GetOrCreate(context.Background(), s, config, "alice")
+	if err != nil {
+		t.Fatal("error in GetOrCreate:", err)
+	}
+	if err := identity.VerifyIdentity(config, id, "alice"); err != nil {
+		t.Fatal("created identity is invalid:", err)
+	}
+
+	again, err := store.GetOrCreate(context.Background(), s, config, "alice")
+	if err != nil || again != id {
+		t.Fatal("GetOrCreate did not return the stored identity")
+	}
+
+	record, err := s.Get(context.Background(), store.UserKey(config.AppID, "alice"))
+	if err != nil || record.Identity != id {
+		t.Fatal("GetOrCreate did not store the identity")
+	}
+}
+
+func TestGetOrCreateWithIssuer(t *testing.T) {
+	config := storetest.NewConfig()
+	issuer, err := identity.NewIssuer(config)
+	if err != nil {
+		panic("error creating issuer")
+	}
+	s := store.NewMemory()
+
+	id, err := store.GetOrCreateWithIssuer(context.Background(), s, issuer, "alice")
+	if err != nil {
+		t.Fatal("error in GetOrCreateWithIssuer:", err)
+	}
+	if err := identity.VerifyIdentity(config, id, "alice"); err != nil {
+		t.Fatal("created identity is invalid:", err)
+	}
+	if again, err := store.GetOrCreate(context.Background(), s, config, "alice"); err != nil || again != id {
+		t.Fatal("GetOrCreate did not return the identity stored by GetOrCreateWithIssuer")
+	}
+}
+
+func TestGetOrCreate_Concurrent(t *testing.T) {
+	config := storetest.NewConfig()
+
+	backends := map[string]func(t *testing.T) store.IdentityStore{
+		"Memory": func(*testing.T) store.IdentityStore { return store.NewMemory() },
+		"File": func(t *testing.T) store.IdentityStore {
+			return openFile(t, filepath.Join(t.TempDir(), "identities.log"))
+		},
+	}
+
+	for name, newStore := range backends {
+		t.Run(name, func(t *testing.T) {
+			var swaps int32
+			s := countingStore{IdentityStore: newStore(t), swaps: &swaps}
+			ids := hammerGetOrCreate(t, s, config)
+
+			if swaps != 1 {
+				t.Fatalf("expected a single stored identity, got %d", swaps)
+			}
+			record, err := s.Get(context.Background(), store.UserKey(config.AppID, "alice"))
+			if err != nil || record.Identity != ids[0] {
+				t.Fatal("returned identity is not the stored one")
+			}
+		})
+
+		t.Run(name+"/WithoutDeduplication", func(t *testing.T) {
+			var swaps int32
+			s := uncomparableStore{IdentityStore: countingStore{IdentityStore: newStore(t), swaps: &swaps}}
+			ids := hammerGetOrCreate(t, s, config)
+
+			if swaps != 1 {
+				t.Fatalf("expected a single stored identity, got %d", swaps)
+			}
+			record, err := s.Get(context.Background(), store.UserKey(config.AppID, "alice"))
+			if err != nil || record.Identity != ids[0] {
+				t.Fatal("returned identity is not the stored one")
+			}
+		})
+	}
+}
+
+type failingGetStore struct {
+	*store.Memory
+}
+
+func (failingGetStore) Get(context.Context, store.Key) (store.Record, error) {
+	return store.Record{}, errors.New("database is down")
+}
+
+func TestGetOrCreate_Error(t *testing.T) {
+	config := storetest.NewConfig()
+
+	t.Run("InvalidConfig", func(t *testing.T) {
+		_, err := store.GetOrCreate(context.Background(), store.NewMemory(), identity.Config{}, "alice")
+		if !errors.Is(err, identity.ErrInvalidConfig) {
+			t.Fatal("expected ErrInvalidConfig, got", err)
+		}
+	})
+
+	t.Run("StoreFailure", func(t *testing.T) {
+		_, err := store.GetOrCreate(context.Background(), failingGetStore{store.NewMemory()}, config, "alice")
+		if err == nil {
+			t.Fatal("no error with failing store")
+		}
+	})
+
+	t.Run("CanceledContext", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+		_, err := store.GetOrCreate(ctx, store.NewMemory(), config, "alice")
+		if !errors.Is(err, context.Canceled) {
+			t.Fatal("expected context.Canceled, got", err)
+		}
+	})
+}
@@ -0,0 +1,155 @@
+package store
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// Memory is an IdentityStore and ClaimStore keeping records in memory,
+// typically for tests and development servers
+type Memory struct {
+	mu      sync.RWMutex
+	records map[Key]Record
+	claims  claimIndex
+}
+
+var _ ClaimStore = (*Memory)(nil)
+
+// NewMemory returns an empty Memory store
+func NewMemory() *Memory {
+	return &Memory{records: map[Key]Record{}, claims: newClaimIndex()}
+}
+
+// Get implements IdentityStore
+func (m *Memory) Get(ctx context.Context, key Key) (Record, error) {
+	if err := ctx.Err(); err != nil {
+		return Record{}, err
+	}
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	record, found := m.records[key]
+	if !found {
+		return Record{}, ErrNotFound
+	}
+	return record, nil
+}
+
+// Put implements IdentityStore
+func (m *Memory) Put(ctx context.Context, key Key, identity string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.records[key] = newRecord(key, identity)
+	return nil
+}
+
+// CompareAndSwap implements IdentityStore
+func (m *Memory) CompareAndSwap(ctx context.Context, key Key, old string, identity string) (Record, bool, error) {
+	if err := ctx.Err(); err != nil {
+		return Record{}, false, err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	current := m.records[key]
+	if current.Identity != old {
+		return current, false, nil
+	}
+	record := newRecord(key, identity)
+	m.records[key] = record
+	return record, true, nil
+}
+
+// Touch implements IdentityStore
+func (m *Memory) Touch(ctx context.Context, key Key, at time.Time) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	record, found := m.records[key]
+	if !found {
+		return ErrNotFound
+	}
+	record.AccessedAt = at
+	m.records[key] = record
+	return nil
+}
+
+// Delete implements IdentityStore
+func (m *Memory) Delete(ctx context.Context, key Key) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.records, key)
+	return nil
+}
+
+// Range implements IdentityStore
+func (m *Memory) Range(ctx context.Context, fn func(Record) error) error {
+	m.mu.RLock()
+	records := make([]Record, 0, len(m.records))
+	for _, record := range m.records {
+		records = append(records, record)
+	}
+	m.mu.RUnlock()
+
+	for _, record := range records {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if err := fn(record); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Claim implements ClaimStore
+func (m *Memory) Claim(ctx context.Context, key Key, userID string) (string, error) {
+	if err := ctx.Err(); err != nil {
+		return "", err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.claims.claim(key, userID), nil
+}
+
+// Claimant implements ClaimStore
+func (m *Memory) Claimant(ctx context.Context, key Key) (string, error) {
+	if err := ctx.Err(); err != nil {
+		return "", err
+	}
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	claimant, found := m.claims.claimants[key]
+	if !found {
+		return "", ErrNotFound
+	}
+	return claimant, nil
+}
+
+// ClaimedBy implements ClaimStore
+func (m *Memory) ClaimedBy(ctx context.Context, appID string, userID string) ([]Key, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return m.claims.claimedBy(appID, userID), nil
+}
+
+// Unclaim implements ClaimStore
+func (m *Memory) Unclaim(ctx context.Context, appID string, userID string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.claims.unclaim(appID, userID)
+	return nil
+}
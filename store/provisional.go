@@ -0,0 +1,90 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/TankerHQ/identity-go/v3"
+)
+
+// ErrInvalidValue is returned when a provisional identity value is not a
+// valid email address or phone number
+var ErrInvalidValue = errors.New("invalid provisional identity value")
+
+// GetOrCreateProvisional returns the provisional identity for target and
+// value stored in s, creating and storing it first if needed, along with
+// its public identity.
+//
+// value is normalized with NormalizeProvisionalValue first, so that every
+// sender sharing with the same email address or phone number gets the same
+// provisional identity, with the same guarantees as GetOrCreate. The
+// provisional identity holds the normalized value, which is the one the
+// recipient must verify to claim it. Delivering a stored identity updates
+// its AccessedAt, which RetentionPolicy.MaxIdle is based on.
+func GetOrCreateProvisional(ctx context.Context, s IdentityStore, config identity.Config, target string, value string) (secret string, public string, err error) {
+	return getOrCreateProvisional(ctx, s, config.AppID, target, value, func(normalized string) (*string, error) {
+		return identity.CreateProvisional(config, target, normalized)
+	})
+}
+
+// GetOrCreateProvisionalWithIssuer is GetOrCreateProvisional for the app
+// of issuer, which saves decoding the app config every time an identity
+// is created
+func GetOrCreateProvisionalWithIssuer(ctx context.Context, s IdentityStore, issuer *identity.Issuer, target string, value string) (secret string, public string, err error) {
+	return getOrCreateProvisional(ctx, s, issuer.AppID(), target, value, func(normalized string) (*string, error) {
+		return issuer.CreateProvisional(target, normalized)
+	})
+}
+
+// getOrCreateProvisional implements GetOrCreateProvisional, where create
+// returns a new provisional identity for the normalized value
+func getOrCreateProvisional(ctx context.Context, s IdentityStore, appID string, target string, value string, create func(normalized string) (*string, error)) (secret string, public string, err error) {
+	normalized, err := NormalizeProvisionalValue(target, value)
+	if err != nil {
+		return "", "", err
+	}
+
+	key := ProvisionalKey(appID, target, normalized)
+	secret, err = getOrCreate(ctx, s, key, true, func() (*string, error) {
+		return create(normalized)
+	})
+	if err != nil {
+		return "", "", err
+	}
+
+	publicIdentity, err := identity.GetPublicIdentity(secret)
+	if err != nil {
+		return "", "", err
+	}
+	return secret, *publicIdentity, nil
+}
+
+// NormalizeProvisionalValue returns the canonical form of a provisional
+// identity value: emails are trimmed and lowercased, phone numbers are
+// stripped of spaces and of the usual separators (".", "-", "(" and ")").
+func NormalizeProvisionalValue(target string, value string) (string, error) {
+	var normalized string
+	switch target {
+	case "email":
+		normalized = strings.ToLower(strings.TrimSpace(value))
+		if strings.Count(normalized, "@") != 1 || strings.HasPrefix(normalized, "@") || strings.HasSuffix(normalized, "@") {
+			return "", fmt.Errorf("%w: invalid email address", ErrInvalidValue)
+		}
+	case "phone_number":
+		normalized = strings.Map(func(r rune) rune {
+			switch r {
+			case ' ', '\t', '.', '-', '(', ')':
+				return -1
+			}
+			return r
+		}, value)
+		if normalized == "" || strings.Trim(normalized[1:], "0123456789") != "" || strings.Trim(normalized[:1], "+0123456789") != "" {
+			return "", fmt.Errorf("%w: invalid phone number", ErrInvalidValue)
+		}
+	default:
+		return "", identity.ErrUnsupportedTarget
+	}
+	return normalized, nil
+}
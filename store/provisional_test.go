@@ -0,0 +1,191 @@
+package store_test
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/TankerHQ/identity-go/v3"
+	"github.com/TankerHQ/identity-go/v3/store"
+	"github.com/TankerHQ/identity-go/v3/store/storetest"
+)
+
+func TestNormalizeProvisionalValue(t *testing.T) {
+	vectors := []struct {
+		target   string
+		value    string
+		expected string
+	}{
+		{target: "email", value: "alice@example.com", expected: "alice@example.com"},
+		{target: "email", value: "  Alice@Example.COM\n", expected: "alice@example.com"},
+		{target: "phone_number", value: "+33 6 12 34 56 78", expected: "+33612345678"},
+		{target: "phone_number", value: "(555) 123-4567", expected: "5551234567"},
+		{target: "phone_number", value: "+1.555.123.4567", expected: "+15551234567"},
+	}
+
+	for _, vec := range vectors {
+		normalized, err := store.NormalizeProvisionalValue(vec.target, vec.value)
+		if err != nil {
+			t.Fatalf("error normalizing %q: %v", vec.value, err)
+		}
+		if normalized != vec.expected {
+			t.Fatalf("expected %q, got %q", vec.expected, normalized)
+		}
+	}
+}
+
+func TestNormalizeProvisionalValue_Error(t *testing.T) {
+	vectors := []struct {
+		target   string
+		value    string
+		sentinel error
+	}{
+		{target: "fax", value: "alice", sentinel: identity.ErrUnsupportedTarget},
+		{target: "user", value: "alice", sentinel: identity.ErrUnsupportedTarget},
+		{target: "email", value: "alice", sentinel: store.ErrInvalidValue},
+		{target: "email", value: "@example.com", sentinel: store.ErrInvalidValue},
+		{target: "email", value: "a@b@example.com", sentinel: store.ErrInvalidValue},
+		{target: "phone_number", value: "", sentinel: store.ErrInvalidValue},
+		{target: "phone_number", value: "+33 6 12 AB", sentinel: store.ErrInvalidValue},
+		{target: "phone_number", value: "33+612", sentinel: store.ErrInvalidValue},
+	}
+
+	for _, vec := range vectors {
+		_, err := store.NormalizeProvisionalValue(vec.target, vec.value)
+		if !errors.Is(err, vec.sentinel) {
+			t.Fatalf("expected %v normalizing %q, got %v", vec.sentinel, vec.value, err)
+		}
+	}
+}
+
+func TestGetOrCreateProvisional(t *testing.T) {
+	ctx := context.Background()
+	config := storetest.NewConfig()
+	s := store.NewMemory()
+
+	secret, public, err := store.GetOrCreateProvisional(ctx, s, config, "email", "Bob@Example.com")
+	if err != nil {
+		t.Fatal("error in GetOrCreateProvisional:", err)
+	}
+	if err := identity.ValidateProvisionalIdentity(secret); err != nil {
+		t.Fatal("created provisional identity is invalid:", err)
+	}
+	expectedPublic, _ := identity.GetPublicIdentity(secret)
+	if public != *expectedPublic {
+		t.Fatal("wrong public identity")
+	}
+
+	parsed, _ := identity.ParseIdentity(secret)
+	if parsed.(*identity.SecretProvisionalIdentity).Value != "bob@example.com" {
+		t.Fatal("provisional identity does not hold the normalized value")
+	}
+
+	secret2, public2, err := store.GetOrCreateProvisional(ctx, s, config, "email", " bob@example.com ")
+	if err != nil {
+		t.Fatal("error in GetOrCreateProvisional:", err)
+	}
+	if secret2 != secret || public2 != public {
+		t.Fatal("different provisional identities for the same email")
+	}
+
+	record, err := s.Get(ctx, store.ProvisionalKey(config.AppID, "email", "bob@example.com"))
+	if err != nil || record.Identity != secret {
+		t.Fatal("provisional identity not stored under its normalized key")
+	}
+}
+
+func TestGetOrCreateProvisionalWithIssuer(t *testing.T) {
+	ctx := context.Background()
+	config := storetest.NewConfig()
+	issuer, err := identity.NewIssuer(config)
+	if err != nil {
+		panic("error creating issuer")
+	}
+	s := store.NewMemory()
+
+	secret, public, err := store.GetOrCreateProvisionalWithIssuer(ctx, s, issuer, "email", "Bob@Example.com")
+	if err != nil {
+		t.Fatal("error in GetOrCreateProvisionalWithIssuer:", err)
+	}
+	if err := identity.ValidateProvisionalIdentity(secret); err != nil {
+		t.Fatal("created provisional identity is invalid:", err)
+	}
+	secret2, public2, err := store.GetOrCreateProvisional(ctx, s, config, "email", "bob@example.com")
+	if err != nil || secret2 != secret || public2 != public {
+		t.Fatal("GetOrCreateProvisional did not return the identity stored by GetOrCreateProvisionalWithIssuer")
+	}
+}
+
+func TestGetOrCreateProvisional_AccessedAt(t *testing.T) {
+	ctx := context.Background()
+	config := storetest.NewConfig()
+	s := store.NewMemory()
+	key := store.ProvisionalKey(config.AppID, "email", "bob@example.com")
+
+	if _, _, err := store.GetOrCreateProvisional(ctx, s, config, "email", "bob@example.com"); err != nil {
+		t.Fatal("error in GetOrCreateProvisional:", err)
+	}
+	created, _ := s.Get(ctx, key)
+
+	// recent accesses are not recorded again
+	if _, _, err := store.GetOrCreateProvisional(ctx, s, config, "email", "bob@example.com"); err != nil {
+		t.Fatal("error in GetOrCreateProvisional:", err)
+	}
+	if record, _ := s.Get(ctx, key); !record.AccessedAt.Equal(created.AccessedAt) {
+		t.Fatal("AccessedAt updated before AccessResolution")
+	}
+
+	stale := time.Now().Add(-2 * store.AccessResolution)
+	if err := s.Touch(ctx, key, stale); err != nil {
+		panic("error touching record")
+	}
+	if _, _, err := store.GetOrCreateProvisional(ctx, s, config, "email", "bob@example.com"); err != nil {
+		t.Fatal("error in GetOrCreateProvisional:", err)
+	}
+	record, _ := s.Get(ctx, key)
+	if !record.AccessedAt.After(stale) || !record.CreatedAt.Equal(created.CreatedAt) {
+		t.Fatal("AccessedAt not updated on delivery")
+	}
+}
+
+func TestGetOrCreateProvisional_Concurrent(t *testing.T) {
+	config := storetest.NewConfig()
+	s := store.NewMemory()
+	values := []string{"bob@example.com", "BOB@example.com", " bob@EXAMPLE.com"}
+
+	const workers = 30
+	var wg sync.WaitGroup
+	secrets := make([]string, workers)
+	errs := make([]error, workers)
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			secrets[w], _, errs[w] = store.GetOrCreateProvisional(context.Background(), s, config, "email", values[w%len(values)])
+		}(w)
+	}
+	wg.Wait()
+
+	for w := range secrets {
+		if errs[w] != nil {
+			t.Fatal("error in GetOrCreateProvisional:", errs[w])
+		}
+		if secrets[w] != secrets[0] {
+			t.Fatal("different provisional identities for the same email")
+		}
+	}
+}
+
+func TestGetOrCreateProvisional_Error(t *testing.T) {
+	ctx := context.Background()
+	config := storetest.NewConfig()
+
+	if _, _, err := store.GetOrCreateProvisional(ctx, store.NewMemory(), config, "fax", "bob"); !errors.Is(err, identity.ErrUnsupportedTarget) {
+		t.Fatal("expected ErrUnsupportedTarget, got", err)
+	}
+	if _, _, err := store.GetOrCreateProvisional(ctx, store.NewMemory(), identity.Config{}, "email", "bob@example.com"); !errors.Is(err, identity.ErrInvalidConfig) {
+		t.Fatal("expected ErrInvalidConfig, got", err)
+	}
+}
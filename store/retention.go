@@ -0,0 +1,96 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+// AccessResolution is how stale the AccessedAt of a provisional record
+// must be before GetOrCreateProvisional updates it. It keeps deliveries
+// from writing to the store every time.
+const AccessResolution = time.Hour
+
+// RetentionPolicy tells when provisional records expire. Records whose
+// timestamps are unknown, that is zero, never expire.
+type RetentionPolicy struct {
+	// MaxAge is how long a provisional record is kept after its creation,
+	// or forever if zero
+	MaxAge time.Duration
+	// MaxIdle is how long a provisional record is kept after it was last
+	// delivered, or forever if zero
+	MaxIdle time.Duration
+	// Now returns the current time, time.Now if nil
+	Now func() time.Time
+}
+
+// Expired returns whether record is a provisional record expired under p
+func (p RetentionPolicy) Expired(record Record) bool {
+	if !record.Key.IsProvisional() {
+		return false
+	}
+	now := time.Now()
+	if p.Now != nil {
+		now = p.Now()
+	}
+	if p.MaxAge > 0 && !record.CreatedAt.IsZero() && now.Sub(record.CreatedAt) > p.MaxAge {
+		return true
+	}
+	if p.MaxIdle > 0 && !record.AccessedAt.IsZero() && now.Sub(record.AccessedAt) > p.MaxIdle {
+		return true
+	}
+	return false
+}
+
+// PurgeHook is called by PurgeExpiredProvisional before deleting record.
+// Returning an error keeps the record and stops the purge.
+type PurgeHook func(ctx context.Context, record Record) error
+
+// PurgeExpiredProvisional deletes the provisional records of s expired
+// under policy, and returns how many were deleted. It is meant to be run
+// periodically, for instance daily.
+//
+// Provisional identities claimed by a user, see MarkClaimed, are kept:
+// they are erased along with the user, see envelope.Store.Erase. Deleting
+// them alone would leave their claim behind, which would then apply to
+// the identity created next for the same target and value.
+//
+// Each record is read again right before being deleted, so that a record
+// delivered or replaced during the purge is kept. hooks are then called
+// in order, and may for instance notify whoever shared data with the
+// provisional identity, which becomes unreadable once it is deleted.
+func PurgeExpiredProvisional(ctx context.Context, s IdentityStore, policy RetentionPolicy, hooks ...PurgeHook) (int, error) {
+	purged := 0
+	err := s.Range(ctx, func(record Record) error {
+		if !policy.Expired(record) {
+			return nil
+		}
+		current, err := s.Get(ctx, record.Key)
+		if errors.Is(err, ErrNotFound) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if !policy.Expired(current) {
+			return nil
+		}
+		if _, err := Claimant(ctx, s, current.Key); err == nil {
+			return nil
+		} else if !errors.Is(err, ErrNotFound) {
+			return err
+		}
+
+		for _, hook := range hooks {
+			if err := hook(ctx, current); err != nil {
+				return err
+			}
+		}
+		if err := s.Delete(ctx, record.Key); err != nil {
+			return err
+		}
+		purged++
+		return nil
+	})
+	return purged, err
+}
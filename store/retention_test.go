@@ -0,0 +1,144 @@
+package store_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/TankerHQ/identity-go/v3/store"
+	"github.com/TankerHQ/identity-go/v3/store/storetest"
+)
+
+func TestRetentionPolicy_Expired(t *testing.T) {
+	now := time.Date(2030, time.January, 1, 0, 0, 0, 0, time.UTC)
+	policy := store.RetentionPolicy{
+		MaxAge:  90 * 24 * time.Hour,
+		MaxIdle: 30 * 24 * time.Hour,
+		Now:     func() time.Time { return now },
+	}
+	provisional := store.ProvisionalKey("app", "email", "alice@example.com")
+	daysAgo := func(days int) time.Time { return now.Add(-time.Duration(days) * 24 * time.Hour) }
+
+	vectors := []struct {
+		desc    string
+		record  store.Record
+		expired bool
+	}{
+		{desc: "Fresh", record: store.Record{Key: provisional, CreatedAt: daysAgo(1), AccessedAt: daysAgo(1)}},
+		{desc: "Old", record: store.Record{Key: provisional, CreatedAt: daysAgo(91), AccessedAt: daysAgo(1)}, expired: true},
+		{desc: "Idle", record: store.Record{Key: provisional, CreatedAt: daysAgo(31), AccessedAt: daysAgo(31)}, expired: true},
+		{desc: "Unknown", record: store.Record{Key: provisional}},
+		{desc: "DataKey", record: store.Record{Key: store.Key{AppID: "app", Target: "data_key", Value: "key"}, CreatedAt: daysAgo(365), AccessedAt: daysAgo(365)}},
+		{desc: "Permanent", record: store.Record{Key: store.UserKey("app", "alice"), CreatedAt: daysAgo(365), AccessedAt: daysAgo(365)}},
+	}
+	for _, vec := range vectors {
+		t.Run(vec.desc, func(t *testing.T) {
+			if policy.Expired(vec.record) != vec.expired {
+				t.Fatal("wrong expiry")
+			}
+		})
+	}
+
+	if (store.RetentionPolicy{}).Expired(vectors[1].record) {
+		t.Fatal("record expired under an empty policy")
+	}
+}
+
+func TestPurgeExpiredProvisional(t *testing.T) {
+	ctx := context.Background()
+	s := store.NewMemory()
+	alice := store.UserKey("app", "alice")
+	oldInvite := store.ProvisionalKey("app", "email", "old@example.com")
+	newInvite := store.ProvisionalKey("app", "email", "new@example.com")
+	for _, key := range []store.Key{alice, oldInvite, newInvite} {
+		if err := s.Put(ctx, key, key.Value); err != nil {
+			panic("error putting record")
+		}
+	}
+	// newInvite was delivered recently, after oldInvite
+	later := time.Now().Add(20 * 24 * time.Hour)
+	if err := s.Touch(ctx, newInvite, later); err != nil {
+		panic("error touching record")
+	}
+
+	policy := store.RetentionPolicy{
+		MaxIdle: 30 * 24 * time.Hour,
+		Now:     func() time.Time { return later.Add(15 * 24 * time.Hour) },
+	}
+	var notified []store.Key
+	purged, err := store.PurgeExpiredProvisional(ctx, s, policy, func(ctx context.Context, record store.Record) error {
+		if _, err := s.Get(ctx, record.Key); err != nil {
+			t.Fatal("hook called after deletion")
+		}
+		notified = append(notified, record.Key)
+		return nil
+	})
+	if err != nil || purged != 1 {
+		t.Fatalf("expected 1 record purged, got %d (%v)", purged, err)
+	}
+	if len(notified) != 1 || notified[0] != oldInvite {
+		t.Fatal("hook not called for the purged record")
+	}
+	if _, err := s.Get(ctx, oldInvite); !errors.Is(err, store.ErrNotFound) {
+		t.Fatal("expired record not purged")
+	}
+	for _, key := range []store.Key{alice, newInvite} {
+		if _, err := s.Get(ctx, key); err != nil {
+			t.Fatal("record purged before expiry")
+		}
+	}
+}
+
+func TestPurgeExpiredProvisional_HookError(t *testing.T) {
+	ctx := context.Background()
+	s := store.NewMemory()
+	key := store.ProvisionalKey("app", "email", "old@example.com")
+	if err := s.Put(ctx, key, "identity"); err != nil {
+		panic("error putting record")
+	}
+
+	hookErr := errors.New("notification failed")
+	policy := store.RetentionPolicy{
+		MaxAge: time.Hour,
+		Now:    func() time.Time { return time.Now().Add(2 * time.Hour) },
+	}
+	purged, err := store.PurgeExpiredProvisional(ctx, s, policy, func(context.Context, store.Record) error {
+		return hookErr
+	})
+	if !errors.Is(err, hookErr) || purged != 0 {
+		t.Fatal("hook error not returned")
+	}
+	if _, err := s.Get(ctx, key); err != nil {
+		t.Fatal("record purged despite hook error")
+	}
+}
+
+func TestPurgeExpiredProvisional_Claimed(t *testing.T) {
+	ctx := context.Background()
+	s := store.NewMemory()
+	config := storetest.NewConfig()
+	if _, _, err := store.GetOrCreateProvisional(ctx, s, config, "email", "bob@example.com"); err != nil {
+		t.Fatal("error creating provisional identity:", err)
+	}
+	if err := store.MarkClaimed(ctx, s, config.AppID, "email", "bob@example.com", "bob"); err != nil {
+		t.Fatal("error marking claim:", err)
+	}
+
+	policy := store.RetentionPolicy{
+		MaxAge: time.Hour,
+		Now:    func() time.Time { return time.Now().Add(2 * time.Hour) },
+	}
+	purged, err := store.PurgeExpiredProvisional(ctx, s, policy)
+	if err != nil || purged != 0 {
+		t.Fatalf("expected no record purged, got %d (%v)", purged, err)
+	}
+
+	key := store.ProvisionalKey(config.AppID, "email", "bob@example.com")
+	if _, err := s.Get(ctx, key); err != nil {
+		t.Fatal("claimed record purged")
+	}
+	if claimant, err := store.Claimant(ctx, s, key); err != nil || claimant != "bob" {
+		t.Fatal("claim lost during purge")
+	}
+}
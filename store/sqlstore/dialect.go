@@ -0,0 +1,43 @@
+package sqlstore
+
+import (
+	"strconv"
+	"strings"
+)
+
+// Dialect describes the few differences between SQL databases that matter
+// to Store. Queries are otherwise written in standard SQL.
+type Dialect struct {
+	// Placeholder returns the bind parameter for the n-th argument of a
+	// query, starting at 1
+	Placeholder func(n int) string
+	// caseInsensitiveText tells that text columns compare their values
+	// case- and accent-insensitively by default, as in MySQL, in which
+	// case Migrate converts the columns identifying records to a binary
+	// collation
+	caseInsensitiveText bool
+}
+
+var (
+	// Postgres is the dialect of PostgreSQL
+	Postgres = Dialect{Placeholder: func(n int) string { return "$" + strconv.Itoa(n) }}
+	// MySQL is the dialect of MySQL and MariaDB
+	MySQL = Dialect{Placeholder: func(int) string { return "?" }, caseInsensitiveText: true}
+	// SQLite is the dialect of SQLite
+	SQLite = Dialect{Placeholder: func(int) string { return "?" }}
+)
+
+// rebind replaces the "?" placeholders of query with the ones of d
+func (d Dialect) rebind(query string) string {
+	var b strings.Builder
+	n := 0
+	for _, r := range query {
+		if r == '?' {
+			n++
+			b.WriteString(d.Placeholder(n))
+			continue
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
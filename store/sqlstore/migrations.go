@@ -0,0 +1,302 @@
+package sqlstore
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+
+	"github.com/TankerHQ/identity-go/v3/store"
+)
+
+const migrationsTable = "tanker_identity_migrations"
+
+// migration is a schema change: its statements are executed, then fill,
+// if set, updates the existing records
+type migration struct {
+	statements []statement
+	fill       func(ctx context.Context, s *Store, tx *sql.Tx) error
+}
+
+// statement is a schema change of a migration. MySQL commits schema
+// changes immediately, even within a transaction, so a migration which
+// failed may have applied some of its statements: exists tells whether
+// the change was already made, or is not needed by the database, in which
+// case the statement is skipped.
+type statement struct {
+	query  string
+	exists func(ctx context.Context, s *Store) bool
+}
+
+// columnExists reports whether column exists in table, which is also how
+// the existence of a table is checked
+func columnExists(table string, column string) func(ctx context.Context, s *Store) bool {
+	return func(ctx context.Context, s *Store) bool {
+		rows, err := s.db.QueryContext(ctx, `SELECT `+column+` FROM `+table+` WHERE 1 = 0`)
+		if err != nil {
+			return false
+		}
+		rows.Close()
+		return true
+	}
+}
+
+// indexExists reports whether index exists on table. There is no
+// standard way to list indexes, so only databases providing
+// information_schema.statistics, such as MySQL, are queried. The others
+// support transactional schema changes, so the index cannot exist when
+// its migration was not recorded.
+func indexExists(table string, index string) func(ctx context.Context, s *Store) bool {
+	return func(ctx context.Context, s *Store) bool {
+		query := s.dialect.rebind(`SELECT index_name FROM information_schema.statistics WHERE table_schema = DATABASE() AND table_name = ? AND index_name = ?`)
+		rows, err := s.db.QueryContext(ctx, query, table, index)
+		if err != nil {
+			return false
+		}
+		defer rows.Close()
+		return rows.Next()
+	}
+}
+
+// binaryCollation is the MySQL collation comparing text byte by byte
+const binaryCollation = "utf8mb4_bin"
+
+// binaryColumn converts column of table, whose type is definition, to
+// binaryCollation on databases whose text comparisons are not binary, see
+// Dialect. MySQL compares text case- and accent-insensitively by default,
+// so that values differing by case would otherwise match the same records.
+// The conversion only ever runs on MySQL, and is written in its syntax.
+func binaryColumn(table string, column string, definition string) statement {
+	return statement{
+		query:  `ALTER TABLE ` + table + ` MODIFY ` + column + ` ` + definition + ` CHARACTER SET utf8mb4 COLLATE ` + binaryCollation,
+		exists: binaryCollated(table, column),
+	}
+}
+
+// binaryCollated reports whether column of table compares its values
+// byte by byte, which is always the case unless the dialect says
+// otherwise
+func binaryCollated(table string, column string) func(ctx context.Context, s *Store) bool {
+	return func(ctx context.Context, s *Store) bool {
+		if !s.dialect.caseInsensitiveText {
+			return true
+		}
+		query := s.dialect.rebind(`SELECT collation_name FROM information_schema.columns WHERE table_schema = DATABASE() AND table_name = ? AND column_name = ?`)
+		var collation sql.NullString
+		if err := s.db.QueryRowContext(ctx, query, table, column).Scan(&collation); err != nil {
+			return false
+		}
+		return collation.String == binaryCollation
+	}
+}
+
+// migrations are applied in order, each in its own transaction. The
+// version of a migration is its index in the slice plus one. Applied
+// migrations must never be changed, only new ones appended.
+var migrations = []migration{
+	{statements: []statement{
+		{
+			query: `CREATE TABLE tanker_identities (
+				app_id VARCHAR(64) NOT NULL,
+				target VARCHAR(32) NOT NULL,
+				target_value VARCHAR(255) NOT NULL,
+				secret_identity TEXT NOT NULL,
+				CONSTRAINT tanker_identities_pkey PRIMARY KEY (app_id, target, target_value)
+			)`,
+			exists: columnExists("tanker_identities", "app_id"),
+		},
+	}},
+	{
+		statements: []statement{
+			{
+				query:  `ALTER TABLE tanker_identities ADD COLUMN public_value VARCHAR(64)`,
+				exists: columnExists("tanker_identities", "public_value"),
+			},
+			{
+				query:  `CREATE INDEX tanker_identities_public_value ON tanker_identities (app_id, public_value)`,
+				exists: indexExists("tanker_identities", "tanker_identities_public_value"),
+			},
+		},
+		fill: fillPublicValues,
+	},
+	{
+		statements: []statement{
+			{
+				query:  `ALTER TABLE tanker_identities ADD COLUMN created_at BIGINT`,
+				exists: columnExists("tanker_identities", "created_at"),
+			},
+			{
+				query:  `ALTER TABLE tanker_identities ADD COLUMN accessed_at BIGINT`,
+				exists: columnExists("tanker_identities", "accessed_at"),
+			},
+		},
+		fill: fillTimestamps,
+	},
+	{statements: []statement{
+		{
+			query: `CREATE TABLE tanker_identity_claims (
+				app_id VARCHAR(64) NOT NULL,
+				target VARCHAR(32) NOT NULL,
+				target_value VARCHAR(255) NOT NULL,
+				user_id VARCHAR(255) NOT NULL,
+				claimed_at BIGINT NOT NULL,
+				CONSTRAINT tanker_identity_claims_pkey PRIMARY KEY (app_id, target, target_value)
+			)`,
+			exists: columnExists("tanker_identity_claims", "app_id"),
+		},
+		{
+			query:  `CREATE INDEX tanker_identity_claims_user_id ON tanker_identity_claims (app_id, user_id)`,
+			exists: indexExists("tanker_identity_claims", "tanker_identity_claims_user_id"),
+		},
+	}},
+	{statements: []statement{
+		binaryColumn("tanker_identities", "app_id", "VARCHAR(64) NOT NULL"),
+		binaryColumn("tanker_identities", "target", "VARCHAR(32) NOT NULL"),
+		binaryColumn("tanker_identities", "target_value", "VARCHAR(255) NOT NULL"),
+		binaryColumn("tanker_identities", "secret_identity", "TEXT NOT NULL"),
+		binaryColumn("tanker_identities", "public_value", "VARCHAR(64)"),
+		binaryColumn("tanker_identity_claims", "app_id", "VARCHAR(64) NOT NULL"),
+		binaryColumn("tanker_identity_claims", "target", "VARCHAR(32) NOT NULL"),
+		binaryColumn("tanker_identity_claims", "target_value", "VARCHAR(255) NOT NULL"),
+		binaryColumn("tanker_identity_claims", "user_id", "VARCHAR(255) NOT NULL"),
+	}},
+}
+
+// SchemaVersion is the schema version Migrate brings the database to
+var SchemaVersion = len(migrations)
+
+const (
+	createMigrationsTable = `CREATE TABLE IF NOT EXISTS ` + migrationsTable + ` (version INTEGER NOT NULL PRIMARY KEY)`
+	selectMigrations      = `SELECT version FROM ` + migrationsTable
+	insertMigration       = `INSERT INTO ` + migrationsTable + ` (version) VALUES (?)`
+)
+
+// Migrate creates or upgrades the tables used by s. It is safe to call
+// every time the application starts: migrations that were already applied
+// are skipped.
+//
+// Each migration is applied in a transaction. On databases with
+// transactional schema changes, such as PostgreSQL and SQLite, a failed
+// migration is rolled back entirely. MySQL commits schema changes
+// immediately, so a failed migration may be partially applied: calling
+// Migrate again skips the changes which were already made and completes
+// it. Concurrent calls to Migrate, for instance from several instances of
+// an application starting at once, may fail for the same reasons, in
+// which case Migrate should be called again.
+//
+// With the MySQL dialect, Migrate converts the columns holding keys,
+// identities and claimants to the utf8mb4_bin collation: with the default
+// collations, keys differing by case or accents would match the same
+// record, and a user could be given the identity of another one.
+func (s *Store) Migrate(ctx context.Context) error {
+	if _, err := s.db.ExecContext(ctx, createMigrationsTable); err != nil {
+		return fmt.Errorf("unable to create migrations table: %w", err)
+	}
+
+	applied, err := s.appliedMigrations(ctx)
+	if err != nil {
+		return err
+	}
+
+	for i, m := range migrations {
+		version := i + 1
+		if applied[version] {
+			continue
+		}
+		if err := s.applyMigration(ctx, version, m); err != nil {
+			return fmt.Errorf("unable to apply migration %d: %w", version, err)
+		}
+	}
+	return nil
+}
+
+func (s *Store) appliedMigrations(ctx context.Context) (map[int]bool, error) {
+	rows, err := s.db.QueryContext(ctx, selectMigrations)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	applied := map[int]bool{}
+	for rows.Next() {
+		var version int
+		if err := rows.Scan(&version); err != nil {
+			return nil, err
+		}
+		applied[version] = true
+	}
+	return applied, rows.Err()
+}
+
+func (s *Store) applyMigration(ctx context.Context, version int, m migration) error {
+	// changes left by a previous run are detected before the transaction
+	// begins, since a failed query aborts transactions on some databases
+	pending := make([]statement, 0, len(m.statements))
+	for _, statement := range m.statements {
+		if !statement.exists(ctx, s) {
+			pending = append(pending, statement)
+		}
+	}
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback() //nolint: errcheck
+
+	for _, statement := range pending {
+		if _, err := tx.ExecContext(ctx, statement.query); err != nil {
+			return err
+		}
+	}
+	if m.fill != nil {
+		if err := m.fill(ctx, s, tx); err != nil {
+			return err
+		}
+	}
+	// a concurrent Migrate which recorded the same version first makes
+	// this insert fail on the primary key
+	if _, err := tx.ExecContext(ctx, s.dialect.rebind(insertMigration), version); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// fillPublicValues sets the public value of the records stored before the
+// column was added
+func fillPublicValues(ctx context.Context, s *Store, tx *sql.Tx) error {
+	rows, err := tx.QueryContext(ctx, `SELECT app_id, target, target_value, secret_identity FROM tanker_identities`)
+	if err != nil {
+		return err
+	}
+	var records []store.Record
+	for rows.Next() {
+		var record store.Record
+		if err := rows.Scan(&record.Key.AppID, &record.Key.Target, &record.Key.Value, &record.Identity); err != nil {
+			rows.Close()
+			return err
+		}
+		records = append(records, record)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	update := s.dialect.rebind(`UPDATE tanker_identities SET public_value = ? WHERE app_id = ? AND target = ? AND target_value = ?`)
+	for _, record := range records {
+		key := record.Key
+		if _, err := tx.ExecContext(ctx, update, publicValueOf(key, record.Identity), key.AppID, key.Target, key.Value); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// fillTimestamps sets the timestamps of the records stored before they
+// were tracked to the current time, so that their retention starts now
+func fillTimestamps(ctx context.Context, s *Store, tx *sql.Tx) error {
+	now := time.Now().UnixMilli()
+	_, err := tx.ExecContext(ctx, s.dialect.rebind(`UPDATE tanker_identities SET created_at = ?, accessed_at = ?`), now, now)
+	return err
+}
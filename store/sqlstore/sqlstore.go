@@ -0,0 +1,373 @@
+// Package sqlstore implements store.IdentityStore on top of database/sql.
+//
+// Queries are written in standard SQL and only differ between databases
+// by their placeholders, described by a Dialect, so any driver can be
+// used. Call Store.Migrate when the application starts to create or
+// upgrade the tables.
+//
+// Records are kept in the tanker_identities table, where the primary key
+// on (app_id, target, target_value) guarantees a single identity per user
+// of an app, and per provisional target and value. Next to each record,
+// the public_value column holds the value of the matching public
+// identity, a hash of the user ID or email, so that a record can be found
+// from a public identity with LookupPublicIdentity.
+//
+// Claims, see store.MarkClaimed, are kept in the tanker_identity_claims
+// table, whose primary key on (app_id, target, target_value) guarantees
+// a single claimant per provisional identity.
+//
+// Keys are compared byte by byte, including on MySQL whose default
+// collations ignore case and accents, see Migrate. User IDs, email
+// addresses and phone numbers are limited to MaxValueLength characters.
+package sqlstore
+
+import (
+	"context"
+	"database/sql"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+	"unicode/utf8"
+
+	"github.com/TankerHQ/identity-go/v3"
+	"github.com/TankerHQ/identity-go/v3/store"
+)
+
+const (
+	selectIdentity = `SELECT secret_identity, created_at, accessed_at FROM tanker_identities WHERE app_id = ? AND target = ? AND target_value = ?`
+	insertIdentity = `INSERT INTO tanker_identities (app_id, target, target_value, public_value, secret_identity, created_at, accessed_at) VALUES (?, ?, ?, ?, ?, ?, ?)`
+	updateIdentity = `UPDATE tanker_identities SET public_value = ?, secret_identity = ?, created_at = ?, accessed_at = ? WHERE app_id = ? AND target = ? AND target_value = ?`
+	swapIdentity   = `UPDATE tanker_identities SET public_value = ?, secret_identity = ?, created_at = ?, accessed_at = ? WHERE app_id = ? AND target = ? AND target_value = ? AND secret_identity = ?`
+	touchIdentity  = `UPDATE tanker_identities SET accessed_at = ? WHERE app_id = ? AND target = ? AND target_value = ?`
+	deleteIdentity = `DELETE FROM tanker_identities WHERE app_id = ? AND target = ? AND target_value = ?`
+	selectAll      = `SELECT app_id, target, target_value, secret_identity, created_at, accessed_at FROM tanker_identities`
+	selectByPublic = `SELECT app_id, target, target_value, secret_identity, created_at, accessed_at FROM tanker_identities WHERE app_id = ? AND public_value = ?`
+
+	insertClaim    = `INSERT INTO tanker_identity_claims (app_id, target, target_value, user_id, claimed_at) VALUES (?, ?, ?, ?, ?)`
+	selectClaimant = `SELECT user_id FROM tanker_identity_claims WHERE app_id = ? AND target = ? AND target_value = ?`
+	selectClaimed  = `SELECT target, target_value, claimed_at FROM tanker_identity_claims WHERE app_id = ? AND user_id = ?`
+	deleteClaims   = `DELETE FROM tanker_identity_claims WHERE app_id = ? AND user_id = ?`
+)
+
+// MaxValueLength is the length, in characters, of the longest key value
+// and claimant user ID the tables can hold
+const MaxValueLength = 255
+
+// ErrValueTooLong is returned when storing a record or a claim whose key
+// value or user ID is longer than MaxValueLength, which some databases
+// would otherwise truncate
+var ErrValueTooLong = errors.New("value too long to be stored")
+
+func checkLength(value string) error {
+	if n := utf8.RuneCountInString(value); n > MaxValueLength {
+		return fmt.Errorf("%w: %d characters, at most %d", ErrValueTooLong, n, MaxValueLength)
+	}
+	return nil
+}
+
+// Store is a store.IdentityStore and store.ClaimStore keeping records in
+// a SQL database. Timestamps are stored as milliseconds since the Unix
+// epoch.
+type Store struct {
+	db      *sql.DB
+	dialect Dialect
+	queries map[string]string
+}
+
+var (
+	_ store.IdentityStore = (*Store)(nil)
+	_ store.ClaimStore    = (*Store)(nil)
+)
+
+// New returns a Store using db, whose queries are written for dialect.
+// The schema is not checked: call Migrate before using the store.
+func New(db *sql.DB, dialect Dialect) *Store {
+	s := &Store{db: db, dialect: dialect, queries: map[string]string{}}
+	for _, query := range []string{selectIdentity, insertIdentity, updateIdentity, swapIdentity, touchIdentity, deleteIdentity, selectAll, selectByPublic, insertClaim, selectClaimant, selectClaimed, deleteClaims} {
+		s.queries[query] = dialect.rebind(query)
+	}
+	return s
+}
+
+// Get implements store.IdentityStore
+func (s *Store) Get(ctx context.Context, key store.Key) (store.Record, error) {
+	record := store.Record{Key: key}
+	var createdAt, accessedAt sql.NullInt64
+	err := s.db.QueryRowContext(ctx, s.queries[selectIdentity], key.AppID, key.Target, key.Value).Scan(&record.Identity, &createdAt, &accessedAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return store.Record{}, store.ErrNotFound
+	}
+	if err != nil {
+		return store.Record{}, err
+	}
+	record.CreatedAt, record.AccessedAt = fromMillis(createdAt), fromMillis(accessedAt)
+	return record, nil
+}
+
+// Put implements store.IdentityStore
+func (s *Store) Put(ctx context.Context, key store.Key, secretIdentity string) error {
+	if err := checkLength(key.Value); err != nil {
+		return err
+	}
+	publicValue := publicValueOf(key, secretIdentity)
+	now := time.Now().UnixMilli()
+	update := func() (bool, error) {
+		result, err := s.db.ExecContext(ctx, s.queries[updateIdentity], publicValue, secretIdentity, now, now, key.AppID, key.Target, key.Value)
+		if err != nil {
+			return false, err
+		}
+		updated, err := result.RowsAffected()
+		return updated > 0, err
+	}
+
+	// there is no portable upsert, so update then insert; should a
+	// concurrent Put insert the record first, ours fails on the primary
+	// key and the update is retried
+	if updated, err := update(); err != nil || updated {
+		return err
+	}
+	_, insertErr := s.db.ExecContext(ctx, s.queries[insertIdentity], key.AppID, key.Target, key.Value, publicValue, secretIdentity, now, now)
+	if insertErr == nil {
+		return nil
+	}
+	if updated, err := update(); err != nil || updated {
+		return err
+	}
+	// some databases do not count rows updated with the values they
+	// already held
+	if _, err := s.Get(ctx, key); err == nil {
+		return nil
+	}
+	return insertErr
+}
+
+// CompareAndSwap implements store.IdentityStore
+func (s *Store) CompareAndSwap(ctx context.Context, key store.Key, old string, secretIdentity string) (store.Record, bool, error) {
+	if err := checkLength(key.Value); err != nil {
+		return store.Record{}, false, err
+	}
+	publicValue := publicValueOf(key, secretIdentity)
+	now := time.Now().UTC().Truncate(time.Millisecond)
+	record := store.Record{Key: key, Identity: secretIdentity, CreatedAt: now, AccessedAt: now}
+
+	if old == "" {
+		// the primary key makes the insert fail if a record was stored,
+		// in which case the current record is returned
+		_, insertErr := s.db.ExecContext(ctx, s.queries[insertIdentity], key.AppID, key.Target, key.Value, publicValue, secretIdentity, now.UnixMilli(), now.UnixMilli())
+		if insertErr == nil {
+			return record, true, nil
+		}
+		current, err := s.Get(ctx, key)
+		if errors.Is(err, store.ErrNotFound) {
+			return store.Record{}, false, insertErr
+		}
+		return current, false, err
+	}
+
+	result, err := s.db.ExecContext(ctx, s.queries[swapIdentity], publicValue, secretIdentity, now.UnixMilli(), now.UnixMilli(), key.AppID, key.Target, key.Value, old)
+	if err != nil {
+		return store.Record{}, false, err
+	}
+	swapped, err := result.RowsAffected()
+	if err != nil {
+		return store.Record{}, false, err
+	}
+	if swapped > 0 {
+		return record, true, nil
+	}
+	current, err := s.Get(ctx, key)
+	if errors.Is(err, store.ErrNotFound) {
+		return store.Record{}, false, nil
+	}
+	if err == nil && current.Identity == old && old == secretIdentity && current.CreatedAt.Equal(now) {
+		// the update matched but did not change the row
+		return current, true, nil
+	}
+	return current, false, err
+}
+
+// Touch implements store.IdentityStore
+func (s *Store) Touch(ctx context.Context, key store.Key, at time.Time) error {
+	result, err := s.db.ExecContext(ctx, s.queries[touchIdentity], at.UnixMilli(), key.AppID, key.Target, key.Value)
+	if err != nil {
+		return err
+	}
+	touched, err := result.RowsAffected()
+	if err != nil || touched > 0 {
+		return err
+	}
+	// the record may already have been accessed at the same time
+	_, err = s.Get(ctx, key)
+	return err
+}
+
+// Delete implements store.IdentityStore
+func (s *Store) Delete(ctx context.Context, key store.Key) error {
+	_, err := s.db.ExecContext(ctx, s.queries[deleteIdentity], key.AppID, key.Target, key.Value)
+	return err
+}
+
+// Range implements store.IdentityStore. All the records are read before
+// fn is first called, so that fn can use the store even when the database
+// only allows a single connection.
+func (s *Store) Range(ctx context.Context, fn func(store.Record) error) error {
+	records, err := s.query(ctx, s.queries[selectAll])
+	if err != nil {
+		return err
+	}
+	for _, record := range records {
+		if err := fn(record); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Claim implements store.ClaimStore
+func (s *Store) Claim(ctx context.Context, key store.Key, userID string) (string, error) {
+	if err := checkLength(key.Value); err != nil {
+		return "", err
+	}
+	if err := checkLength(userID); err != nil {
+		return "", err
+	}
+	// the primary key makes the insert fail if key was claimed, in which
+	// case the current claimant is returned
+	_, insertErr := s.db.ExecContext(ctx, s.queries[insertClaim], key.AppID, key.Target, key.Value, userID, time.Now().UnixMilli())
+	if insertErr == nil {
+		return userID, nil
+	}
+	claimant, err := s.Claimant(ctx, key)
+	if errors.Is(err, store.ErrNotFound) {
+		return "", insertErr
+	}
+	return claimant, err
+}
+
+// Claimant implements store.ClaimStore
+func (s *Store) Claimant(ctx context.Context, key store.Key) (string, error) {
+	var claimant string
+	err := s.db.QueryRowContext(ctx, s.queries[selectClaimant], key.AppID, key.Target, key.Value).Scan(&claimant)
+	if errors.Is(err, sql.ErrNoRows) {
+		return "", store.ErrNotFound
+	}
+	return claimant, err
+}
+
+// ClaimedBy implements store.ClaimStore. Claims made within the same
+// millisecond are returned in the order the database lists them.
+func (s *Store) ClaimedBy(ctx context.Context, appID string, userID string) ([]store.Key, error) {
+	rows, err := s.db.QueryContext(ctx, s.queries[selectClaimed], appID, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	type claim struct {
+		key       store.Key
+		claimedAt int64
+	}
+	var claims []claim
+	for rows.Next() {
+		c := claim{key: store.Key{AppID: appID}}
+		if err := rows.Scan(&c.key.Target, &c.key.Value, &c.claimedAt); err != nil {
+			return nil, err
+		}
+		claims = append(claims, c)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("unable to read claims: %w", err)
+	}
+
+	sort.SliceStable(claims, func(i, j int) bool { return claims[i].claimedAt < claims[j].claimedAt })
+	keys := make([]store.Key, len(claims))
+	for i, c := range claims {
+		keys[i] = c.key
+	}
+	return keys, nil
+}
+
+// Unclaim implements store.ClaimStore
+func (s *Store) Unclaim(ctx context.Context, appID string, userID string) error {
+	_, err := s.db.ExecContext(ctx, s.queries[deleteClaims], appID, userID)
+	return err
+}
+
+// LookupPublicIdentity returns the record whose public identity is
+// b64PublicIdentity, or store.ErrNotFound.
+//
+// Permanent identities and email provisional identities can always be
+// found. Phone number provisional identities can only be found when their
+// record holds the secret identity in clear, since their public value is
+// derived from the secret identity.
+func (s *Store) LookupPublicIdentity(ctx context.Context, b64PublicIdentity string) (store.Record, error) {
+	parsed, err := identity.ParsePublicIdentity(b64PublicIdentity)
+	if err != nil {
+		return store.Record{}, err
+	}
+	trustchainID := base64.StdEncoding.EncodeToString(parsed.GetTrustchainID())
+	records, err := s.query(ctx, s.queries[selectByPublic], trustchainID, parsed.GetValue())
+	if err != nil {
+		return store.Record{}, err
+	}
+	if len(records) == 0 {
+		return store.Record{}, store.ErrNotFound
+	}
+	return records[0], nil
+}
+
+func (s *Store) query(ctx context.Context, query string, args ...interface{}) ([]store.Record, error) {
+	rows, err := s.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var records []store.Record
+	for rows.Next() {
+		var record store.Record
+		var createdAt, accessedAt sql.NullInt64
+		if err := rows.Scan(&record.Key.AppID, &record.Key.Target, &record.Key.Value, &record.Identity, &createdAt, &accessedAt); err != nil {
+			return nil, err
+		}
+		record.CreatedAt, record.AccessedAt = fromMillis(createdAt), fromMillis(accessedAt)
+		records = append(records, record)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("unable to read identities: %w", err)
+	}
+	return records, nil
+}
+
+// publicValueOf returns the value of the public identity matching key, or
+// nil when it cannot be computed
+func publicValueOf(key store.Key, secretIdentity string) interface{} {
+	var public *string
+	var err error
+	switch key.Target {
+	case store.UserTarget:
+		public, err = identity.PublicIdentityFromUserID(key.AppID, key.Value)
+	case "email":
+		return identity.HashedEmail(key.Value)
+	default:
+		public, err = identity.GetPublicIdentity(secretIdentity)
+	}
+	if err != nil {
+		return nil
+	}
+
+	parsed, err := identity.ParsePublicIdentity(*public)
+	if err != nil {
+		return nil
+	}
+	return parsed.GetValue()
+}
+
+func fromMillis(millis sql.NullInt64) time.Time {
+	if !millis.Valid {
+		return time.Time{}
+	}
+	return time.UnixMilli(millis.Int64).UTC()
+}
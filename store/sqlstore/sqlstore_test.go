@@ -0,0 +1,342 @@
+package sqlstore_test
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"github.com/TankerHQ/identity-go/v3"
+	"github.com/TankerHQ/identity-go/v3/store"
+	"github.com/TankerHQ/identity-go/v3/store/sqlstore"
+	"github.com/TankerHQ/identity-go/v3/store/storetest"
+)
+
+var dbCount atomic.Int64
+
+// openDB returns a handle on a new, empty stub database
+func openDB(t *testing.T) *sql.DB {
+	db, err := sql.Open(stubDriverName, fmt.Sprintf("%s-%d", t.Name(), dbCount.Add(1)))
+	if err != nil {
+		panic("error opening stub database")
+	}
+	t.Cleanup(func() { db.Close() })
+	return db
+}
+
+// openMySQL returns a handle on a new, empty stub database behaving like
+// MySQL, see stubDriver.mysql
+func openMySQL(t *testing.T, fail *regexp.Regexp) (*sql.DB, *stubDB) {
+	name := fmt.Sprintf("%s-%d", t.Name(), dbCount.Add(1))
+	stubDB := stub.mysql(name, fail)
+	db, err := sql.Open(stubDriverName, name)
+	if err != nil {
+		panic("error opening stub database")
+	}
+	t.Cleanup(func() { db.Close() })
+	return db, stubDB
+}
+
+func newStore(t *testing.T, dialect sqlstore.Dialect) *sqlstore.Store {
+	s := sqlstore.New(openDB(t), dialect)
+	if err := s.Migrate(context.Background()); err != nil {
+		t.Fatal("error migrating database:", err)
+	}
+	return s
+}
+
+func TestStore(t *testing.T) {
+	storetest.Run(t, func(t *testing.T) store.IdentityStore {
+		return newStore(t, sqlstore.SQLite)
+	})
+}
+
+func TestStore_Postgres(t *testing.T) {
+	storetest.Run(t, func(t *testing.T) store.IdentityStore {
+		return newStore(t, sqlstore.Postgres)
+	})
+}
+
+func TestStore_MySQL(t *testing.T) {
+	storetest.Run(t, func(t *testing.T) store.IdentityStore {
+		db, _ := openMySQL(t, nil)
+		s := sqlstore.New(db, sqlstore.MySQL)
+		if err := s.Migrate(context.Background()); err != nil {
+			t.Fatal("error migrating database:", err)
+		}
+		return s
+	})
+}
+
+func migrationVersions(t *testing.T, db *sql.DB) []int {
+	rows, err := db.Query("SELECT version FROM tanker_identity_migrations")
+	if err != nil {
+		t.Fatal("error reading migrations:", err)
+	}
+	defer rows.Close()
+	var versions []int
+	for rows.Next() {
+		var version int
+		if err := rows.Scan(&version); err != nil {
+			t.Fatal("error reading migrations:", err)
+		}
+		versions = append(versions, version)
+	}
+	return versions
+}
+
+func TestMigrate(t *testing.T) {
+	ctx := context.Background()
+	db := openDB(t)
+	s := sqlstore.New(db, sqlstore.SQLite)
+
+	if _, err := s.Get(ctx, store.UserKey("app", "alice")); err == nil {
+		t.Fatal("no error using the store before migrating")
+	}
+	for i := 0; i < 2; i++ {
+		if err := s.Migrate(ctx); err != nil {
+			t.Fatal("error migrating database:", err)
+		}
+	}
+
+	versions := migrationVersions(t, db)
+	if len(versions) != sqlstore.SchemaVersion {
+		t.Fatalf("expected %d migrations, got %v", sqlstore.SchemaVersion, versions)
+	}
+	for i, version := range versions {
+		if version != i+1 {
+			t.Fatalf("migrations applied out of order: %v", versions)
+		}
+	}
+}
+
+func TestMigrate_Upgrade(t *testing.T) {
+	ctx := context.Background()
+	db := openDB(t)
+	config := storetest.NewConfig()
+	id, err := identity.Create(config, "alice")
+	if err != nil {
+		panic("error creating identity")
+	}
+
+	// the schema of version 1, with a record stored before public values
+	// were tracked
+	for _, statement := range []struct {
+		query string
+		args  []interface{}
+	}{
+		{query: `CREATE TABLE tanker_identity_migrations (version INTEGER NOT NULL PRIMARY KEY)`},
+		{query: `INSERT INTO tanker_identity_migrations (version) VALUES (?)`, args: []interface{}{1}},
+		{query: `CREATE TABLE tanker_identities (
+			app_id VARCHAR(64) NOT NULL,
+			target VARCHAR(32) NOT NULL,
+			target_value VARCHAR(255) NOT NULL,
+			secret_identity TEXT NOT NULL,
+			CONSTRAINT tanker_identities_pkey PRIMARY KEY (app_id, target, target_value)
+		)`},
+		{
+			query: `INSERT INTO tanker_identities (app_id, target, target_value, secret_identity) VALUES (?, ?, ?, ?)`,
+			args:  []interface{}{config.AppID, "user", "alice", *id},
+		},
+	} {
+		if _, err := db.Exec(statement.query, statement.args...); err != nil {
+			panic("error creating version 1 database")
+		}
+	}
+
+	s := sqlstore.New(db, sqlstore.SQLite)
+	if err := s.Migrate(ctx); err != nil {
+		t.Fatal("error migrating database:", err)
+	}
+	if versions := migrationVersions(t, db); len(versions) != sqlstore.SchemaVersion {
+		t.Fatalf("expected %d migrations, got %v", sqlstore.SchemaVersion, versions)
+	}
+	record, err := s.Get(ctx, store.UserKey(config.AppID, "alice"))
+	if err != nil || record.Identity != *id {
+		t.Fatal("record lost during migration")
+	}
+	if record.CreatedAt.IsZero() || record.AccessedAt.IsZero() {
+		t.Fatal("timestamps not filled during migration")
+	}
+
+	pub, err := identity.GetPublicIdentity(*id)
+	if err != nil {
+		panic("error getting public identity")
+	}
+	if _, err := s.LookupPublicIdentity(ctx, *pub); err != nil {
+		t.Fatal("public value not filled during migration:", err)
+	}
+}
+
+func TestMigrate_MySQLFailure(t *testing.T) {
+	ctx := context.Background()
+
+	// the fills of the migrations adding public values and timestamps,
+	// and the last column converted to a binary collation
+	for _, fail := range []string{
+		`^SELECT app_id, target, target_value, secret_identity FROM`,
+		`^UPDATE tanker_identities SET created_at`,
+		`^ALTER TABLE tanker_identity_claims MODIFY user_id`,
+	} {
+		db, stubDB := openMySQL(t, regexp.MustCompile(fail))
+		s := sqlstore.New(db, sqlstore.MySQL)
+		if err := s.Migrate(ctx); err == nil {
+			t.Fatal("no error migrating database when a migration fails")
+		}
+		if versions := migrationVersions(t, db); len(versions) == sqlstore.SchemaVersion {
+			t.Fatal("failed migration recorded")
+		}
+
+		// the schema changes of the failed migration were committed
+		stubDB.setFail(nil)
+		if err := s.Migrate(ctx); err != nil {
+			t.Fatal("error migrating database again after a failed migration:", err)
+		}
+		if versions := migrationVersions(t, db); len(versions) != sqlstore.SchemaVersion {
+			t.Fatalf("expected %d migrations, got %v", sqlstore.SchemaVersion, versions)
+		}
+		if err := s.Put(ctx, store.UserKey("app", "alice"), "alice"); err != nil {
+			t.Fatal("error putting identity after migrating again:", err)
+		}
+	}
+}
+
+func TestStore_UniqueUser(t *testing.T) {
+	ctx := context.Background()
+	db := openDB(t)
+	s := sqlstore.New(db, sqlstore.SQLite)
+	if err := s.Migrate(ctx); err != nil {
+		t.Fatal("error migrating database:", err)
+	}
+
+	key := store.UserKey("app", "alice")
+	if err := s.Put(ctx, key, "first"); err != nil {
+		t.Fatal("error putting identity:", err)
+	}
+	_, err := db.Exec(`INSERT INTO tanker_identities (app_id, target, target_value, secret_identity) VALUES (?, ?, ?, ?)`, "app", "user", "alice", "second")
+	if err == nil {
+		t.Fatal("no error inserting a second identity for a user")
+	}
+}
+
+func TestStore_MySQLCase(t *testing.T) {
+	ctx := context.Background()
+	db, _ := openMySQL(t, nil)
+	s := sqlstore.New(db, sqlstore.MySQL)
+	if err := s.Migrate(ctx); err != nil {
+		t.Fatal("error migrating database:", err)
+	}
+	config := storetest.NewConfig()
+
+	upper, err := store.GetOrCreate(ctx, s, config, "Alice")
+	if err != nil {
+		t.Fatal("error creating identity:", err)
+	}
+	lower, err := store.GetOrCreate(ctx, s, config, "alice")
+	if err != nil {
+		t.Fatal("error creating identity:", err)
+	}
+	if upper == lower {
+		t.Fatal("users differing by case share an identity")
+	}
+	record, err := s.Get(ctx, store.UserKey(config.AppID, "Alice"))
+	if err != nil || record.Identity != upper {
+		t.Fatal("wrong identity for a user differing by case")
+	}
+
+	key := store.UserKey("app", "bob")
+	if err := s.Put(ctx, key, "identity"); err != nil {
+		t.Fatal("error putting identity:", err)
+	}
+	if _, swapped, err := s.CompareAndSwap(ctx, key, "IDENTITY", "other"); err != nil || swapped {
+		t.Fatal("identity swapped for an old value differing by case")
+	}
+
+	email := store.ProvisionalKey("app", "email", "bob@example.com")
+	if _, err := s.Claim(ctx, email, "bob"); err != nil {
+		t.Fatal("error claiming provisional identity:", err)
+	}
+	if claimed, err := s.ClaimedBy(ctx, "app", "Bob"); err != nil || len(claimed) != 0 {
+		t.Fatal("claims of a user differing by case returned")
+	}
+}
+
+func TestStore_ValueTooLong(t *testing.T) {
+	ctx := context.Background()
+	s := newStore(t, sqlstore.SQLite)
+	long := strings.Repeat("é", sqlstore.MaxValueLength+1)
+
+	if err := s.Put(ctx, store.UserKey("app", long), "identity"); !errors.Is(err, sqlstore.ErrValueTooLong) {
+		t.Fatal("expected ErrValueTooLong, got", err)
+	}
+	if _, _, err := s.CompareAndSwap(ctx, store.UserKey("app", long), "", "identity"); !errors.Is(err, sqlstore.ErrValueTooLong) {
+		t.Fatal("expected ErrValueTooLong, got", err)
+	}
+	if _, err := s.Claim(ctx, store.ProvisionalKey("app", "email", "bob@example.com"), long); !errors.Is(err, sqlstore.ErrValueTooLong) {
+		t.Fatal("expected ErrValueTooLong, got", err)
+	}
+	if err := s.Put(ctx, store.UserKey("app", long[:2*sqlstore.MaxValueLength]), "identity"); err != nil {
+		t.Fatal("error putting identity of the longest value:", err)
+	}
+}
+
+func TestLookupPublicIdentity(t *testing.T) {
+	ctx := context.Background()
+	s := newStore(t, sqlstore.SQLite)
+	config := storetest.NewConfig()
+
+	id, err := identity.Create(config, "alice")
+	if err != nil {
+		panic("error creating identity")
+	}
+	userKey := store.UserKey(config.AppID, "alice")
+	if err := s.Put(ctx, userKey, *id); err != nil {
+		t.Fatal("error putting identity:", err)
+	}
+
+	provisionalKeys := map[string]store.Key{}
+	for _, target := range []string{"email", "phone_number"} {
+		secret, public, err := store.GetOrCreateProvisional(ctx, s, config, target, map[string]string{
+			"email":        "alice@example.com",
+			"phone_number": "+33600000000",
+		}[target])
+		if err != nil {
+			t.Fatal("error creating provisional identity:", err)
+		}
+		key, _ := store.ProvisionalKeyOf(secret)
+		provisionalKeys[public] = key
+	}
+
+	pub, err := identity.GetPublicIdentity(*id)
+	if err != nil {
+		panic("error getting public identity")
+	}
+	record, err := s.LookupPublicIdentity(ctx, *pub)
+	if err != nil || record.Key != userKey || record.Identity != *id {
+		t.Fatal("wrong record for public permanent identity")
+	}
+
+	for public, key := range provisionalKeys {
+		record, err := s.LookupPublicIdentity(ctx, public)
+		if err != nil || record.Key != key {
+			t.Fatalf("wrong record for public %s identity", key.Target)
+		}
+	}
+
+	// the public identity of a user with no record, in a known app
+	other, err := identity.PublicIdentityFromUserID(config.AppID, "bob")
+	if err != nil {
+		panic("error getting public identity")
+	}
+	if _, err := s.LookupPublicIdentity(ctx, *other); !errors.Is(err, store.ErrNotFound) {
+		t.Fatal("expected ErrNotFound, got", err)
+	}
+
+	if _, err := s.LookupPublicIdentity(ctx, *id); !errors.Is(err, identity.ErrWrongKind) {
+		t.Fatal("expected ErrWrongKind, got", err)
+	}
+}
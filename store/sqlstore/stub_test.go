@@ -0,0 +1,606 @@
+package sqlstore_test
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"io"
+	"regexp"
+	"strings"
+	"sync"
+)
+
+// stubDriver is a database/sql driver interpreting the small subset of SQL
+// used by sqlstore: tables with a primary key, single-table SELECT, INSERT,
+// UPDATE and DELETE whose WHERE clauses are equalities joined by AND, and
+// transactions. Connections opened with the same name share a database.
+// Schema changes are transactional, and text is compared byte by byte, as
+// in PostgreSQL and SQLite, unless the database is set up with
+// stubDriver.mysql.
+type stubDriver struct {
+	mu  sync.Mutex
+	dbs map[string]*stubDB
+}
+
+const stubDriverName = "sqlstore-stub"
+
+var stub = &stubDriver{dbs: map[string]*stubDB{}}
+
+func init() {
+	sql.Register(stubDriverName, stub)
+}
+
+func (d *stubDriver) Open(name string) (driver.Conn, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	db, found := d.dbs[name]
+	if !found {
+		db = &stubDB{tables: map[string]*stubTable{}}
+		d.dbs[name] = db
+	}
+	return &stubConn{db: db}, nil
+}
+
+// mysql makes the database called name commit schema changes immediately,
+// list its indexes and column collations in information_schema, and
+// compare text case-insensitively unless its collation is binary, like
+// MySQL, and fail the queries matching fail, if set
+func (d *stubDriver) mysql(name string, fail *regexp.Regexp) *stubDB {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	db := &stubDB{tables: map[string]*stubTable{}, ddlCommits: true, fail: fail}
+	d.dbs[name] = db
+	return db
+}
+
+// stubDB is locked for each statement, and for the whole duration of
+// transactions, which are therefore serializable
+type stubDB struct {
+	mu     sync.Mutex
+	tables map[string]*stubTable
+	// ddlCommits makes schema changes commit the current transaction
+	ddlCommits bool
+	fail       *regexp.Regexp
+}
+
+// setFail makes the database fail the queries matching fail
+func (db *stubDB) setFail(fail *regexp.Regexp) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	db.fail = fail
+}
+
+type stubTable struct {
+	columns    []string
+	primaryKey []string
+	indexes    []string
+	// collations holds the collation of the text columns of MySQL
+	// databases
+	collations map[string]string
+	rows       []map[string]driver.Value
+}
+
+func (t *stubTable) clone() *stubTable {
+	c := &stubTable{
+		columns:    append([]string(nil), t.columns...),
+		primaryKey: t.primaryKey,
+		indexes:    append([]string(nil), t.indexes...),
+		collations: map[string]string{},
+	}
+	for column, collation := range t.collations {
+		c.collations[column] = collation
+	}
+	for _, row := range t.rows {
+		r := map[string]driver.Value{}
+		for column, value := range row {
+			r[column] = value
+		}
+		c.rows = append(c.rows, r)
+	}
+	return c
+}
+
+// equal compares values of column according to its collation
+func (t *stubTable) equal(column string, a driver.Value, b driver.Value) bool {
+	sa, aok := a.(string)
+	sb, bok := b.(string)
+	if aok && bok && strings.HasSuffix(t.collations[column], "_ci") {
+		return strings.EqualFold(sa, sb)
+	}
+	return a == b
+}
+
+func (t *stubTable) hasColumn(column string) bool {
+	for _, c := range t.columns {
+		if c == column {
+			return true
+		}
+	}
+	return false
+}
+
+type stubConn struct {
+	db *stubDB
+	// snapshot holds the tables as they were when the current transaction
+	// began, nil outside of transactions
+	snapshot map[string]*stubTable
+}
+
+func (c *stubConn) Prepare(query string) (driver.Stmt, error) {
+	return &stubStmt{conn: c, query: query}, nil
+}
+
+func (c *stubConn) Close() error {
+	if c.snapshot != nil {
+		return c.Rollback()
+	}
+	return nil
+}
+
+func (c *stubConn) Begin() (driver.Tx, error) {
+	c.db.mu.Lock()
+	c.takeSnapshot()
+	return c, nil
+}
+
+func (c *stubConn) takeSnapshot() {
+	c.snapshot = map[string]*stubTable{}
+	for name, table := range c.db.tables {
+		c.snapshot[name] = table.clone()
+	}
+}
+
+func (c *stubConn) Commit() error {
+	c.snapshot = nil
+	c.db.mu.Unlock()
+	return nil
+}
+
+func (c *stubConn) Rollback() error {
+	c.db.tables = c.snapshot
+	c.snapshot = nil
+	c.db.mu.Unlock()
+	return nil
+}
+
+func (c *stubConn) ExecContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Result, error) {
+	_, affected, err := c.run(ctx, query, args)
+	if err != nil {
+		return nil, err
+	}
+	return driver.RowsAffected(affected), nil
+}
+
+func (c *stubConn) QueryContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Rows, error) {
+	rows, _, err := c.run(ctx, query, args)
+	if err != nil {
+		return nil, err
+	}
+	if rows == nil {
+		rows = &stubRows{}
+	}
+	return rows, nil
+}
+
+type stubStmt struct {
+	conn  *stubConn
+	query string
+}
+
+func (s *stubStmt) Close() error  { return nil }
+func (s *stubStmt) NumInput() int { return -1 }
+
+func (s *stubStmt) Exec(args []driver.Value) (driver.Result, error) {
+	return s.conn.ExecContext(context.Background(), s.query, named(args))
+}
+
+func (s *stubStmt) Query(args []driver.Value) (driver.Rows, error) {
+	return s.conn.QueryContext(context.Background(), s.query, named(args))
+}
+
+func named(args []driver.Value) []driver.NamedValue {
+	namedArgs := make([]driver.NamedValue, len(args))
+	for i, arg := range args {
+		namedArgs[i] = driver.NamedValue{Ordinal: i + 1, Value: arg}
+	}
+	return namedArgs
+}
+
+type stubRows struct {
+	columns []string
+	values  [][]driver.Value
+}
+
+func (r *stubRows) Columns() []string { return r.columns }
+func (r *stubRows) Close() error      { return nil }
+
+func (r *stubRows) Next(dest []driver.Value) error {
+	if len(r.values) == 0 {
+		return io.EOF
+	}
+	copy(dest, r.values[0])
+	r.values = r.values[1:]
+	return nil
+}
+
+var (
+	spaces        = regexp.MustCompile(`\s+`)
+	dollarParam   = regexp.MustCompile(`\$\d+`)
+	createTableRe = regexp.MustCompile(`^CREATE TABLE (IF NOT EXISTS )?(\w+) \((.*)\)$`)
+	primaryKeyRe  = regexp.MustCompile(`PRIMARY KEY \((.*)\)$`)
+	alterTableRe  = regexp.MustCompile(`^ALTER TABLE (\w+) ADD COLUMN (\w+) (.*)$`)
+	modifyRe      = regexp.MustCompile(`^ALTER TABLE (\w+) MODIFY (\w+) (.*)$`)
+	createIndexRe = regexp.MustCompile(`^CREATE INDEX (\w+) ON (\w+) \((.*)\)$`)
+	insertRe      = regexp.MustCompile(`^INSERT INTO (\w+) \((.*)\) VALUES \((.*)\)$`)
+	selectRe      = regexp.MustCompile(`^SELECT (.*) FROM (\w+)(?: WHERE (.*))?$`)
+	updateRe      = regexp.MustCompile(`^UPDATE (\w+) SET (.*?)(?: WHERE (.*))?$`)
+	deleteRe      = regexp.MustCompile(`^DELETE FROM (\w+) WHERE (.*)$`)
+	statisticsRe  = regexp.MustCompile(`^SELECT index_name FROM information_schema.statistics WHERE table_schema = DATABASE\(\) AND table_name = \? AND index_name = \?$`)
+	collationsRe  = regexp.MustCompile(`^SELECT collation_name FROM information_schema.columns WHERE table_schema = DATABASE\(\) AND table_name = \? AND column_name = \?$`)
+	textTypeRe    = regexp.MustCompile(`^(VARCHAR|TEXT)\b`)
+	collateRe     = regexp.MustCompile(`\bCOLLATE (\w+)`)
+)
+
+// mysqlDefaultCollation is the default collation of MySQL, which ignores
+// case and accents
+const mysqlDefaultCollation = "utf8mb4_0900_ai_ci"
+
+func (c *stubConn) run(ctx context.Context, query string, args []driver.NamedValue) (*stubRows, int64, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, 0, err
+	}
+	if c.snapshot == nil {
+		c.db.mu.Lock()
+		defer c.db.mu.Unlock()
+	}
+
+	query = spaces.ReplaceAllString(strings.TrimSpace(query), " ")
+	query = strings.NewReplacer("( ", "(", " )", ")").Replace(query)
+	query = dollarParam.ReplaceAllString(query, "?")
+	values := make([]driver.Value, len(args))
+	for i, arg := range args {
+		values[i] = arg.Value
+	}
+	if strings.Count(query, "?") != len(values) {
+		return nil, 0, fmt.Errorf("stub: %d arguments for %q", len(values), query)
+	}
+	if c.db.fail != nil && c.db.fail.MatchString(query) {
+		return nil, 0, fmt.Errorf("stub: failing query %q", query)
+	}
+
+	if m := createTableRe.FindStringSubmatch(query); m != nil {
+		return nil, 0, c.schemaChange(c.createTable(m[2], m[3], m[1] != ""))
+	}
+	if m := alterTableRe.FindStringSubmatch(query); m != nil {
+		return nil, 0, c.schemaChange(c.addColumn(m[1], m[2], m[3]))
+	}
+	if m := modifyRe.FindStringSubmatch(query); m != nil && c.db.ddlCommits {
+		return nil, 0, c.schemaChange(c.modifyColumn(m[1], m[2], m[3]))
+	}
+	if m := createIndexRe.FindStringSubmatch(query); m != nil {
+		return nil, 0, c.schemaChange(c.createIndex(m[1], m[2], list(m[3])))
+	}
+	if statisticsRe.MatchString(query) && c.db.ddlCommits {
+		return c.indexes(values[0], values[1])
+	}
+	if collationsRe.MatchString(query) && c.db.ddlCommits {
+		return c.collation(values[0], values[1])
+	}
+	if m := insertRe.FindStringSubmatch(query); m != nil {
+		return nil, 1, c.insert(m[1], list(m[2]), values)
+	}
+	if m := selectRe.FindStringSubmatch(query); m != nil {
+		rows, err := c.selectRows(m[2], list(m[1]), m[3], values)
+		return rows, 0, err
+	}
+	if m := updateRe.FindStringSubmatch(query); m != nil {
+		affected, err := c.update(m[1], m[2], m[3], values)
+		return nil, affected, err
+	}
+	if m := deleteRe.FindStringSubmatch(query); m != nil {
+		affected, err := c.delete(m[1], m[2], values)
+		return nil, affected, err
+	}
+	return nil, 0, fmt.Errorf("stub: unsupported query %q", query)
+}
+
+// schemaChange commits the current transaction after a successful schema
+// change if the database does so
+func (c *stubConn) schemaChange(err error) error {
+	if err == nil && c.db.ddlCommits && c.snapshot != nil {
+		c.takeSnapshot()
+	}
+	return err
+}
+
+func list(s string) []string {
+	items := strings.Split(s, ",")
+	for i := range items {
+		items[i] = strings.TrimSpace(items[i])
+	}
+	return items
+}
+
+func (c *stubConn) table(name string) (*stubTable, error) {
+	table, found := c.db.tables[name]
+	if !found {
+		return nil, fmt.Errorf("stub: no such table %s", name)
+	}
+	return table, nil
+}
+
+func (c *stubConn) createTable(name string, definition string, ifNotExists bool) error {
+	if _, found := c.db.tables[name]; found {
+		if ifNotExists {
+			return nil
+		}
+		return fmt.Errorf("stub: table %s already exists", name)
+	}
+
+	table := &stubTable{collations: map[string]string{}}
+	for _, column := range splitColumns(definition) {
+		if m := primaryKeyRe.FindStringSubmatch(column); m != nil && strings.HasPrefix(column, "CONSTRAINT ") {
+			table.primaryKey = list(m[1])
+			continue
+		}
+		columnName, columnType, _ := strings.Cut(column, " ")
+		table.columns = append(table.columns, columnName)
+		c.setCollation(table, columnName, columnType)
+		if strings.HasSuffix(column, " PRIMARY KEY") {
+			table.primaryKey = []string{columnName}
+		}
+	}
+	c.db.tables[name] = table
+	return nil
+}
+
+// splitColumns splits a table definition on the commas which are not
+// within parentheses
+func splitColumns(definition string) []string {
+	var columns []string
+	depth, start := 0, 0
+	for i, r := range definition {
+		switch r {
+		case '(':
+			depth++
+		case ')':
+			depth--
+		case ',':
+			if depth == 0 {
+				columns = append(columns, strings.TrimSpace(definition[start:i]))
+				start = i + 1
+			}
+		}
+	}
+	return append(columns, strings.TrimSpace(definition[start:]))
+}
+
+// setCollation sets the collation of column, of type columnType, if the
+// database is MySQL and the column holds text
+func (c *stubConn) setCollation(table *stubTable, column string, columnType string) {
+	if !c.db.ddlCommits || !textTypeRe.MatchString(columnType) {
+		delete(table.collations, column)
+		return
+	}
+	table.collations[column] = mysqlDefaultCollation
+	if m := collateRe.FindStringSubmatch(columnType); m != nil {
+		table.collations[column] = m[1]
+	}
+}
+
+func (c *stubConn) addColumn(tableName string, column string, columnType string) error {
+	table, err := c.table(tableName)
+	if err != nil {
+		return err
+	}
+	if table.hasColumn(column) {
+		return fmt.Errorf("stub: column %s already exists", column)
+	}
+	table.columns = append(table.columns, column)
+	c.setCollation(table, column, columnType)
+	return nil
+}
+
+func (c *stubConn) modifyColumn(tableName string, column string, columnType string) error {
+	table, err := c.table(tableName)
+	if err != nil {
+		return err
+	}
+	if !table.hasColumn(column) {
+		return fmt.Errorf("stub: no such column %s", column)
+	}
+	c.setCollation(table, column, columnType)
+	return nil
+}
+
+// collation returns the collation of column in table, NULL for columns
+// which do not hold text
+func (c *stubConn) collation(tableName driver.Value, column driver.Value) (*stubRows, int64, error) {
+	rows := &stubRows{columns: []string{"collation_name"}}
+	if table, found := c.db.tables[fmt.Sprint(tableName)]; found && table.hasColumn(fmt.Sprint(column)) {
+		var collation driver.Value
+		if c, found := table.collations[fmt.Sprint(column)]; found {
+			collation = c
+		}
+		rows.values = append(rows.values, []driver.Value{collation})
+	}
+	return rows, 0, nil
+}
+
+func (c *stubConn) createIndex(name string, tableName string, columns []string) error {
+	table, err := c.table(tableName)
+	if err != nil {
+		return err
+	}
+	for _, column := range columns {
+		if !table.hasColumn(column) {
+			return fmt.Errorf("stub: no such column %s", column)
+		}
+	}
+	for _, index := range table.indexes {
+		if index == name {
+			return fmt.Errorf("stub: index %s already exists", name)
+		}
+	}
+	table.indexes = append(table.indexes, name)
+	return nil
+}
+
+// indexes lists the index called name on table, if it exists
+func (c *stubConn) indexes(tableName driver.Value, name driver.Value) (*stubRows, int64, error) {
+	rows := &stubRows{columns: []string{"index_name"}}
+	if table, found := c.db.tables[fmt.Sprint(tableName)]; found {
+		for _, index := range table.indexes {
+			if index == name {
+				rows.values = append(rows.values, []driver.Value{index})
+			}
+		}
+	}
+	return rows, 0, nil
+}
+
+var errUniqueConstraint = errors.New("stub: UNIQUE constraint failed")
+
+func (c *stubConn) insert(tableName string, columns []string, values []driver.Value) error {
+	table, err := c.table(tableName)
+	if err != nil {
+		return err
+	}
+	row := map[string]driver.Value{}
+	for i, column := range columns {
+		if !table.hasColumn(column) {
+			return fmt.Errorf("stub: no such column %s", column)
+		}
+		row[column] = values[i]
+	}
+	for _, existing := range table.rows {
+		if samePrimaryKey(table, existing, row) {
+			return errUniqueConstraint
+		}
+	}
+	table.rows = append(table.rows, row)
+	return nil
+}
+
+func samePrimaryKey(table *stubTable, a map[string]driver.Value, b map[string]driver.Value) bool {
+	if len(table.primaryKey) == 0 {
+		return false
+	}
+	for _, column := range table.primaryKey {
+		if !table.equal(column, a[column], b[column]) {
+			return false
+		}
+	}
+	return true
+}
+
+// where returns a filter for conditions, whose placeholders are bound to
+// values in order
+func where(table *stubTable, conditions string, values []driver.Value) (func(map[string]driver.Value) bool, error) {
+	if conditions == "" {
+		return func(map[string]driver.Value) bool { return true }, nil
+	}
+	if conditions == "1 = 0" {
+		return func(map[string]driver.Value) bool { return false }, nil
+	}
+	filter := map[string]driver.Value{}
+	for i, condition := range strings.Split(conditions, " AND ") {
+		column := strings.TrimSuffix(condition, " = ?")
+		if column == condition || !table.hasColumn(column) {
+			return nil, fmt.Errorf("stub: unsupported condition %q", condition)
+		}
+		filter[column] = values[i]
+	}
+	return func(row map[string]driver.Value) bool {
+		for column, value := range filter {
+			// NULL is never equal to anything
+			if row[column] == nil || !table.equal(column, row[column], value) {
+				return false
+			}
+		}
+		return true
+	}, nil
+}
+
+func (c *stubConn) selectRows(tableName string, columns []string, conditions string, values []driver.Value) (*stubRows, error) {
+	table, err := c.table(tableName)
+	if err != nil {
+		return nil, err
+	}
+	for _, column := range columns {
+		if !table.hasColumn(column) {
+			return nil, fmt.Errorf("stub: no such column %s", column)
+		}
+	}
+	match, err := where(table, conditions, values)
+	if err != nil {
+		return nil, err
+	}
+
+	rows := &stubRows{columns: columns}
+	for _, row := range table.rows {
+		if !match(row) {
+			continue
+		}
+		selected := make([]driver.Value, len(columns))
+		for i, column := range columns {
+			selected[i] = row[column]
+		}
+		rows.values = append(rows.values, selected)
+	}
+	return rows, nil
+}
+
+func (c *stubConn) update(tableName string, assignments string, conditions string, values []driver.Value) (int64, error) {
+	table, err := c.table(tableName)
+	if err != nil {
+		return 0, err
+	}
+	set := map[string]driver.Value{}
+	for i, assignment := range list(assignments) {
+		column := strings.TrimSuffix(assignment, " = ?")
+		if column == assignment || !table.hasColumn(column) {
+			return 0, fmt.Errorf("stub: unsupported assignment %q", assignment)
+		}
+		set[column] = values[i]
+	}
+	match, err := where(table, conditions, values[len(set):])
+	if err != nil {
+		return 0, err
+	}
+
+	var affected int64
+	for _, row := range table.rows {
+		if !match(row) {
+			continue
+		}
+		for column, value := range set {
+			row[column] = value
+		}
+		affected++
+	}
+	return affected, nil
+}
+
+func (c *stubConn) delete(tableName string, conditions string, values []driver.Value) (int64, error) {
+	table, err := c.table(tableName)
+	if err != nil {
+		return 0, err
+	}
+	match, err := where(table, conditions, values)
+	if err != nil {
+		return 0, err
+	}
+
+	kept := table.rows[:0]
+	for _, row := range table.rows {
+		if !match(row) {
+			kept = append(kept, row)
+		}
+	}
+	affected := int64(len(table.rows) - len(kept))
+	table.rows = kept
+	return affected, nil
+}
@@ -0,0 +1,133 @@
+// Package store persists Tanker identities, so that the same identity is
+// delivered to a user every time. Identities are keyed by app ID, then by
+// user ID for permanent identities, or by target and value for
+// provisional identities.
+package store
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/TankerHQ/identity-go/v3"
+)
+
+// UserTarget is the Key target of permanent identities
+const UserTarget = "user"
+
+// ErrNotFound is returned when no identity is stored for a key
+var ErrNotFound = errors.New("identity not found")
+
+// Key identifies a stored identity
+type Key struct {
+	// AppID is the ID of the app the identity belongs to
+	AppID string `json:"app_id"`
+	// Target is UserTarget for permanent identities, and the provisional
+	// identity target ("email" or "phone_number") otherwise
+	Target string `json:"target"`
+	// Value is the user ID for permanent identities, and the email or
+	// phone number otherwise
+	Value string `json:"value"`
+}
+
+// UserKey returns the key of the permanent identity of userID
+func UserKey(appID string, userID string) Key {
+	return Key{AppID: appID, Target: UserTarget, Value: userID}
+}
+
+// ProvisionalKey returns the key of the provisional identity for target
+// and value
+func ProvisionalKey(appID string, target string, value string) Key {
+	return Key{AppID: appID, Target: target, Value: value}
+}
+
+// ProvisionalKeyOf returns the key of b64Identity, a secret provisional
+// identity such as the ones returned by identity.CreateProvisional
+func ProvisionalKeyOf(b64Identity string) (Key, error) {
+	parsed, err := identity.ParseIdentity(b64Identity)
+	if err != nil {
+		return Key{}, err
+	}
+	provisional, ok := parsed.(*identity.SecretProvisionalIdentity)
+	if !ok {
+		return Key{}, fmt.Errorf("%w: expected a %v identity, got a %v identity", identity.ErrWrongKind, identity.KindSecretProvisional, parsed.Kind())
+	}
+	appID := base64.StdEncoding.EncodeToString(provisional.TrustchainID)
+	return ProvisionalKey(appID, provisional.Target, provisional.Value), nil
+}
+
+// IsProvisional returns whether k is the key of a provisional identity.
+// Keys of other records kept in a store, such as the data keys of package
+// envelope, are neither permanent nor provisional.
+func (k Key) IsProvisional() bool {
+	return k.Target == "email" || k.Target == "phone_number"
+}
+
+// Record is a stored identity
+type Record struct {
+	Key Key
+	// Identity is the base64-encoded secret identity
+	Identity string
+	// CreatedAt is when Identity was stored
+	CreatedAt time.Time
+	// AccessedAt is when the identity was last delivered, as recorded by
+	// Touch. GetOrCreateProvisional updates it at most once per
+	// AccessResolution.
+	AccessedAt time.Time
+}
+
+// IdentityStore is implemented by identity storage backends.
+//
+// Implementations must be safe for concurrent use. The storetest package
+// checks that an implementation behaves as documented.
+type IdentityStore interface {
+	// Get returns the record stored for key, or ErrNotFound
+	Get(ctx context.Context, key Key) (Record, error)
+	// Put stores identity for key, replacing any previous record. The
+	// CreatedAt and AccessedAt of the record are set to the current time.
+	Put(ctx context.Context, key Key, identity string) error
+	// CompareAndSwap atomically replaces the record stored for key with
+	// identity if the current record holds old, or stores it if there is
+	// no record and old is empty. It returns the record stored for key
+	// once the operation is done, and whether identity was stored. A
+	// stored record has its timestamps set like with Put.
+	CompareAndSwap(ctx context.Context, key Key, old string, identity string) (Record, bool, error)
+	// Touch sets the AccessedAt of the record stored for key to at, or
+	// returns ErrNotFound
+	Touch(ctx context.Context, key Key, at time.Time) error
+	// Delete removes the record stored for key. Deleting a key with no
+	// record is not an error.
+	Delete(ctx context.Context, key Key) error
+	// Range calls fn for each stored record, in no particular order, until
+	// fn returns an error, which Range then returns. fn may call the other
+	// methods of the store.
+	Range(ctx context.Context, fn func(Record) error) error
+}
+
+// Exister is implemented by stores which can tell whether a record exists
+// more cheaply than by reading it, for instance without decrypting it
+type Exister interface {
+	// Exists returns whether a record is stored for key
+	Exists(ctx context.Context, key Key) (bool, error)
+}
+
+// Exists returns whether a record is stored for key in s, using
+// Exister.Exists when s implements it
+func Exists(ctx context.Context, s IdentityStore, key Key) (bool, error) {
+	if exister, ok := s.(Exister); ok {
+		return exister.Exists(ctx, key)
+	}
+	_, err := s.Get(ctx, key)
+	if errors.Is(err, ErrNotFound) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// newRecord returns a record for identity, created now
+func newRecord(key Key, identity string) Record {
+	now := time.Now().UTC()
+	return Record{Key: key, Identity: identity, CreatedAt: now, AccessedAt: now}
+}
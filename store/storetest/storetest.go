@@ -0,0 +1,504 @@
+// Package storetest checks that an implementation of store.IdentityStore,
+// and of store.ClaimStore if it implements it, behaves as documented. Backends run it from their own tests:
+//
+//	func TestMyStore(t *testing.T) {
+//		storetest.Run(t, func(t *testing.T) store.IdentityStore {
+//			return newMyStore(t)
+//		})
+//	}
+package storetest
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/TankerHQ/identity-go/v3"
+	"github.com/TankerHQ/identity-go/v3/internal/app"
+	"github.com/TankerHQ/identity-go/v3/store"
+)
+
+// NewStoreFunc returns a new, empty store. It is called once per sub-test.
+type NewStoreFunc func(t *testing.T) store.IdentityStore
+
+// NewConfig returns the config of a random app, for tests
+func NewConfig() identity.Config {
+	_, appSecret, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		panic("error generating app secret")
+	}
+	return identity.Config{
+		AppID:     base64.StdEncoding.EncodeToString(app.GetAppId(appSecret)),
+		AppSecret: base64.StdEncoding.EncodeToString(appSecret),
+	}
+}
+
+// Run runs the conformance suite against the stores returned by newStore
+func Run(t *testing.T, newStore NewStoreFunc) {
+	t.Run("GetNotFound", func(t *testing.T) { testGetNotFound(t, newStore(t)) })
+	t.Run("PutGet", func(t *testing.T) { testPutGet(t, newStore(t)) })
+	t.Run("PutReplaces", func(t *testing.T) { testPutReplaces(t, newStore(t)) })
+	t.Run("DistinctKeys", func(t *testing.T) { testDistinctKeys(t, newStore(t)) })
+	t.Run("CompareAndSwap", func(t *testing.T) { testCompareAndSwap(t, newStore(t)) })
+	t.Run("CompareAndSwapConcurrent", func(t *testing.T) { testCompareAndSwapConcurrent(t, newStore(t)) })
+	t.Run("Timestamps", func(t *testing.T) { testTimestamps(t, newStore(t)) })
+	t.Run("Touch", func(t *testing.T) { testTouch(t, newStore(t)) })
+	t.Run("Delete", func(t *testing.T) { testDelete(t, newStore(t)) })
+	t.Run("Range", func(t *testing.T) { testRange(t, newStore(t)) })
+	t.Run("RangeError", func(t *testing.T) { testRangeError(t, newStore(t)) })
+	t.Run("RangeDelete", func(t *testing.T) { testRangeDelete(t, newStore(t)) })
+	t.Run("Concurrent", func(t *testing.T) { testConcurrent(t, newStore(t)) })
+	t.Run("Claims", func(t *testing.T) { testClaims(t, newStore(t)) })
+}
+
+func testGetNotFound(t *testing.T, s store.IdentityStore) {
+	_, err := s.Get(context.Background(), store.UserKey("app", "alice"))
+	if !errors.Is(err, store.ErrNotFound) {
+		t.Fatalf("expected ErrNotFound, got %v", err)
+	}
+}
+
+func testPutGet(t *testing.T, s store.IdentityStore) {
+	ctx := context.Background()
+	config := NewConfig()
+
+	id, err := identity.Create(config, "alice")
+	if err != nil {
+		panic("error creating identity")
+	}
+	key := store.UserKey(config.AppID, "alice")
+	if err := s.Put(ctx, key, *id); err != nil {
+		t.Fatal("error putting identity:", err)
+	}
+
+	prov, err := identity.CreateProvisional(config, "email", "alice@example.com")
+	if err != nil {
+		panic("error creating provisional identity")
+	}
+	provKey, err := store.ProvisionalKeyOf(*prov)
+	if err != nil {
+		t.Fatal("error getting provisional key:", err)
+	}
+	if err := s.Put(ctx, provKey, *prov); err != nil {
+		t.Fatal("error putting provisional identity:", err)
+	}
+
+	record, err := s.Get(ctx, key)
+	if err != nil {
+		t.Fatal("error getting identity:", err)
+	}
+	if record.Key != key || record.Identity != *id {
+		t.Fatal("wrong record returned")
+	}
+
+	record, err = s.Get(ctx, store.ProvisionalKey(config.AppID, "email", "alice@example.com"))
+	if err != nil {
+		t.Fatal("error getting provisional identity:", err)
+	}
+	if record.Key != provKey || record.Identity != *prov {
+		t.Fatal("wrong provisional record returned")
+	}
+}
+
+func testPutReplaces(t *testing.T, s store.IdentityStore) {
+	ctx := context.Background()
+	key := store.UserKey("app", "alice")
+
+	if err := s.Put(ctx, key, "first"); err != nil {
+		t.Fatal("error putting identity:", err)
+	}
+	if err := s.Put(ctx, key, "second"); err != nil {
+		t.Fatal("error putting identity:", err)
+	}
+	record, err := s.Get(ctx, key)
+	if err != nil {
+		t.Fatal("error getting identity:", err)
+	}
+	if record.Identity != "second" {
+		t.Fatal("Put did not replace the record")
+	}
+}
+
+func testDistinctKeys(t *testing.T, s store.IdentityStore) {
+	ctx := context.Background()
+	keys := []store.Key{
+		store.UserKey("app1", "alice"),
+		store.UserKey("app2", "alice"),
+		store.UserKey("app1", "bob"),
+		store.ProvisionalKey("app1", "email", "alice"),
+		store.ProvisionalKey("app1", "phone_number", "alice"),
+	}
+
+	for i, key := range keys {
+		if err := s.Put(ctx, key, fmt.Sprint(i)); err != nil {
+			t.Fatal("error putting identity:", err)
+		}
+	}
+	for i, key := range keys {
+		record, err := s.Get(ctx, key)
+		if err != nil {
+			t.Fatal("error getting identity:", err)
+		}
+		if record.Identity != fmt.Sprint(i) {
+			t.Fatalf("records of %+v and %+v collide", key, keys[i])
+		}
+	}
+}
+
+func testCompareAndSwap(t *testing.T, s store.IdentityStore) {
+	ctx := context.Background()
+	key := store.UserKey("app", "alice")
+
+	record, swapped, err := s.CompareAndSwap(ctx, key, "missing", "first")
+	if err != nil || swapped {
+		t.Fatal("CompareAndSwap stored a record with a wrong old value")
+	}
+	if _, err := s.Get(ctx, key); !errors.Is(err, store.ErrNotFound) {
+		t.Fatal("failed CompareAndSwap stored a record")
+	}
+
+	record, swapped, err = s.CompareAndSwap(ctx, key, "", "first")
+	if err != nil || !swapped || record.Identity != "first" || record.Key != key {
+		t.Fatal("CompareAndSwap did not store a new record")
+	}
+
+	record, swapped, err = s.CompareAndSwap(ctx, key, "", "second")
+	if err != nil || swapped {
+		t.Fatal("CompareAndSwap replaced an existing record")
+	}
+	if record.Identity != "first" {
+		t.Fatal("failed CompareAndSwap did not return the current record")
+	}
+
+	record, swapped, err = s.CompareAndSwap(ctx, key, "first", "second")
+	if err != nil || !swapped || record.Identity != "second" {
+		t.Fatal("CompareAndSwap did not replace the record")
+	}
+	record, err = s.Get(ctx, key)
+	if err != nil || record.Identity != "second" {
+		t.Fatal("CompareAndSwap did not persist the record")
+	}
+}
+
+// checkRecent fails unless at is between before and after, allowing for
+// stores which keep timestamps with a millisecond precision
+func checkRecent(t *testing.T, name string, at time.Time, before time.Time, after time.Time) {
+	t.Helper()
+	if at.Before(before.Add(-time.Millisecond)) || at.After(after.Add(time.Millisecond)) {
+		t.Fatalf("%s is %v, should be between %v and %v", name, at, before, after)
+	}
+}
+
+func testTimestamps(t *testing.T, s store.IdentityStore) {
+	ctx := context.Background()
+	key := store.UserKey("app", "alice")
+
+	before := time.Now()
+	if err := s.Put(ctx, key, "first"); err != nil {
+		t.Fatal("error putting identity:", err)
+	}
+	after := time.Now()
+	record, err := s.Get(ctx, key)
+	if err != nil {
+		t.Fatal("error getting identity:", err)
+	}
+	checkRecent(t, "CreatedAt", record.CreatedAt, before, after)
+	checkRecent(t, "AccessedAt", record.AccessedAt, before, after)
+
+	time.Sleep(2 * time.Millisecond)
+	before = time.Now()
+	swapped, ok, err := s.CompareAndSwap(ctx, key, "first", "second")
+	if err != nil || !ok {
+		t.Fatal("error swapping identity:", err)
+	}
+	after = time.Now()
+	checkRecent(t, "CreatedAt", swapped.CreatedAt, before, after)
+	record, err = s.Get(ctx, key)
+	if err != nil {
+		t.Fatal("error getting identity:", err)
+	}
+	checkRecent(t, "CreatedAt", record.CreatedAt, before, after)
+}
+
+func testTouch(t *testing.T, s store.IdentityStore) {
+	ctx := context.Background()
+	key := store.ProvisionalKey("app", "email", "alice@example.com")
+
+	if err := s.Touch(ctx, key, time.Now()); !errors.Is(err, store.ErrNotFound) {
+		t.Fatalf("expected ErrNotFound, got %v", err)
+	}
+	if err := s.Put(ctx, key, "identity"); err != nil {
+		t.Fatal("error putting identity:", err)
+	}
+	created, err := s.Get(ctx, key)
+	if err != nil {
+		t.Fatal("error getting identity:", err)
+	}
+
+	at := time.Date(2030, time.January, 2, 3, 4, 5, 0, time.UTC)
+	if err := s.Touch(ctx, key, at); err != nil {
+		t.Fatal("error touching identity:", err)
+	}
+	record, err := s.Get(ctx, key)
+	if err != nil {
+		t.Fatal("error getting identity:", err)
+	}
+	if !record.AccessedAt.Equal(at) || !record.CreatedAt.Equal(created.CreatedAt) || record.Identity != "identity" {
+		t.Fatal("Touch did not only update AccessedAt")
+	}
+}
+
+func testCompareAndSwapConcurrent(t *testing.T, s store.IdentityStore) {
+	ctx := context.Background()
+	key := store.UserKey("app", "alice")
+	const workers = 16
+
+	var wg sync.WaitGroup
+	results := make(chan store.Record, workers)
+	swaps := make(chan bool, workers)
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			record, swapped, err := s.CompareAndSwap(ctx, key, "", fmt.Sprint(w))
+			if err != nil {
+				t.Error("error in CompareAndSwap:", err)
+				return
+			}
+			results <- record
+			swaps <- swapped
+		}(w)
+	}
+	wg.Wait()
+	close(results)
+	close(swaps)
+
+	swapCount := 0
+	for swapped := range swaps {
+		if swapped {
+			swapCount++
+		}
+	}
+	if swapCount != 1 {
+		t.Fatalf("expected exactly one successful CompareAndSwap, got %d", swapCount)
+	}
+
+	stored, err := s.Get(ctx, key)
+	if err != nil {
+		t.Fatal("error getting identity:", err)
+	}
+	for record := range results {
+		if record.Identity != stored.Identity {
+			t.Fatal("CompareAndSwap returned a record that is not the stored one")
+		}
+	}
+}
+
+func testDelete(t *testing.T, s store.IdentityStore) {
+	ctx := context.Background()
+	key := store.UserKey("app", "alice")
+	other := store.UserKey("app", "bob")
+
+	if err := s.Put(ctx, key, "alice"); err != nil {
+		t.Fatal("error putting identity:", err)
+	}
+	if err := s.Put(ctx, other, "bob"); err != nil {
+		t.Fatal("error putting identity:", err)
+	}
+	if err := s.Delete(ctx, key); err != nil {
+		t.Fatal("error deleting identity:", err)
+	}
+	if _, err := s.Get(ctx, key); !errors.Is(err, store.ErrNotFound) {
+		t.Fatalf("expected ErrNotFound after delete, got %v", err)
+	}
+	if _, err := s.Get(ctx, other); err != nil {
+		t.Fatal("Delete removed another record")
+	}
+	if err := s.Delete(ctx, key); err != nil {
+		t.Fatal("error deleting missing identity:", err)
+	}
+}
+
+func testRange(t *testing.T, s store.IdentityStore) {
+	ctx := context.Background()
+	expected := map[store.Key]string{}
+	for i := 0; i < 10; i++ {
+		key := store.UserKey("app", fmt.Sprint("user", i))
+		expected[key] = fmt.Sprint(i)
+		if err := s.Put(ctx, key, fmt.Sprint(i)); err != nil {
+			t.Fatal("error putting identity:", err)
+		}
+	}
+
+	seen := map[store.Key]string{}
+	err := s.Range(ctx, func(record store.Record) error {
+		seen[record.Key] = record.Identity
+		return nil
+	})
+	if err != nil {
+		t.Fatal("error ranging over records:", err)
+	}
+	if len(seen) != len(expected) {
+		t.Fatalf("expected %d records, got %d", len(expected), len(seen))
+	}
+	for key, id := range expected {
+		if seen[key] != id {
+			t.Fatalf("wrong record for %+v", key)
+		}
+	}
+}
+
+func testRangeError(t *testing.T, s store.IdentityStore) {
+	ctx := context.Background()
+	for i := 0; i < 3; i++ {
+		if err := s.Put(ctx, store.UserKey("app", fmt.Sprint(i)), "id"); err != nil {
+			t.Fatal("error putting identity:", err)
+		}
+	}
+
+	stop := errors.New("stop")
+	calls := 0
+	err := s.Range(ctx, func(store.Record) error {
+		calls++
+		return stop
+	})
+	if !errors.Is(err, stop) || calls != 1 {
+		t.Fatal("Range did not stop on the first error")
+	}
+}
+
+func testRangeDelete(t *testing.T, s store.IdentityStore) {
+	ctx := context.Background()
+	for i := 0; i < 3; i++ {
+		if err := s.Put(ctx, store.UserKey("app", fmt.Sprint(i)), "id"); err != nil {
+			t.Fatal("error putting identity:", err)
+		}
+	}
+
+	err := s.Range(ctx, func(record store.Record) error {
+		return s.Delete(ctx, record.Key)
+	})
+	if err != nil {
+		t.Fatal("error deleting while ranging:", err)
+	}
+	err = s.Range(ctx, func(record store.Record) error {
+		return fmt.Errorf("record %+v not deleted", record.Key)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func testConcurrent(t *testing.T, s store.IdentityStore) {
+	ctx := context.Background()
+	const workers = 8
+	const perWorker = 20
+
+	var wg sync.WaitGroup
+	errs := make(chan error, workers)
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			for i := 0; i < perWorker; i++ {
+				key := store.UserKey("app", fmt.Sprint(w, "-", i))
+				if err := s.Put(ctx, key, key.Value); err != nil {
+					errs <- err
+					return
+				}
+				record, err := s.Get(ctx, key)
+				if err != nil {
+					errs <- err
+					return
+				}
+				if record.Identity != key.Value {
+					errs <- fmt.Errorf("wrong record for %+v", key)
+					return
+				}
+			}
+		}(w)
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Fatal("error using store concurrently:", err)
+	}
+
+	count := 0
+	if err := s.Range(ctx, func(store.Record) error { count++; return nil }); err != nil {
+		t.Fatal("error ranging over records:", err)
+	}
+	if count != workers*perWorker {
+		t.Fatalf("expected %d records, got %d", workers*perWorker, count)
+	}
+}
+
+func testClaims(t *testing.T, s store.IdentityStore) {
+	claims, ok := s.(store.ClaimStore)
+	if !ok {
+		t.Skip("store does not keep claims")
+	}
+	ctx := context.Background()
+	email := store.ProvisionalKey("app", "email", "alice@example.com")
+	phoneNumber := store.ProvisionalKey("app", "phone_number", "+33600000000")
+	if err := s.Put(ctx, email, "email identity"); err != nil {
+		t.Fatal("error putting identity:", err)
+	}
+
+	if _, err := claims.Claimant(ctx, email); !errors.Is(err, store.ErrNotFound) {
+		t.Fatalf("expected ErrNotFound, got %v", err)
+	}
+	for _, key := range []store.Key{email, phoneNumber, email} {
+		if claimant, err := claims.Claim(ctx, key, "alice"); err != nil || claimant != "alice" {
+			t.Fatal("error claiming provisional identity:", err)
+		}
+	}
+	if claimant, err := claims.Claim(ctx, email, "mallory"); err != nil || claimant != "alice" {
+		t.Fatal("provisional identity claimed twice")
+	}
+	if claimant, err := claims.Claimant(ctx, email); err != nil || claimant != "alice" {
+		t.Fatal("wrong claimant")
+	}
+	claimed, err := claims.ClaimedBy(ctx, "app", "alice")
+	if err != nil || len(claimed) != 2 || claimed[0] != email || claimed[1] != phoneNumber {
+		t.Fatalf("wrong claimed keys: %v (%v)", claimed, err)
+	}
+	if claimed, err := claims.ClaimedBy(ctx, "other app", "alice"); err != nil || len(claimed) != 0 {
+		t.Fatal("claims of another app returned")
+	}
+
+	// claims are not records
+	records := 0
+	err = s.Range(ctx, func(store.Record) error {
+		records++
+		return nil
+	})
+	if err != nil || records != 1 {
+		t.Fatalf("expected 1 record, got %d (%v)", records, err)
+	}
+	if err := s.Delete(ctx, email); err != nil {
+		t.Fatal("error deleting identity:", err)
+	}
+	if claimant, err := claims.Claimant(ctx, email); err != nil || claimant != "alice" {
+		t.Fatal("claim removed along with the identity")
+	}
+
+	if err := claims.Unclaim(ctx, "app", "alice"); err != nil {
+		t.Fatal("error removing claims:", err)
+	}
+	if claimed, err := claims.ClaimedBy(ctx, "app", "alice"); err != nil || len(claimed) != 0 {
+		t.Fatal("claims left after Unclaim")
+	}
+	if _, err := claims.Claimant(ctx, phoneNumber); !errors.Is(err, store.ErrNotFound) {
+		t.Fatalf("expected ErrNotFound, got %v", err)
+	}
+	if err := claims.Unclaim(ctx, "app", "alice"); err != nil {
+		t.Fatal("error removing claims of a user with none:", err)
+	}
+}
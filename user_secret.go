@@ -1,7 +1,7 @@
 package identity
 
 import (
-	"crypto/rand"
+	"io"
 
 	"golang.org/x/crypto/blake2b"
 )
@@ -14,14 +14,13 @@ func hashUserID(trustchainID []byte, userIDString string) []byte {
 	return hashedUserID[:]
 }
 
-func newUserSecret(userID []byte) []byte {
+func newUserSecret(random io.Reader, userID []byte) ([]byte, error) {
 	randdata := make([]byte, userSecretSize-1)
-	_, err := rand.Read(randdata)
-	if err != nil {
-		panic("random failed: " + err.Error())
+	if _, err := io.ReadFull(random, randdata); err != nil {
+		return nil, err
 	}
 	check := oneByteGenericHash(append(randdata, userID...))
-	return append(randdata, check)
+	return append(randdata, check), nil
 }
 
 func oneByteGenericHash(input []byte) byte {
@@ -32,3 +31,12 @@ func oneByteGenericHash(input []byte) byte {
 	hash.Write(input)
 	return hash.Sum(nil)[0]
 }
+
+func checkUserSecret(userSecret []byte, userID []byte) bool {
+	if len(userSecret) != userSecretSize {
+		return false
+	}
+	randdata := userSecret[:userSecretSize-1]
+	check := oneByteGenericHash(append(append([]byte{}, randdata...), userID...))
+	return check == userSecret[userSecretSize-1]
+}
@@ -0,0 +1,103 @@
+package identity
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"encoding/base64"
+	"fmt"
+
+	"github.com/TankerHQ/identity-go/v3/internal/crypto"
+)
+
+// VerifyIdentity checks that b64Identity is a valid secret permanent
+// identity for userID in the app described by config. It returns nil
+// when the identity is valid, and an error describing the first
+// inconsistency found otherwise.
+func VerifyIdentity(config Config, b64Identity string, userID string) error {
+	conf, err := config.fromBase64()
+	if err != nil {
+		return err
+	}
+	if err := checkKeysIntegrity(*conf); err != nil {
+		return err
+	}
+
+	parsed, err := ParseIdentity(b64Identity)
+	if err != nil {
+		return err
+	}
+	identity, ok := parsed.(*SecretPermanentIdentity)
+	if !ok {
+		return fmt.Errorf("%w: expected a %v identity, got a %v identity", ErrWrongKind, KindSecretPermanent, parsed.Kind())
+	}
+	return verifyIdentity(*conf, identity, userID)
+}
+
+func verifyIdentity(config config, identity *SecretPermanentIdentity, userIDString string) error {
+	if !bytes.Equal(identity.TrustchainID, config.AppID) {
+		return fieldError(ErrInvalidIdentity, "trustchain_id", "does not match the app ID")
+	}
+
+	userID := hashUserID(config.AppID, userIDString)
+	if identity.Value != base64.StdEncoding.EncodeToString(userID) {
+		return fieldError(ErrInvalidIdentity, "value", "does not match the user ID")
+	}
+
+	// key sizes were checked by ParseIdentity
+	appPublicKey := ed25519.PrivateKey(config.AppSecret).Public().(ed25519.PublicKey)
+	payload := append(append([]byte{}, identity.EphemeralPublicSignatureKey...), userID...)
+	if !ed25519.Verify(appPublicKey, payload, identity.DelegationSignature) {
+		return fieldError(ErrInvalidIdentity, "delegation_signature", "is not a valid signature by the app")
+	}
+
+	// the public half stored in an ed25519.PrivateKey is not checked by the
+	// standard library, so derive it again from the seed
+	ephemeralSeed := ed25519.PrivateKey(identity.EphemeralPrivateSignatureKey).Seed()
+	derived := ed25519.NewKeyFromSeed(ephemeralSeed)
+	if !bytes.Equal(derived, identity.EphemeralPrivateSignatureKey) ||
+		!bytes.Equal(derived.Public().(ed25519.PublicKey), identity.EphemeralPublicSignatureKey) {
+		return fieldError(ErrInvalidIdentity, "ephemeral_private_signature_key", "does not match ephemeral_public_signature_key")
+	}
+
+	if !checkUserSecret(identity.UserSecret, userID) {
+		return fieldError(ErrInvalidIdentity, "user_secret", "does not match the user ID")
+	}
+
+	return nil
+}
+
+// ValidateProvisionalIdentity checks that b64Identity is a well-formed
+// secret provisional identity whose private keys match its public keys.
+// It returns nil when the identity is consistent, and an error
+// describing the first inconsistency found otherwise.
+func ValidateProvisionalIdentity(b64Identity string) error {
+	parsed, err := ParseIdentity(b64Identity)
+	if err != nil {
+		return err
+	}
+	identity, ok := parsed.(*SecretProvisionalIdentity)
+	if !ok {
+		return fmt.Errorf("%w: expected a %v identity, got a %v identity", ErrWrongKind, KindSecretProvisional, parsed.Kind())
+	}
+	return validateProvisionalIdentity(identity)
+}
+
+func validateProvisionalIdentity(identity *SecretProvisionalIdentity) error {
+	// key sizes were checked by ParseIdentity
+	signatureSeed := ed25519.PrivateKey(identity.PrivateSignatureKey).Seed()
+	derivedSignatureKey := ed25519.NewKeyFromSeed(signatureSeed)
+	if !bytes.Equal(derivedSignatureKey, identity.PrivateSignatureKey) ||
+		!bytes.Equal(derivedSignatureKey.Public().(ed25519.PublicKey), identity.PublicSignatureKey) {
+		return fieldError(ErrInvalidIdentity, "private_signature_key", "does not match public_signature_key")
+	}
+
+	derivedEncryptionKey, err := crypto.PublicKey(identity.PrivateEncryptionKey)
+	if err != nil {
+		return err
+	}
+	if !bytes.Equal(derivedEncryptionKey, identity.PublicEncryptionKey) {
+		return fieldError(ErrInvalidIdentity, "private_encryption_key", "does not match public_encryption_key")
+	}
+
+	return nil
+}
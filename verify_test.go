@@ -0,0 +1,273 @@
+package identity_test
+
+import (
+	"crypto/ed25519"
+	"encoding/base64"
+	"testing"
+
+	"github.com/TankerHQ/identity-go/v3"
+	"github.com/TankerHQ/identity-go/v3/internal/app"
+)
+
+func tamperIdentity(id string, tamper func(*identity.SecretPermanentIdentity)) string {
+	parsed, err := identity.ParseIdentity(id)
+	if err != nil {
+		panic("error parsing identity")
+	}
+	secret := parsed.(*identity.SecretPermanentIdentity)
+	tamper(secret)
+	encoded, err := identity.Encode(secret)
+	if err != nil {
+		panic("error encoding identity")
+	}
+	return *encoded
+}
+
+func TestVerifyIdentity(t *testing.T) {
+	id, err := identity.Create(validConf, "userID")
+	if err != nil {
+		panic("error creating identity")
+	}
+
+	if err := identity.VerifyIdentity(validConf, *id, "userID"); err != nil {
+		t.Fatal("error verifying valid identity:", err)
+	}
+}
+
+func TestVerifyIdentity_Error(t *testing.T) {
+	id, err := identity.Create(validConf, "userID")
+	if err != nil {
+		panic("error creating identity")
+	}
+	other, err := identity.Create(validConf, "otherUserID")
+	if err != nil {
+		panic("error creating identity")
+	}
+	otherParsed, _ := identity.ParseIdentity(*other)
+	otherSecret := otherParsed.(*identity.SecretPermanentIdentity)
+
+	otherAppSecretRaw := ed25519.NewKeyFromSeed(byteArray(ed25519.SeedSize))
+	otherConf := identity.Config{
+		AppID:     base64.StdEncoding.EncodeToString(app.GetAppId(otherAppSecretRaw)),
+		AppSecret: base64.StdEncoding.EncodeToString(otherAppSecretRaw),
+	}
+
+	for _, conf := range badConfsVector {
+		t.Run(conf.desc, func(t *testing.T) {
+			if identity.VerifyIdentity(conf.config, *id, "userID") == nil {
+				t.Fatal("no error verifying identity")
+			}
+		})
+	}
+
+	vectors := []struct {
+		desc     string
+		config   identity.Config
+		identity string
+		userID   string
+	}{
+		{desc: "InvalidBase64", config: validConf, identity: notBase64Identity, userID: "userID"},
+		{desc: "WrongUserID", config: validConf, identity: *id, userID: "otherUserID"},
+		{desc: "WrongApp", config: otherConf, identity: *id, userID: "userID"},
+		{
+			desc:   "TamperedDelegationSignature",
+			config: validConf,
+			identity: tamperIdentity(*id, func(secret *identity.SecretPermanentIdentity) {
+				secret.DelegationSignature[0] ^= 1
+			}),
+			userID: "userID",
+		},
+		{
+			desc:   "SwappedEphemeralPrivateKey",
+			config: validConf,
+			identity: tamperIdentity(*id, func(secret *identity.SecretPermanentIdentity) {
+				secret.EphemeralPrivateSignatureKey = otherSecret.EphemeralPrivateSignatureKey
+			}),
+			userID: "userID",
+		},
+		{
+			desc:   "TamperedEphemeralPrivateKeySeed",
+			config: validConf,
+			identity: tamperIdentity(*id, func(secret *identity.SecretPermanentIdentity) {
+				secret.EphemeralPrivateSignatureKey[0] ^= 1
+			}),
+			userID: "userID",
+		},
+		{
+			desc:   "SwappedUserSecret",
+			config: validConf,
+			identity: tamperIdentity(*id, func(secret *identity.SecretPermanentIdentity) {
+				secret.UserSecret = otherSecret.UserSecret
+			}),
+			userID: "userID",
+		},
+		{
+			desc:   "TruncatedUserSecret",
+			config: validConf,
+			identity: tamperIdentity(*id, func(secret *identity.SecretPermanentIdentity) {
+				secret.UserSecret = secret.UserSecret[1:]
+			}),
+			userID: "userID",
+		},
+		{
+			desc:   "TruncatedSignature",
+			config: validConf,
+			identity: tamperIdentity(*id, func(secret *identity.SecretPermanentIdentity) {
+				secret.DelegationSignature = secret.DelegationSignature[1:]
+			}),
+			userID: "userID",
+		},
+	}
+
+	for _, vec := range vectors {
+		t.Run(vec.desc, func(t *testing.T) {
+			if identity.VerifyIdentity(vec.config, vec.identity, vec.userID) == nil {
+				t.Fatal("no error verifying identity")
+			}
+		})
+	}
+
+	t.Run("PublicIdentity", func(t *testing.T) {
+		pub, err := identity.GetPublicIdentity(*id)
+		if err != nil {
+			panic("error getting public identity")
+		}
+		if identity.VerifyIdentity(validConf, *pub, "userID") == nil {
+			t.Fatal("no error verifying public identity")
+		}
+	})
+
+	t.Run("ProvisionalIdentity", func(t *testing.T) {
+		prov, err := identity.CreateProvisional(validConf, "email", "userID")
+		if err != nil {
+			panic("error creating provisional identity")
+		}
+		if identity.VerifyIdentity(validConf, *prov, "userID") == nil {
+			t.Fatal("no error verifying provisional identity")
+		}
+	})
+}
+
+func tamperProvisionalIdentity(id string, tamper func(*identity.SecretProvisionalIdentity)) string {
+	parsed, err := identity.ParseIdentity(id)
+	if err != nil {
+		panic("error parsing identity")
+	}
+	secret := parsed.(*identity.SecretProvisionalIdentity)
+	tamper(secret)
+	encoded, err := identity.Encode(secret)
+	if err != nil {
+		panic("error encoding identity")
+	}
+	return *encoded
+}
+
+func TestValidateProvisionalIdentity(t *testing.T) {
+	for _, target := range validTargets {
+		t.Run(target, func(t *testing.T) {
+			prov, err := identity.CreateProvisional(validConf, target, "userID")
+			if err != nil {
+				panic("error creating provisional identity")
+			}
+			if err := identity.ValidateProvisionalIdentity(*prov); err != nil {
+				t.Fatal("error validating provisional identity:", err)
+			}
+		})
+	}
+}
+
+func TestValidateProvisionalIdentity_Error(t *testing.T) {
+	prov, err := identity.CreateProvisional(validConf, "email", "userID")
+	if err != nil {
+		panic("error creating provisional identity")
+	}
+	other, err := identity.CreateProvisional(validConf, "email", "otherUserID")
+	if err != nil {
+		panic("error creating provisional identity")
+	}
+	otherParsed, _ := identity.ParseIdentity(*other)
+	otherSecret := otherParsed.(*identity.SecretProvisionalIdentity)
+
+	vectors := []struct {
+		desc   string
+		tamper func(*identity.SecretProvisionalIdentity)
+	}{
+		{
+			desc: "SwappedPrivateSignatureKey",
+			tamper: func(secret *identity.SecretProvisionalIdentity) {
+				secret.PrivateSignatureKey = otherSecret.PrivateSignatureKey
+			},
+		},
+		{
+			desc: "SwappedPublicSignatureKey",
+			tamper: func(secret *identity.SecretProvisionalIdentity) {
+				secret.PublicSignatureKey = otherSecret.PublicSignatureKey
+			},
+		},
+		{
+			desc: "SwappedPrivateEncryptionKey",
+			tamper: func(secret *identity.SecretProvisionalIdentity) {
+				secret.PrivateEncryptionKey = otherSecret.PrivateEncryptionKey
+			},
+		},
+		{
+			desc: "SwappedPublicEncryptionKey",
+			tamper: func(secret *identity.SecretProvisionalIdentity) {
+				secret.PublicEncryptionKey = otherSecret.PublicEncryptionKey
+			},
+		},
+		{
+			desc: "TruncatedPrivateEncryptionKey",
+			tamper: func(secret *identity.SecretProvisionalIdentity) {
+				secret.PrivateEncryptionKey = secret.PrivateEncryptionKey[1:]
+			},
+		},
+		{
+			desc: "TruncatedPublicSignatureKey",
+			tamper: func(secret *identity.SecretProvisionalIdentity) {
+				secret.PublicSignatureKey = secret.PublicSignatureKey[1:]
+			},
+		},
+		{
+			desc: "TruncatedTrustchainID",
+			tamper: func(secret *identity.SecretProvisionalIdentity) {
+				secret.TrustchainID = secret.TrustchainID[1:]
+			},
+		},
+	}
+
+	for _, vec := range vectors {
+		t.Run(vec.desc, func(t *testing.T) {
+			tampered := tamperProvisionalIdentity(*prov, vec.tamper)
+			if identity.ValidateProvisionalIdentity(tampered) == nil {
+				t.Fatal("no error validating provisional identity")
+			}
+		})
+	}
+
+	t.Run("InvalidBase64", func(t *testing.T) {
+		if identity.ValidateProvisionalIdentity(notBase64Identity) == nil {
+			t.Fatal("no error validating provisional identity")
+		}
+	})
+
+	t.Run("PublicIdentity", func(t *testing.T) {
+		pub, err := identity.GetPublicIdentity(*prov)
+		if err != nil {
+			panic("error getting public identity")
+		}
+		if identity.ValidateProvisionalIdentity(*pub) == nil {
+			t.Fatal("no error validating public provisional identity")
+		}
+	})
+
+	t.Run("PermanentIdentity", func(t *testing.T) {
+		id, err := identity.Create(validConf, "userID")
+		if err != nil {
+			panic("error creating identity")
+		}
+		if identity.ValidateProvisionalIdentity(*id) == nil {
+			t.Fatal("no error validating permanent identity")
+		}
+	})
+}